@@ -0,0 +1,130 @@
+// Package cms keeps the registry of supported content management systems.
+// Each adapter package registers itself from its init function, so the
+// commands never need to know which CMSes exist.
+package cms
+
+import (
+	"cmsmgmt/database"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// CMS is one installation of a supported content management system.
+type CMS interface {
+	// Name returns the registry name, e.g. "wordpress".
+	Name() string
+	// DBConfig returns the connection settings from the CMS configuration.
+	DBConfig() (database.DBConfig, error)
+	// Version returns the installed CMS version.
+	Version() (string, error)
+	// ShowInfo prints general information about the installation.
+	ShowInfo() error
+}
+
+// Evidence rates how certain a detector is that a directory holds an
+// installation.
+type Evidence int
+
+const (
+	// NotFound means nothing of the CMS was found.
+	NotFound Evidence = iota
+	// CoreFiles means the CMS's own code was found but not its configuration,
+	// as on sites whose configuration file was moved elsewhere.
+	CoreFiles
+	// ConfigFound means the CMS's configuration file was found.
+	ConfigFound
+)
+
+// Detector reports how strongly the directory path looks like an installation.
+type Detector func(path string) Evidence
+
+// Factory opens the installation in the directory path.
+type Factory func(path string) (CMS, error)
+
+type adapter struct {
+	name    string
+	detect  Detector
+	factory Factory
+}
+
+var registry = map[string]adapter{}
+
+// Precedence decides between several CMSes detected in the same directory, as
+// happens when one is installed inside another. Listed names win in order;
+// any others follow alphabetically.
+var Precedence = []string{"wordpress", "joomla", "typo3", "mediawiki"}
+
+// Register adds a CMS adapter. It panics if name is already registered.
+func Register(name string, detect Detector, factory Factory) {
+	if _, dup := registry[name]; dup {
+		panic("cms: Register called twice for " + name)
+	}
+	registry[name] = adapter{name: name, detect: detect, factory: factory}
+}
+
+// Names returns the registered CMS names in detection order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	rank := func(name string) int {
+		for i, p := range Precedence {
+			if p == name {
+				return i
+			}
+		}
+		return len(Precedence)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := rank(names[i]), rank(names[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// Detect returns the names of the CMSes found in path with the strongest
+// evidence, in detection order. A configuration file outweighs core files, so
+// a Joomla site is not mistaken for WordPress because of a stray wp-load.php.
+// The first name is the installation commands should act on.
+func Detect(path string) []string {
+	var found []string
+	best := NotFound
+	for _, name := range Names() {
+		ev := registry[name].detect(path)
+		switch {
+		case ev == NotFound || ev < best:
+		case ev > best:
+			best = ev
+			found = []string{name}
+		default:
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// Exists is a helper for detectors that returns ev if any of the files exists
+// relative to path, and NotFound otherwise.
+func Exists(path string, ev Evidence, files ...string) Evidence {
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(path, f)); err == nil {
+			return ev
+		}
+	}
+	return NotFound
+}
+
+// Open returns the installation of the named CMS in path.
+func Open(name, path string) (CMS, error) {
+	a, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown CMS %q", name)
+	}
+	return a.factory(path)
+}
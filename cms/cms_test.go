@@ -0,0 +1,29 @@
+package cms_test
+
+import (
+	"cmsmgmt/cms"
+	"reflect"
+	"testing"
+
+	_ "cmsmgmt/joomla"
+	_ "cmsmgmt/mediawiki"
+	_ "cmsmgmt/typo3"
+	_ "cmsmgmt/wordpress"
+)
+
+// Installs whose configuration is not where the detectors look first.
+func TestDetectRelocated(t *testing.T) {
+	for _, tt := range []struct {
+		dir  string
+		want []string
+	}{
+		{"wp-config-above/public", []string{"wordpress"}}, // wp-config.php one level up
+		{"wp-core-only", []string{"wordpress"}},           // wp-config.php moved elsewhere
+		{"joomla-core-only", []string{"joomla"}},
+		{"joomla-stray-wp", []string{"joomla"}}, // the configuration outweighs wp-load.php
+	} {
+		if got := cms.Detect("testdata/" + tt.dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: detected %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+<?php
+final class Version {
+    const MAJOR_VERSION = 5;
+    const MINOR_VERSION = 1;
+    const PATCH_VERSION = 0;
+}
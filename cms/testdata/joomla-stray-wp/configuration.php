@@ -0,0 +1,9 @@
+<?php
+class JConfig {
+	public $dbtype = 'mysqli';
+	public $host = 'localhost';
+	public $user = 'joomla';
+	public $password = 'secret';
+	public $db = 'joomla';
+	public $dbprefix = 'jos_';
+}
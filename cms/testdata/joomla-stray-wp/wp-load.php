@@ -0,0 +1,2 @@
+<?php
+// left over from an old WordPress install
@@ -0,0 +1,2 @@
+<?php
+require_once dirname( __FILE__ ) . '/wp-config.php';
@@ -0,0 +1,2 @@
+<?php
+// loads the configuration kept outside the docroot
@@ -0,0 +1,7 @@
+<?php
+define( 'DB_NAME', 'wordpress' );
+define( 'DB_USER', 'wp_user' );
+define( 'DB_PASSWORD', 'secret' );
+define( 'DB_HOST', 'localhost' );
+$table_prefix = 'wp_';
+require_once __DIR__ . '/public/wp-settings.php';
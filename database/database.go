@@ -1,13 +1,25 @@
 package database
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
 // DBConfig holds the configuration for connecting to a database.
@@ -18,6 +30,387 @@ type DBConfig struct {
 	User     string
 	Password string
 	DBName   string
+
+	Charset   string // MySQL connection charset, defaults to utf8mb4
+	Collation string // optional MySQL connection collation
+	ParseTime bool   // scan DATE/DATETIME columns into time.Time (MySQL only)
+	TLS       bool   // encrypt the connection and verify the server certificate
+}
+
+// ConfigDiff is one field of two compared DBConfigs.
+type ConfigDiff struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+	Same  bool   `json:"same"`
+}
+
+// DiffConfigs compares a and b field by field, in the order of DBConfig.
+// Passwords are never returned, only whether they are the same. Host names
+// are compared case-insensitively.
+func DiffConfigs(a, b DBConfig) []ConfigDiff {
+	const hidden = "(hidden)"
+	return []ConfigDiff{
+		{"type", a.Type, b.Type, a.Type == b.Type},
+		{"host", a.Host, b.Host, strings.EqualFold(a.Host, b.Host)},
+		{"port", strconv.Itoa(a.Port), strconv.Itoa(b.Port), a.Port == b.Port},
+		{"user", a.User, b.User, a.User == b.User},
+		{"password", hidden, hidden, a.Password == b.Password},
+		{"dbName", a.DBName, b.DBName, a.DBName == b.DBName},
+		{"charset", a.Charset, b.Charset, a.Charset == b.Charset},
+		{"collation", a.Collation, b.Collation, a.Collation == b.Collation},
+		{"tls", strconv.FormatBool(a.TLS), strconv.FormatBool(b.TLS), a.TLS == b.TLS},
+	}
+}
+
+// DefaultCharset is the MySQL charset used when DBConfig.Charset is empty.
+const DefaultCharset = "utf8mb4"
+
+// groupConcatMaxLen is the group_concat_max_len of MySQL sessions, enough for
+// users in thousands of groups.
+const groupConcatMaxLen = 1 << 20
+
+// ReadOnly, when true, makes Begin and Exec refuse to run and opens
+// PostgreSQL sessions with read-only transactions.
+var ReadOnly bool
+
+// Strict, when true, turns warnings about inconsistent or partial data into errors.
+var Strict bool
+
+// Insecure, when true, accepts unencrypted connections to remote servers
+// without the warning, or in Strict mode the refusal, Connect gives otherwise.
+var Insecure bool
+
+// ConnectTimeout limits how long Connect waits for the server to answer, and
+// QueryTimeout how long a single query may take, reading its rows included.
+// Zero means no limit. The query limit is a context deadline the connections
+// Connect hands out add to every query, so it also covers the calls that take
+// no context; statements run with Exec are not limited, see timeoutConnector.
+var (
+	ConnectTimeout = 10 * time.Second
+	QueryTimeout   = 60 * time.Second
+)
+
+// LocalOnly, when true, makes Connect refuse any database server that is not
+// on this machine, including one reached through an SSH tunnel.
+var LocalOnly bool
+
+// Force, when true, lets changes through that a safety guard would refuse,
+// such as removing a site's last administrator. See Guard.
+var Force bool
+
+// Schema is the PostgreSQL schema that holds the CMS tables. Queries name it
+// explicitly instead of relying on the server's search_path.
+var Schema = "public"
+
+// Verbose, when true, makes Connect print the DSN it uses, password masked,
+// to stderr.
+var Verbose bool
+
+// Profile, when true, makes Phase print how long each step of a command took.
+var Profile bool
+
+// MaskEmails, when true, makes DisplayEmail mask the addresses it is given.
+var MaskEmails bool
+
+// Color, when true, makes Highlight add ANSI colors. It is only set for text
+// output to a terminal, and never with --no-color or NO_COLOR.
+var Color bool
+
+// AllowInvalidEmail, when true, makes ValidateEmail accept any address, for
+// sites whose legacy data has to be written back as it is.
+var AllowInvalidEmail bool
+
+// ErrReadOnly is returned for any attempted write while ReadOnly is set.
+var ErrReadOnly = errors.New("refusing to write: read-only mode is enabled")
+
+// ErrUserNotFound is wrapped by lookups of a named user that does not exist,
+// so bulk operations can skip such rows.
+var ErrUserNotFound = errors.New("user not found")
+
+// Warnf reports a problem that does not stop the command. The warning goes to
+// stderr so it never mixes with JSON or CSV output; in Strict mode it is
+// returned as an error instead.
+func Warnf(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if Strict {
+		return errors.New(msg)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+	return nil
+}
+
+// Phase reports on stderr, under Profile, that the step name took the time
+// since start. Call it as defer Phase("step", time.Now()) or right after the
+// step.
+func Phase(name string, start time.Time) {
+	if Profile {
+		fmt.Fprintf(os.Stderr, "profile: %-24s %v\n", name, time.Since(start).Round(time.Microsecond))
+	}
+}
+
+// Tolerate returns err in Strict mode and nil otherwise. It marks the places
+// where a failure only leaves the result incomplete, so interactive use can
+// carry on with what was read.
+func Tolerate(err error) error {
+	if Strict {
+		return err
+	}
+	return nil
+}
+
+// Guard refuses a change that would leave the site in an unsafe state. With
+// Force set the change goes ahead, after a loud warning on stderr.
+func Guard(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if !Force {
+		return fmt.Errorf("%s; pass --force to do it anyway", msg)
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: --force overrides a safety check: %s\n", msg)
+	return nil
+}
+
+// Highlight wraps s in the given ANSI color code when Color is set.
+func Highlight(s, code string) string {
+	if !Color {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// HighlightPrivileged marks an administrative role, such as a WordPress role
+// with manage_options or a Joomla group granted core.admin, in red.
+func HighlightPrivileged(role string) string { return Highlight(role, "31") }
+
+// DisplayEmail returns email as it should be shown to the user: unchanged, or
+// masked by MaskEmail when MaskEmails is set.
+func DisplayEmail(email string) string {
+	if MaskEmails {
+		return MaskEmail(email)
+	}
+	return email
+}
+
+// MaskEmail hides most of an e-mail address for sharing, keeping the first
+// and last character of the local part, the first character of the domain
+// and the top-level domain: john@example.com becomes j**n@e******.com.
+func MaskEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return maskTail([]rune(email), 1)
+	}
+
+	local := []rune(email[:at])
+	var masked string
+	if len(local) <= 2 {
+		masked = maskTail(local, 1)
+	} else {
+		masked = string(local[0]) + strings.Repeat("*", len(local)-2) + string(local[len(local)-1])
+	}
+
+	domain, tld := email[at+1:], ""
+	if dot := strings.LastIndex(domain, "."); dot > 0 {
+		domain, tld = domain[:dot], domain[dot:]
+	}
+	return masked + "@" + maskTail([]rune(domain), 1) + tld
+}
+
+// ValidateEmail checks that email is a bare address such as jane@example.com,
+// without a display name or angle brackets. Every e-mail write checks the new
+// address with it unless AllowInvalidEmail is set.
+func ValidateEmail(email string) error {
+	if AllowInvalidEmail {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid e-mail address %q", email)
+	}
+	return nil
+}
+
+// maskTail keeps the first keep runes of s and replaces the rest with '*'.
+func maskTail(s []rune, keep int) string {
+	if len(s) <= keep {
+		return strings.Repeat("*", len(s))
+	}
+	return string(s[:keep]) + strings.Repeat("*", len(s)-keep)
+}
+
+// BlockedFilter selects users by their blocked/disabled state.
+type BlockedFilter int
+
+const (
+	BlockedAny     BlockedFilter = iota // no filtering
+	BlockedOnly                         // only blocked users
+	BlockedExclude                      // only active users
+)
+
+// UserFilter narrows user listings. The zero value matches every user.
+type UserFilter struct {
+	Blocked BlockedFilter
+	NoRoles bool      // skip the role lookups, which are the slow part on large sites
+	Since   time.Time // only users registered at or after Since, if set
+	Before  time.Time // only users registered before Before, if set
+
+	EmailDomains []string // only users with an e-mail address on one of these domains, if set
+}
+
+// IsZero reports whether the filter matches every user.
+func (f UserFilter) IsZero() bool {
+	return f.Blocked == BlockedAny && !f.NoRoles && f.Since.IsZero() && f.Before.IsZero() && len(f.EmailDomains) == 0
+}
+
+// RegisteredConds returns the WHERE conditions and their arguments that limit
+// the registration time stored in column to the filter's Since/Before window.
+func (f UserFilter) RegisteredConds(column string) ([]string, []any) {
+	var conds []string
+	var args []any
+	if !f.Since.IsZero() {
+		conds = append(conds, column+" >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Before.IsZero() {
+		conds = append(conds, column+" < ?")
+		args = append(args, f.Before.UTC())
+	}
+	return conds, args
+}
+
+// EmailConds returns the WHERE condition and its arguments that limit the
+// e-mail address in column to the filter's domains, case-insensitively.
+func (f UserFilter) EmailConds(column string) ([]string, []any) {
+	if len(f.EmailDomains) == 0 {
+		return nil, nil
+	}
+	likes := make([]string, len(f.EmailDomains))
+	args := make([]any, len(f.EmailDomains))
+	for i, d := range f.EmailDomains {
+		likes[i] = "LOWER(" + column + ") LIKE ?"
+		args[i] = "%@" + EscapeLike(strings.ToLower(d))
+	}
+	return []string{"(" + strings.Join(likes, " OR ") + ")"}, args
+}
+
+// PoolConfig holds the connection pool limits applied to every database opened by Connect.
+type PoolConfig struct {
+	MaxOpen      int           // maximum open connections, 0 for unlimited
+	MaxIdle      int           // maximum idle connections kept in the pool
+	ConnLifetime time.Duration // maximum time a connection is reused, 0 for forever
+}
+
+// Pool is deliberately small so the tool can run next to a busy production
+// server. Some queries run while another result set is still open, so MaxOpen
+// must be at least 2.
+var Pool = PoolConfig{
+	MaxOpen:      4,
+	MaxIdle:      2,
+	ConnLifetime: 5 * time.Minute,
+}
+
+// Override, when set, is called by Connect before the DSN is built so that
+// command-line settings can take precedence over the parsed CMS configuration.
+var Override func(*DBConfig)
+
+// ClientDefaults holds the connection settings from the [client] section of a
+// MySQL option file such as ~/.my.cnf. Empty fields are left untouched by Apply.
+type ClientDefaults struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+}
+
+// ReadDefaultsFile parses the [client] section of a MySQL option file. Other
+// sections and unrelated options are ignored.
+func ReadDefaultsFile(path string) (ClientDefaults, error) {
+	var d ClientDefaults
+	f, err := os.Open(path)
+	if err != nil {
+		return d, err
+	}
+	defer f.Close()
+
+	inClient := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '!' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inClient = strings.EqualFold(strings.TrimSpace(line[1:len(line)-1]), "client")
+			continue
+		}
+		if !inClient {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+		value = unquoteOption(strings.TrimSpace(value))
+		switch key {
+		case "host":
+			d.Host = value
+		case "port":
+			if d.Port, err = strconv.Atoi(value); err != nil {
+				return d, fmt.Errorf("invalid port %q in %s", value, path)
+			}
+		case "user":
+			d.User = value
+		case "password":
+			d.Password = value
+		}
+	}
+	return d, sc.Err()
+}
+
+// unquoteOption strips the quotes around an option value and resolves the
+// escapes MySQL allows inside them.
+func unquoteOption(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		v = v[1 : len(v)-1]
+		return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\\`, `\`, `\"`, `"`, `\'`, `'`).Replace(v)
+	}
+	// unquoted values may carry a trailing comment
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v
+}
+
+// Apply overwrites the fields of cfg that are set in d.
+func (d ClientDefaults) Apply(cfg *DBConfig) {
+	if d.Host != "" {
+		cfg.Host = d.Host
+	}
+	if d.Port != 0 {
+		cfg.Port = d.Port
+	}
+	if d.User != "" {
+		cfg.User = d.User
+	}
+	if d.Password != "" {
+		cfg.Password = d.Password
+	}
+}
+
+// newConnector parses the DSN for the named driver, like sql.Open does.
+func newConnector(driverName, dsn string) (driver.Connector, error) {
+	switch driverName {
+	case "mysql":
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.NewConnector(cfg)
+	case "postgres":
+		return pq.NewConnector(dsn)
+	}
+	return nil, fmt.Errorf("unsupported database driver: %s", driverName)
 }
 
 // Connect establishes a connection to the database using the provided configuration.
@@ -25,35 +418,587 @@ func Connect(config DBConfig) (*sql.DB, error) {
 	var dsn string
 	var driverName string
 
+	if Override != nil {
+		Override(&config)
+	}
+
 	switch config.Type {
 	case "mysql", "mysqli":
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True",
-			config.User, config.Password, config.Host, config.Port, config.DBName)
+		dsn = mysqlDSN(config)
 		driverName = "mysql"
 	case "postgres":
-		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-			config.Host, config.Port, config.User, config.Password, config.DBName)
+		dsn = postgresDSN(config)
 		driverName = "postgres"
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", config.Type)
 	}
 
-	db, err := sql.Open(driverName, dsn)
+	if LocalOnly && (SSH.Host != "" || !isLocal(config.Host)) {
+		host := config.Host
+		if SSH.Host != "" {
+			host += " through " + SSH.Host
+		}
+		return nil, &ConnectError{Err: fmt.Errorf("refusing to connect to %s: only local database servers are allowed", host)}
+	}
+
+	// the SSH tunnel encrypts the way to the server, local sockets need nothing
+	if !config.TLS && !Insecure && SSH.Host == "" && !isLocal(config.Host) {
+		if err := Warnf("connecting to %s without TLS sends the credentials in the clear; use --db-tls, or --insecure to accept it", config.Host); err != nil {
+			return nil, &ConnectError{Err: err}
+		}
+	}
+
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "Connecting with DSN %s\n", maskedDSN(config))
+	}
+
+	var connector driver.Connector
+	var err error
+	if SSH.Host != "" {
+		connector, err = tunneledConnector(driverName, dsn)
+	} else {
+		connector, err = newConnector(driverName, dsn)
+	}
 	if err != nil {
+		return nil, &ConnectError{Err: err}
+	}
+	connector = timeoutConnector{connector}
+	if Transcript != nil {
+		connector = newTranscriptConnector(connector, config)
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(Pool.MaxOpen)
+	db.SetMaxIdleConns(Pool.MaxIdle)
+	db.SetConnMaxLifetime(Pool.ConnLifetime)
+
+	start := time.Now()
+	ctx := context.Background()
+	if ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ConnectTimeout)
+		defer cancel()
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		if unknownDatabase(err) {
+			err = fmt.Errorf("database %q does not exist on %s: %w", config.DBName, config.Host, err)
+		}
+		return nil, &ConnectError{Err: err}
+	}
+	Phase("connect", start)
+
+	trackDB(db)
+	return db, nil
+}
+
+// connLost lists the messages of errors that mean the connection to the
+// server dropped: MySQL's "server has gone away" (2006) and "lost connection"
+// (2013), the drivers' own bad and invalid connection errors, and the network
+// errors behind them. They are matched by text because callers often wrap
+// errors with %v.
+var connLost = []string{
+	"server has gone away", "Error 2006", "Error 2013", "Lost connection to MySQL server",
+	"invalid connection", "bad connection", "broken pipe", "connection reset by peer", "unexpected EOF",
+}
+
+// ConnectionLost reports whether err means the connection to the database
+// server was lost, so the statement may succeed on a new connection.
+func ConnectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	msg := err.Error()
+	for _, m := range connLost {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconnectAttempts is how often Reconnect tries to connect, pausing one,
+// then two seconds between the attempts.
+const reconnectAttempts = 3
+
+// Reconnect closes db, whose connection was lost, and connects again with
+// config, as Connect would. Under Verbose every attempt is reported on stderr.
+func Reconnect(db *sql.DB, config DBConfig) (*sql.DB, error) {
+	db.Close()
+	var err error
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		if Verbose {
+			fmt.Fprintf(os.Stderr, "Connection lost, reconnecting (attempt %d of %d)\n", attempt, reconnectAttempts)
+		}
+		var fresh *sql.DB
+		if fresh, err = Connect(config); err == nil {
+			return fresh, nil
+		}
+		if attempt < reconnectAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	return nil, fmt.Errorf("reconnect after %d attempts: %w", reconnectAttempts, err)
+}
+
+// unknownDatabase reports whether err is the server saying the database to
+// connect to does not exist: MySQL error 1049 or PostgreSQL SQLSTATE 3D000.
+func unknownDatabase(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == 1049
+	}
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		return pe.Code == "3D000"
+	}
+	return false
+}
+
+// isLocal reports whether host is this machine: empty, localhost, a loopback
+// address or a socket path.
+func isLocal(host string) bool {
+	host = bareHost(host)
+	if host == "" || strings.EqualFold(host, "localhost") || strings.HasPrefix(host, "/") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ConnectError is returned by Connect when the database cannot be opened or reached.
+type ConnectError struct {
+	Err error
+}
+
+func (e *ConnectError) Error() string { return e.Err.Error() }
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// NoTablesError is returned when the database holds none of the CMS's
+// tables, as with a database that was created but never installed into, or
+// one whose data was wiped or moved elsewhere. The connection itself worked.
+type NoTablesError struct {
+	CMS    string
+	DBName string
+	Config string // the configuration file naming the database, if known
+}
+
+func (e *NoTablesError) Error() string {
+	msg := fmt.Sprintf("connected to database %s, but it holds no %s tables", e.DBName, e.CMS)
+	if e.Config == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s; the site is not installed yet, its data was moved or deleted, or %s names the wrong database", msg, e.Config)
+}
+
+// HasTable reports whether table exists and can be read. It works the same
+// on every server and tells a missing table from an empty one.
+func HasTable(db *sql.DB, table string) bool {
+	var n int
+	return db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE 1 = 0").Scan(&n) == nil
+}
+
+// Writable returns ErrReadOnly when writes are disabled.
+func Writable() error {
+	if ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Begin starts a transaction for a mutation, refusing to do so in read-only mode.
+func Begin(db *sql.DB) (*sql.Tx, error) {
+	if err := Writable(); err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	trackTx(tx)
+	return tx, nil
+}
+
+// Exec runs a mutating statement outside a transaction, refusing to do so in read-only mode.
+func Exec(db *sql.DB, query string, args ...any) (sql.Result, error) {
+	if err := Writable(); err != nil {
 		return nil, err
 	}
+	return db.Exec(query, args...)
+}
+
+// serverVersions caches SELECT VERSION() per connection pool.
+var serverVersions sync.Map // map[*sql.DB]string
+
+// ServerVersion returns the database server version string, e.g.
+// "10.11.6-MariaDB-0+deb12u1" or "8.0.36". The value is cached per db.
+func ServerVersion(db *sql.DB) (string, error) {
+	if v, ok := serverVersions.Load(db); ok {
+		return v.(string), nil
+	}
+	var v string
+	if err := db.QueryRow("SELECT VERSION()").Scan(&v); err != nil {
+		return "", fmt.Errorf("failed to query server version: %v", err)
+	}
+	serverVersions.Store(db, v)
+	return v, nil
+}
+
+// ServerFlavor names the server product from a ServerVersion string.
+func ServerFlavor(version string) string {
+	lower := strings.ToLower(version)
+	switch {
+	case strings.Contains(lower, "mariadb"):
+		return "MariaDB"
+	case strings.HasPrefix(lower, "postgresql"):
+		return "PostgreSQL"
+	default:
+		return "MySQL"
+	}
+}
+
+// DescribeServer returns e.g. "MariaDB (10.11.6-MariaDB)" for display.
+func DescribeServer(db *sql.DB) string {
+	v, err := ServerVersion(db)
+	if err != nil {
+		return "unknown (" + err.Error() + ")"
+	}
+	return fmt.Sprintf("%s (%s)", ServerFlavor(v), v)
+}
+
+// FieldChange is a single field an interactive edit is about to change.
+type FieldChange struct {
+	Field  string
+	Old    string
+	New    string
+	Secret bool // show "(changed)" instead of the values
+}
+
+// ConfirmChanges prints changes as a before -> after diff and asks for a y/N
+// answer on r. It returns true without asking when assumeYes is set.
+func ConfirmChanges(r *bufio.Reader, changes []FieldChange, assumeYes bool) (bool, error) {
+	fmt.Println("Pending changes:")
+	for _, c := range changes {
+		if c.Secret {
+			fmt.Printf("  %-10s (changed)\n", c.Field)
+			continue
+		}
+		fmt.Printf("  %-10s %q -> %q\n", c.Field, c.Old, c.New)
+	}
+	if assumeYes {
+		return true, nil
+	}
+
+	fmt.Print("Apply these changes? [y/N]: ")
+	answer, err := r.ReadString('\n')
+	if err != nil && answer == "" {
+		return false, fmt.Errorf("read confirmation: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// RoleCount is the number of users holding a role (WordPress) or group (Joomla).
+type RoleCount struct {
+	Role  string `json:"role"`
+	Users int    `json:"users"`
+}
+
+// UserStats are the aggregate user numbers of one install.
+type UserStats struct {
+	Total   int         `json:"total"`
+	Blocked int         `json:"blocked"`
+	Recent  int         `json:"recent"` // registered at or after Since
+	Since   time.Time   `json:"since"`
+	Roles   []RoleCount `json:"roles"`
+}
+
+// IntegrityIssue counts the rows found by one consistency check. Fixable
+// issues are orphaned rows that can be deleted without losing user data.
+type IntegrityIssue struct {
+	Table   string `json:"table"`
+	Issue   string `json:"issue"`
+	Rows    int    `json:"rows"`
+	Fixable bool   `json:"fixable"`
+}
+
+// PrivilegedUser is an account with full administrative rights. LastLogin is
+// nil when the CMS has no record of a login.
+type PrivilegedUser struct {
+	ID        int64      `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	LastLogin *time.Time `json:"lastLogin"`
+}
+
+// UserMatch is an account found by a lookup in one install. LastLogin is nil
+// when the CMS has no record of a login.
+type UserMatch struct {
+	Prefix    string     `json:"prefix"`
+	ID        int64      `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Roles     []string   `json:"roles"`
+	LastLogin *time.Time `json:"lastLogin"`
+}
+
+// InfoReport is the general information about an install that info db
+// shows: where its database is and which table prefixes it holds.
+type InfoReport struct {
+	CMS      string   `json:"cms"`
+	DBType   string   `json:"dbType"`
+	DBName   string   `json:"dbName"`
+	DBUser   string   `json:"dbUser"`
+	DBHost   string   `json:"dbHost"`
+	DBPort   int      `json:"dbPort"`
+	Server   string   `json:"server"`
+	Prefixes []string `json:"prefixes"`
+	Version  string   `json:"version,omitempty"`
+}
+
+// MergeStep counts the rows one step of a user merge changed in a table.
+type MergeStep struct {
+	Table  string `json:"table"`
+	Action string `json:"action"`
+	Rows   int64  `json:"rows"`
+}
+
+// StaleUser is an active account without activity since a cutoff.
+// LastActive is the newest login the CMS recorded, or the registration when
+// there is none.
+type StaleUser struct {
+	ID         int64     `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	LastActive time.Time `json:"lastActive"`
+	Admin      bool      `json:"admin"`
+}
+
+// NullTime scans a DATE or DATETIME column whether the MySQL driver parses
+// it into a time.Time or, with --db-parse-time=false, hands over the text.
+// MySQL's zero date 0000-00-00 scans as the zero time, as the driver does.
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// Scan implements sql.Scanner.
+func (n *NullTime) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		*n = NullTime{}
+		return nil
+	case time.Time:
+		*n = NullTime{Time: v, Valid: true}
+		return nil
+	case []byte:
+		return n.parse(string(v))
+	case string:
+		return n.parse(v)
+	}
+	return fmt.Errorf("cannot scan %T into a time", v)
+}
+
+func (n *NullTime) parse(s string) error {
+	if strings.HasPrefix(s, "0000-00-00") {
+		*n = NullTime{Valid: true}
+		return nil
+	}
+	// taken as UTC like the driver's default loc; fractional seconds are
+	// accepted without being in the layout
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			*n = NullTime{Time: t, Valid: true}
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse %q as a date", s)
+}
+
+// TableStat holds the size of one table. Rows is the server's estimate for
+// InnoDB and PostgreSQL tables, not an exact count.
+type TableStat struct {
+	Name       string `json:"name"`
+	Rows       int64  `json:"rows"`
+	DataBytes  int64  `json:"dataBytes"`
+	IndexBytes int64  `json:"indexBytes"`
+	TotalBytes int64  `json:"totalBytes"`
+}
 
-	err = db.Ping()
+// TableStats returns the sizes of the tables whose names start with prefix,
+// which is matched literally and must include any separator ("wp_"), largest first.
+func TableStats(db *sql.DB, prefix string) ([]TableStat, error) {
+	version, err := ServerVersion(db)
 	if err != nil {
 		return nil, err
 	}
 
-	return db, nil
+	var query string
+	if ServerFlavor(version) == "PostgreSQL" {
+		query = `
+            SELECT relname, n_live_tup, pg_table_size(relid), pg_indexes_size(relid), pg_total_relation_size(relid)
+            FROM   pg_stat_user_tables
+            WHERE  relname LIKE $1 AND schemaname = $2`
+	} else {
+		query = `
+            SELECT table_name, COALESCE(table_rows, 0), COALESCE(data_length, 0), COALESCE(index_length, 0),
+                   COALESCE(data_length, 0) + COALESCE(index_length, 0)
+            FROM   information_schema.TABLES
+            WHERE  table_schema = DATABASE() AND table_type = 'BASE TABLE' AND table_name LIKE ?`
+	}
+
+	args := []any{EscapeLike(prefix) + "%"}
+	if ServerFlavor(version) == "PostgreSQL" {
+		args = append(args, Schema)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table sizes: %v", err)
+	}
+	defer rows.Close()
+
+	var stats []TableStat
+	for rows.Next() {
+		var t TableStat
+		if err := rows.Scan(&t.Name, &t.Rows, &t.DataBytes, &t.IndexBytes, &t.TotalBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		stats = append(stats, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TotalBytes != stats[j].TotalBytes {
+			return stats[i].TotalBytes > stats[j].TotalBytes
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats, nil
 }
 
-// IdentifyPrefixes identifies the prefixes used in the database tables for WordPress and Joomla.
-func IdentifyPrefixes(db *sql.DB, dbType string) ([]string, error) {
+// Qualify returns name, a table or table prefix, qualified with Schema on
+// PostgreSQL and unchanged on MySQL.
+func Qualify(dbType, name string) string {
+	if strings.ToLower(dbType) != "postgres" {
+		return name
+	}
+	return `"` + strings.ReplaceAll(Schema, `"`, `""`) + `".` + name
+}
+
+// EscapeLike escapes the LIKE wildcards in s so it matches literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// mysqlDSN builds a go-sql-driver DSN from the configuration.
+func mysqlDSN(config DBConfig) string {
+	charset := config.Charset
+	if charset == "" {
+		charset = DefaultCharset
+	}
+
+	params := url.Values{}
+	params.Set("charset", charset)
+	if config.Collation != "" {
+		params.Set("collation", config.Collation)
+	}
+	if config.ParseTime {
+		params.Set("parseTime", "True")
+	}
+	if config.TLS {
+		params.Set("tls", "true")
+	}
+	// the role lists are built with GROUP_CONCAT, which the server cuts at
+	// 1024 bytes by default; the driver sets this on every new connection
+	params.Set("group_concat_max_len", strconv.Itoa(groupConcatMaxLen))
+	if ConnectTimeout > 0 {
+		params.Set("timeout", ConnectTimeout.String())
+	}
+
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
+		config.User, config.Password, net.JoinHostPort(bareHost(config.Host), strconv.Itoa(config.Port)),
+		config.DBName, params.Encode())
+}
+
+// postgresDSN builds a lib/pq keyword/value DSN from the configuration.
+func postgresDSN(config DBConfig) string {
+	sslmode := "disable"
+	if config.TLS {
+		sslmode = "verify-full"
+	}
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		pqQuote(bareHost(config.Host)), config.Port, pqQuote(config.User), pqQuote(config.Password), pqQuote(config.DBName), sslmode)
+	if ReadOnly {
+		dsn += " default_transaction_read_only=on"
+	}
+	if ConnectTimeout > 0 {
+		// whole seconds only, and libpq treats 1 as 2
+		dsn += fmt.Sprintf(" connect_timeout=%d", max(2, int(math.Ceil(ConnectTimeout.Seconds()))))
+	}
+	return dsn
+}
+
+// maskedDSN returns the DSN Connect builds for config with the password
+// replaced by ****. It is built from a copy without the password, so the real
+// one cannot leak into logs whatever characters it contains.
+func maskedDSN(config DBConfig) string {
+	if config.Password != "" {
+		config.Password = "****"
+	}
+	if config.Type == "postgres" {
+		return postgresDSN(config)
+	}
+	return mysqlDSN(config)
+}
+
+// bareHost strips the brackets some configs put around IPv6 literals such as
+// [::1]; the DSN builders add them back where the driver needs them.
+func bareHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// pqQuote quotes a keyword/value DSN value when it is empty or contains
+// spaces, quotes or backslashes.
+func pqQuote(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// TableClass describes the tables attributed to one table prefix, without
+// its trailing underscore, by ClassifyTables.
+type TableClass struct {
+	Prefix string   `json:"prefix"`
+	Tables []string `json:"tables"`
+
+	// the marker tables IdentifyPrefixes looks for
+	Users      bool `json:"users"`
+	Posts      bool `json:"posts"`
+	UserMap    bool `json:"userUsergroupMap"`
+	UserGroups bool `json:"usergroups"`
+
+	// Detected reports whether IdentifyPrefixes accepts the prefix as an install.
+	Detected bool `json:"detected"`
+}
+
+// ClassifyTables lists the tables of the database grouped by the prefix they
+// belong to. Prefixes are taken from the marker tables <prefix>_users,
+// _posts, _user_usergroup_map and _usergroups; every other table is attributed
+// to the longest of them it starts with. Tables that match no prefix are
+// returned under the empty prefix. Names are compared case-insensitively, see
+// classifyTables. On PostgreSQL only the tables in Schema are considered.
+func ClassifyTables(db *sql.DB, dbType string) (map[string]TableClass, error) {
 	var query string
+	var args []any
 	switch strings.ToLower(dbType) {
 	case "mysql", "mysqli":
 		query = "SHOW TABLES"
@@ -61,81 +1006,112 @@ func IdentifyPrefixes(db *sql.DB, dbType string) ([]string, error) {
 		query = `
             SELECT tablename
             FROM   pg_catalog.pg_tables
-            WHERE  schemaname NOT IN ('pg_catalog', 'information_schema')`
+            WHERE  schemaname = $1`
+		args = append(args, Schema)
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", dbType)
 	}
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %v", err)
 	}
 	defer rows.Close()
 
-	// track which companion tables we have seen for each prefix
-	type flags struct {
-		users, posts, userMap, userGroups bool
-	}
-	seen := make(map[string]*flags)
-
+	var tables []string
 	for rows.Next() {
 		var tbl string
 		if err := rows.Scan(&tbl); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %v", err)
 		}
+		tables = append(tables, tbl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+	return classifyTables(tables), nil
+}
 
-		switch {
-		case strings.HasSuffix(tbl, "_users"):
-			p := strings.TrimSuffix(tbl, "_users")
-			f := seen[p]
-			if f == nil {
-				f = &flags{}
-				seen[p] = f
-			}
-			f.users = true
-
-		case strings.HasSuffix(tbl, "_posts"):
-			p := strings.TrimSuffix(tbl, "_posts")
-			f := seen[p]
-			if f == nil {
-				f = &flags{}
-				seen[p] = f
+// classifyTables groups the table names as ClassifyTables describes. Names
+// are matched case-insensitively: MySQL with lower_case_table_names=1, the
+// default on Windows and macOS, may return MyPrefix_Users as
+// myprefix_users, or mix both spellings. The map is keyed by the lowercased
+// prefix, while TableClass.Prefix keeps the casing of the prefix's _users
+// table, or of the first marker table seen, for the queries that follow.
+func classifyTables(tables []string) map[string]TableClass {
+	sort.Strings(tables)
+
+	// track which marker tables we have seen for each prefix
+	classes := make(map[string]TableClass)
+	for _, tbl := range tables {
+		lower := strings.ToLower(tbl)
+		for _, marker := range []string{"_users", "_posts", "_user_usergroup_map", "_usergroups"} {
+			if !strings.HasSuffix(lower, marker) || len(tbl) == len(marker) {
+				continue
 			}
-			f.posts = true
-
-		case strings.HasSuffix(tbl, "_user_usergroup_map"):
-			p := strings.TrimSuffix(tbl, "_user_usergroup_map")
-			f := seen[p]
-			if f == nil {
-				f = &flags{}
-				seen[p] = f
+			p := tbl[:len(tbl)-len(marker)]
+			key := strings.ToLower(p)
+			c := classes[key]
+			if c.Prefix == "" || marker == "_users" {
+				c.Prefix = p
 			}
-			f.userMap = true
-
-		case strings.HasSuffix(tbl, "_usergroups"):
-			p := strings.TrimSuffix(tbl, "_usergroups")
-			f := seen[p]
-			if f == nil {
-				f = &flags{}
-				seen[p] = f
+			switch marker {
+			case "_users":
+				c.Users = true
+			case "_posts":
+				c.Posts = true
+			case "_user_usergroup_map":
+				c.UserMap = true
+			case "_usergroups":
+				c.UserGroups = true
 			}
-			f.userGroups = true
+			classes[key] = c
+			break
 		}
 	}
 
-	var prefixes []string
-	for p, f := range seen {
-		if !f.users {
-			continue // never keep a prefix without _users
-		}
-
+	prefixes := make([]string, 0, len(classes))
+	for key, c := range classes {
+		// never keep a prefix without _users
 		// WordPress – users + posts
 		// Joomla    – users + (userMap or userGroups)
-		if f.posts || (f.userMap && f.userGroups) || (f.userMap || f.userGroups && f.posts) {
-			prefixes = append(prefixes, p)
+		c.Detected = c.Users && (c.Posts || (c.UserMap && c.UserGroups) || (c.UserMap || c.UserGroups && c.Posts))
+		classes[key] = c
+		prefixes = append(prefixes, key)
+	}
+	// longest first, so wp_2 gets wp_2_options before wp does
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, tbl := range tables {
+		lower := strings.ToLower(tbl)
+		owner := ""
+		for _, p := range prefixes {
+			if strings.HasPrefix(lower, p+"_") {
+				owner = p
+				break
+			}
 		}
+		c := classes[owner]
+		c.Tables = append(c.Tables, tbl)
+		classes[owner] = c
 	}
+	return classes
+}
 
+// IdentifyPrefixes identifies the prefixes used in the database tables for
+// WordPress and Joomla. On PostgreSQL only the tables in Schema are considered.
+func IdentifyPrefixes(db *sql.DB, dbType string) ([]string, error) {
+	classes, err := ClassifyTables(db, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []string
+	for _, c := range classes {
+		if c.Detected {
+			prefixes = append(prefixes, c.Prefix)
+		}
+	}
 	sort.Strings(prefixes) // deterministic order (optional)
 	return prefixes, nil
 }
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL with lower_case_table_names=1 may return one spelling for some
+// tables and the lowercased one for others.
+func TestClassifyTablesMixedCase(t *testing.T) {
+	classes := classifyTables([]string{"myprefix_posts", "MyPrefix_Users", "MyPrefix_options", "sessions"})
+	want := map[string]TableClass{
+		"myprefix": {
+			Prefix:   "MyPrefix",
+			Tables:   []string{"MyPrefix_Users", "MyPrefix_options", "myprefix_posts"},
+			Users:    true,
+			Posts:    true,
+			Detected: true,
+		},
+		"": {Tables: []string{"sessions"}},
+	}
+	if !reflect.DeepEqual(classes, want) {
+		t.Errorf("got %+v, want %+v", classes, want)
+	}
+}
+
+// Role lists are built with GROUP_CONCAT, so every MySQL session must raise
+// group_concat_max_len above the server's 1024-byte default.
+func TestMySQLDSNGroupConcat(t *testing.T) {
+	cfg, err := mysql.ParseDSN(mysqlDSN(DBConfig{Host: "localhost", Port: 3306, User: "wp", DBName: "wordpress"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Params["group_concat_max_len"], strconv.Itoa(groupConcatMaxLen); got != want {
+		t.Errorf("group_concat_max_len = %q, want %q", got, want)
+	}
+	if groupConcatMaxLen <= 1024 {
+		t.Errorf("groupConcatMaxLen %d does not raise the server default", groupConcatMaxLen)
+	}
+}
+
+func TestMySQLDSNAddress(t *testing.T) {
+	for _, tt := range []struct {
+		host string
+		port int
+		want string
+	}{
+		{"localhost", 3306, "localhost:3306"},
+		{"192.0.2.10", 3307, "192.0.2.10:3307"},
+		{"::1", 3306, "[::1]:3306"},
+		{"[::1]", 3306, "[::1]:3306"},
+		{"2001:db8::5", 3308, "[2001:db8::5]:3308"},
+		{"[2001:db8::5]", 3308, "[2001:db8::5]:3308"},
+	} {
+		cfg, err := mysql.ParseDSN(mysqlDSN(DBConfig{Host: tt.host, Port: tt.port, User: "wp", DBName: "wordpress"}))
+		if err != nil {
+			t.Errorf("%s: %v", tt.host, err)
+			continue
+		}
+		if cfg.Net != "tcp" || cfg.Addr != tt.want {
+			t.Errorf("%s: address %s(%s), want tcp(%s)", tt.host, cfg.Net, cfg.Addr, tt.want)
+		}
+	}
+}
+
+// A freshly created schema has no tables at all, which must come back as no
+// prefixes rather than an error, so the callers can report NoTablesError.
+func TestIdentifyPrefixesEmptySchema(t *testing.T) {
+	for _, dbType := range []string{"mysql", "postgres"} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := "SHOW TABLES"
+		if dbType == "postgres" {
+			query = "pg_catalog.pg_tables"
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(sqlmock.NewRows([]string{"table"}))
+
+		prefixes, err := IdentifyPrefixes(db, dbType)
+		if err != nil || len(prefixes) != 0 {
+			t.Errorf("%s: prefixes = %v, err = %v, want none", dbType, prefixes, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// open tracks the connection pools and transactions handed out by Connect and
+// Begin, so an interrupted command can be cleaned up from the signal handler
+// even while it is blocked, e.g. on a confirmation prompt.
+var open struct {
+	sync.Mutex
+	dbs []*sql.DB
+	txs []*sql.Tx
+}
+
+func trackDB(db *sql.DB) {
+	open.Lock()
+	defer open.Unlock()
+	open.dbs = append(open.dbs, db)
+}
+
+// trackTx remembers tx until Abort. Finished transactions stay in the list,
+// a command only ever begins a handful.
+func trackTx(tx *sql.Tx) {
+	open.Lock()
+	defer open.Unlock()
+	open.txs = append(open.txs, tx)
+}
+
+// Abort rolls back every transaction that is still open and closes all
+// connections. It returns how many transactions were rolled back. It is meant
+// for the interrupt handler; the command must not use the database afterwards.
+func Abort() int {
+	open.Lock()
+	defer open.Unlock()
+	n := 0
+	for _, tx := range open.txs {
+		// a committed or rolled back transaction reports ErrTxDone
+		if tx.Rollback() == nil {
+			n++
+		}
+	}
+	for _, db := range open.dbs {
+		db.Close()
+	}
+	open.txs, open.dbs = nil, nil
+	return n
+}
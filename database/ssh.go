@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHTunnel describes a bastion host that database connections are dialed
+// through, for servers that are not reachable directly.
+type SSHTunnel struct {
+	Host       string // host or host:port of the bastion, port 22 by default
+	User       string
+	KeyFile    string // private key; the ssh-agent is used when empty
+	KnownHosts string // known_hosts file the bastion's key is checked against
+}
+
+// SSH, when Host is set, makes Connect open an SSH connection to the bastion
+// and dial the database through it. The tunnel is closed with the *sql.DB.
+var SSH SSHTunnel
+
+// dial connects to the bastion.
+func (t SSHTunnel) dial() (*ssh.Client, error) {
+	var auth ssh.AuthMethod
+	if t.KeyFile != "" {
+		key, err := os.ReadFile(t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse SSH key %s (use ssh-agent for passphrase-protected keys): %w", t.KeyFile, err)
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("no SSH key given and no ssh-agent running (SSH_AUTH_SOCK is not set)")
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("connect to ssh-agent: %w", err)
+		}
+		auth = ssh.PublicKeysCallback(agent.NewClient(conn).Signers)
+	}
+
+	knownHosts := t.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("locate known_hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+
+	addr := t.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(bareHost(addr), "22")
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            t.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeys,
+		Timeout:         15 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SSH connection to %s: %w", addr, err)
+	}
+	return client, nil
+}
+
+// tunneledConnector returns a connector like newConnector, but with every
+// connection dialed through the SSH bastion.
+func tunneledConnector(driverName, dsn string) (driver.Connector, error) {
+	client, err := SSH.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	var connector driver.Connector
+	switch driverName {
+	case "mysql":
+		var cfg *mysql.Config
+		if cfg, err = mysql.ParseDSN(dsn); err == nil {
+			cfg.DialFunc = func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return client.DialContext(ctx, "tcp", addr)
+			}
+			connector, err = mysql.NewConnector(cfg)
+		}
+	case "postgres":
+		var c *pq.Connector
+		if c, err = pq.NewConnector(dsn); err == nil {
+			c.Dialer(sshDialer{client})
+			connector = c
+		}
+	default:
+		err = fmt.Errorf("SSH tunnels are not supported for %s", driverName)
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return tunnelConnector{connector, client}, nil
+}
+
+// tunnelConnector closes the SSH connection when the *sql.DB is closed.
+type tunnelConnector struct {
+	driver.Connector
+	client *ssh.Client
+}
+
+func (c tunnelConnector) Close() error { return c.client.Close() }
+
+// sshDialer adapts an SSH client to the dialer interfaces of lib/pq.
+type sshDialer struct {
+	client *ssh.Client
+}
+
+func (d sshDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.client.Dial(network, addr)
+}
+
+func (d sshDialer) DialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.client.DialContext(ctx, network, addr)
+}
+
+func (d sshDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.client.DialContext(ctx, network, addr)
+}
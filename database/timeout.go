@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// timeoutConnector bounds every query its connections run by QueryTimeout,
+// counted until the rows are closed. Exec is left alone: DDL and bulk writes
+// may legitimately run longer than any read, and cutting one off halfway
+// leaves MyISAM tables half changed.
+type timeoutConnector struct {
+	driver.Connector
+}
+
+func (c timeoutConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutConn{conn}, nil
+}
+
+// Close closes the wrapped connector if it needs it, e.g. an SSH tunnel.
+func (c timeoutConnector) Close() error {
+	if cl, ok := c.Connector.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// withQueryTimeout returns ctx limited by QueryTimeout, if one is set.
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, QueryTimeout)
+}
+
+// timedOut names --query-timeout in the error of a query that ran out of it.
+func timedOut(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("query did not finish within --query-timeout %s: %w", QueryTimeout, err)
+	}
+	return err
+}
+
+// timeoutConn applies the query timeout and passes everything else on.
+type timeoutConn struct {
+	driver.Conn
+}
+
+func (c *timeoutConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		cancel()
+		return nil, timedOut(ctx, err)
+	}
+	return &timeoutRows{rows, ctx, cancel}, nil
+}
+
+func (c *timeoutConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *timeoutConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutStmt{stmt, c.Conn}, nil
+}
+
+func (c *timeoutConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *timeoutConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timeoutConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timeoutConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *timeoutConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// timeoutStmt is a prepared statement of a timeoutConn. The MySQL driver
+// prepares every query with arguments, so these need the timeout as well.
+type timeoutStmt struct {
+	driver.Stmt
+	conn driver.Conn
+}
+
+func (s *timeoutStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("driver statement cannot take a context")
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	rows, err := q.QueryContext(ctx, args)
+	if err != nil {
+		cancel()
+		return nil, timedOut(ctx, err)
+	}
+	return &timeoutRows{rows, ctx, cancel}, nil
+}
+
+func (s *timeoutStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("driver statement cannot take a context")
+	}
+	return e.ExecContext(ctx, args)
+}
+
+// CheckNamedValue uses the checker of the statement, or else that of the
+// connection, which database/sql would have used without the wrapper.
+func (s *timeoutStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	if ch, ok := s.conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// timeoutRows keeps the query's deadline running while the rows are read.
+type timeoutRows struct {
+	driver.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == io.EOF {
+		return err
+	}
+	return timedOut(r.ctx, err)
+}
+
+func (r *timeoutRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	db, mock, err := sqlmock.NewWithDSN(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	raw, err := db.Driver().Open(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &timeoutConn{raw}
+	saved := QueryTimeout
+	QueryTimeout = 20 * time.Millisecond
+	defer func() { QueryTimeout = saved }()
+	ctx := context.Background()
+
+	mock.ExpectQuery("SELECT SLEEP").WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	if _, err := conn.QueryContext(ctx, "SELECT SLEEP(1)", nil); err == nil || !strings.Contains(err.Error(), "--query-timeout") {
+		t.Errorf("slow query: err = %v, want the query timeout", err)
+	}
+
+	// writes may take longer
+	mock.ExpectExec("ALTER TABLE").WillDelayFor(50 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, err := conn.ExecContext(ctx, "ALTER TABLE wp_users ADD INDEX (user_email)", nil); err != nil {
+		t.Errorf("slow statement: %v", err)
+	}
+
+	// the deadline runs until the rows are closed, not just until they arrive
+	mock.ExpectQuery("SELECT ID").WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(1).AddRow(2))
+	rows, err := conn.QueryContext(ctx, "SELECT ID FROM wp_users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	tr := rows.(*timeoutRows)
+	if _, ok := tr.ctx.Deadline(); !ok || tr.ctx.Err() != nil {
+		t.Errorf("deadline ended before the rows were read: %v", tr.ctx.Err())
+	}
+	rows.Close()
+	if tr.ctx.Err() == nil {
+		t.Error("closing the rows did not release the deadline")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,475 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcript, when set, makes Connect hand out connections that write every
+// statement passed to Exec to it as plain SQL, with the arguments filled in,
+// instead of running it. Queries still go to the server, so the statements
+// are the ones the command would have executed.
+var Transcript io.Writer
+
+// transcriptMu serialises writes to Transcript from concurrent connections.
+var transcriptMu sync.Mutex
+
+func transcribe(format string, args ...any) error {
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+	_, err := fmt.Fprintf(Transcript, format, args...)
+	return err
+}
+
+// transcriptConnector wraps the connections of a connector and writes the
+// header naming the target database the first time one is opened.
+type transcriptConnector struct {
+	driver.Connector
+	postgres bool
+	header   string
+	once     *sync.Once
+}
+
+func newTranscriptConnector(c driver.Connector, config DBConfig) transcriptConnector {
+	port := ""
+	if config.Port != 0 {
+		port = ":" + strconv.Itoa(config.Port)
+	}
+	header := fmt.Sprintf("-- cmsmgmt SQL transcript\n-- target: %s database %s on %s%s\n-- created: %s\n-- the statements below were NOT executed\n\n",
+		config.Type, config.DBName, config.Host, port, time.Now().Format(time.RFC3339))
+	return transcriptConnector{c, config.Type == "postgres", header, new(sync.Once)}
+}
+
+func (c transcriptConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var herr error
+	c.once.Do(func() { herr = transcribe("%s", c.header) })
+	if herr != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write SQL transcript: %w", herr)
+	}
+	return &transcriptConn{conn, c.postgres}, nil
+}
+
+// Close closes the wrapped connector if it needs it, e.g. an SSH tunnel.
+func (c transcriptConnector) Close() error {
+	if cl, ok := c.Connector.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// transcriptConn records Exec calls and passes everything else on. It
+// implements ExecerContext so database/sql never prepares a statement that
+// would then run through the real connection.
+type transcriptConn struct {
+	driver.Conn
+	postgres bool
+}
+
+func (c *transcriptConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	stmt := interpolate(query, args, c.postgres)
+	if err := transcribe("%s;\n", stmt); err != nil {
+		return nil, fmt.Errorf("write SQL transcript: %w", err)
+	}
+	res := &transcriptResult{rows: -1, postgres: c.postgres}
+	if count, ok := countStatement(stmt, c.postgres); ok && !refersToInsertID(args) {
+		n, err := c.count(ctx, count)
+		if err != nil {
+			return nil, fmt.Errorf("count rows for SQL transcript: %w", err)
+		}
+		res.rows = n
+	} else if n := insertedRows(stmt, c.postgres); n > 0 {
+		res.rows, res.insert = n, true
+	}
+	return res, nil
+}
+
+// count runs a SELECT COUNT(*) built by countStatement on the real connection.
+func (c *transcriptConn) count(ctx context.Context, query string) (int64, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return 0, fmt.Errorf("driver cannot run queries directly")
+	}
+	rows, err := q.QueryContext(ctx, query, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return 0, err
+	}
+	switch n := dest[0].(type) {
+	case int64:
+		return n, nil
+	case []byte:
+		return strconv.ParseInt(string(n), 10, 64)
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf("unexpected count %v", dest[0])
+}
+
+func (c *transcriptConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *transcriptConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *transcriptConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	begin := "START TRANSACTION;\n"
+	if c.postgres {
+		begin = "BEGIN;\n"
+	}
+	if err := transcribe("%s", begin); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("write SQL transcript: %w", err)
+	}
+	return transcriptTx{tx}, nil
+}
+
+func (c *transcriptConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *transcriptConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *transcriptConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *transcriptConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// transcriptTx ends the real transaction, which only ever read, and records
+// the outcome so a failed operation is not applied from the file either.
+type transcriptTx struct {
+	tx driver.Tx
+}
+
+func (t transcriptTx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	return transcribe("COMMIT;\n\n")
+}
+
+func (t transcriptTx) Rollback() error {
+	if err := t.tx.Rollback(); err != nil {
+		return err
+	}
+	return transcribe("ROLLBACK;\n\n")
+}
+
+// transcriptResult reports what a statement that was not executed would have
+// done. UPDATE and DELETE count the rows they match on the server; an INSERT
+// counts its rows and hands out a placeholder ID, see LastInsertId.
+type transcriptResult struct {
+	rows     int64 // -1 when unknown
+	insert   bool
+	postgres bool
+	id       int64
+}
+
+func (r *transcriptResult) RowsAffected() (int64, error) {
+	if r.rows < 0 {
+		return 0, errors.New("the number of changed rows is not known for a statement in a SQL transcript")
+	}
+	return r.rows, nil
+}
+
+// LastInsertId stores the ID the server will assign in a session variable,
+// @cmsmgmt_id_N for MySQL and the psql variable :cmsmgmt_id_N for
+// PostgreSQL, and returns a placeholder that later statements write as that
+// variable instead of a literal.
+func (r *transcriptResult) LastInsertId() (int64, error) {
+	if !r.insert {
+		return 0, errors.New("the statement in the SQL transcript inserts no row")
+	}
+	if r.id != 0 {
+		return r.id, nil
+	}
+	insertIDs.Lock()
+	defer insertIDs.Unlock()
+	n := len(insertIDs.names) + 1
+	name := fmt.Sprintf("@cmsmgmt_id_%d", n)
+	capture := fmt.Sprintf("SET %s = LAST_INSERT_ID();\n", name)
+	if r.postgres {
+		name = fmt.Sprintf(":cmsmgmt_id_%d", n)
+		capture = fmt.Sprintf("SELECT lastval() AS cmsmgmt_id_%d \\gset\n", n)
+	}
+	if err := transcribe("%s", capture); err != nil {
+		return 0, fmt.Errorf("write SQL transcript: %w", err)
+	}
+	r.id = insertIDBase - int64(n)
+	if insertIDs.names == nil {
+		insertIDs.names = make(map[int64]string)
+	}
+	insertIDs.names[r.id] = name
+	return r.id, nil
+}
+
+// insertIDBase is far below any real ID, so placeholders never clash with one.
+const insertIDBase = -1 << 40
+
+// insertIDs maps the placeholders LastInsertId returned to their variables.
+var insertIDs struct {
+	sync.Mutex
+	names map[int64]string
+}
+
+// InsertID formats an ID a command got back from an INSERT: the transcript
+// variable holding it, or the number itself when the statement ran.
+func InsertID(id int64) string {
+	insertIDs.Lock()
+	defer insertIDs.Unlock()
+	if name, ok := insertIDs.names[id]; ok {
+		return name
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// refersToInsertID reports whether an argument is an ID placeholder, which the
+// server cannot resolve before the transcript is applied.
+func refersToInsertID(args []driver.NamedValue) bool {
+	insertIDs.Lock()
+	defer insertIDs.Unlock()
+	for _, a := range args {
+		if id, ok := a.Value.(int64); ok {
+			if _, ok := insertIDs.names[id]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countStatement turns a single-table UPDATE or DELETE, or an INSERT ...
+// SELECT, with its arguments filled in, into a SELECT COUNT(*) of the rows it
+// would change.
+func countStatement(stmt string, postgres bool) (string, bool) {
+	fields := strings.Fields(stmt)
+	if len(fields) < 2 {
+		return "", false
+	}
+	var table string
+	switch {
+	case strings.EqualFold(fields[0], "INSERT"):
+		if i := topLevelKeyword(stmt, "SELECT", postgres); i >= 0 {
+			return "SELECT COUNT(*) FROM (" + stmt[i:] + ") AS inserted", true
+		}
+		return "", false
+	case strings.EqualFold(fields[0], "UPDATE") && len(fields) > 2 && strings.EqualFold(fields[2], "SET"):
+		table = fields[1]
+	case strings.EqualFold(fields[0], "DELETE") && len(fields) > 2 && strings.EqualFold(fields[1], "FROM"):
+		if len(fields) > 3 && !strings.EqualFold(fields[3], "WHERE") {
+			return "", false // multi-table form or USING
+		}
+		table = fields[2]
+	default:
+		return "", false
+	}
+	where := ""
+	if i := topLevelKeyword(stmt, "WHERE", postgres); i >= 0 {
+		where = " " + stmt[i:]
+	}
+	return "SELECT COUNT(*) FROM " + table + where, true
+}
+
+// insertedRows counts the row tuples of an INSERT ... VALUES statement, or
+// returns 0 for anything else.
+func insertedRows(stmt string, postgres bool) int64 {
+	fields := strings.Fields(stmt)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "INSERT") {
+		return 0
+	}
+	i := topLevelKeyword(stmt, "VALUES", postgres)
+	if i < 0 {
+		return 0
+	}
+	var n int64
+	scanTopLevel(stmt[i:], postgres, func(_ int, ch byte, depth int) bool {
+		if ch == '(' && depth == 0 {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// topLevelKeyword returns the index of the first keyword in stmt outside
+// quotes and parentheses, or -1.
+func topLevelKeyword(stmt, keyword string, postgres bool) int {
+	found := -1
+	scanTopLevel(stmt, postgres, func(i int, _ byte, depth int) bool {
+		if depth == 0 && i > 0 && isSpace(stmt[i-1]) && len(stmt)-i >= len(keyword) &&
+			strings.EqualFold(stmt[i:i+len(keyword)], keyword) &&
+			(i+len(keyword) == len(stmt) || isSpace(stmt[i+len(keyword)])) {
+			found = i
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// scanTopLevel calls fn for every byte of stmt outside quoted strings and
+// identifiers, with the parenthesis depth before that byte, until fn returns
+// false. Backslashes escape inside MySQL strings only.
+func scanTopLevel(stmt string, postgres bool, fn func(i int, ch byte, depth int) bool) {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(stmt); i++ {
+		ch := stmt[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '\'' && !postgres {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+			continue
+		}
+		if !fn(i, ch, depth) {
+			return
+		}
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+}
+
+func isSpace(ch byte) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+
+// interpolate replaces the placeholders in query, ? for MySQL and $n for
+// PostgreSQL, with the arguments as SQL literals. Placeholders inside quoted
+// strings and identifiers are left alone.
+func interpolate(query string, args []driver.NamedValue, postgres bool) string {
+	var b strings.Builder
+	next := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else if ch == '\\' && quote != '`' && !postgres && i+1 < len(query) {
+				b.WriteByte(ch)
+				i++
+				ch = query[i]
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?' && !postgres:
+			if next < len(args) {
+				b.WriteString(sqlLiteral(args[next].Value, postgres))
+				next++
+				continue
+			}
+		case ch == '$' && postgres:
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if n, err := strconv.Atoi(query[i+1 : j]); err == nil && n >= 1 && n <= len(args) {
+				b.WriteString(sqlLiteral(args[n-1].Value, postgres))
+				i = j - 1
+				continue
+			}
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+var (
+	mysqlQuoter    = strings.NewReplacer(`\`, `\\`, `'`, `''`, "\x00", `\0`)
+	postgresQuoter = strings.NewReplacer(`'`, `''`)
+)
+
+// sqlLiteral formats an argument as a literal of the given dialect.
+func sqlLiteral(v driver.Value, postgres bool) string {
+	quote := func(s string) string {
+		if postgres {
+			return "'" + postgresQuoter.Replace(s) + "'"
+		}
+		return "'" + mysqlQuoter.Replace(s) + "'"
+	}
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return InsertID(v)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case []byte:
+		if postgres {
+			return `'\x` + hex.EncodeToString(v) + `'::bytea`
+		}
+		return "X'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return quote(v.Format("2006-01-02 15:04:05.999999"))
+	case string:
+		return quote(v)
+	}
+	return quote(fmt.Sprint(v))
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// transcriptMock returns a transcript connection over a sqlmock connection
+// and the buffer the statements go to.
+func transcriptMock(t *testing.T, postgres bool) (*transcriptConn, sqlmock.Sqlmock, *bytes.Buffer) {
+	t.Helper()
+	dsn := fmt.Sprintf("%s/%v", t.Name(), postgres)
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	conn, err := db.Driver().Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	Transcript = &buf
+	insertIDs.names = nil
+	t.Cleanup(func() { Transcript = nil })
+	return &transcriptConn{conn, postgres}, mock, &buf
+}
+
+func args(values ...driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(values))
+	for i, v := range values {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+func TestTranscriptInsertID(t *testing.T) {
+	for _, tt := range []struct {
+		postgres      bool
+		capture, meta string
+	}{
+		{false, "SET @cmsmgmt_id_1 = LAST_INSERT_ID();\n",
+			"INSERT INTO wp_usermeta (user_id, meta_key) VALUES (@cmsmgmt_id_1, 'nickname');\n"},
+		{true, "SELECT lastval() AS cmsmgmt_id_1 \\gset\n",
+			"INSERT INTO wp_usermeta (user_id, meta_key) VALUES (:cmsmgmt_id_1, 'nickname');\n"},
+	} {
+		c, mock, buf := transcriptMock(t, tt.postgres)
+		ctx := context.Background()
+		insert, meta := "INSERT INTO wp_users (user_login) VALUES (?)", "INSERT INTO wp_usermeta (user_id, meta_key) VALUES (?, ?)"
+		if tt.postgres {
+			insert, meta = "INSERT INTO wp_users (user_login) VALUES ($1)", "INSERT INTO wp_usermeta (user_id, meta_key) VALUES ($1, $2)"
+		}
+		res, err := c.ExecContext(ctx, insert, args("jdoe"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := res.LastInsertId(); again != id {
+			t.Errorf("second LastInsertId = %d, want %d", again, id)
+		}
+		if _, err := c.ExecContext(ctx, meta, args(id, "nickname")); err != nil {
+			t.Fatal(err)
+		}
+		want := "INSERT INTO wp_users (user_login) VALUES ('jdoe');\n" + tt.capture + tt.meta
+		if buf.String() != want {
+			t.Errorf("postgres=%v: transcript\n%s\nwant\n%s", tt.postgres, buf, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTranscriptRowsAffected(t *testing.T) {
+	c, mock, buf := transcriptMock(t, false)
+	ctx := context.Background()
+	for _, tt := range []struct {
+		stmt  string
+		args  []driver.NamedValue
+		count string // the query counting the rows, "" when none is made
+		want  int64  // -1 when unknown
+	}{
+		{"UPDATE wp_users SET user_login = ? WHERE user_login = ?", args("a WHERE b", "jdoe"),
+			"SELECT COUNT(*) FROM wp_users WHERE user_login = 'jdoe'", 1},
+		{"DELETE FROM wp_usermeta\n\t\tWHERE user_id NOT IN (SELECT ID FROM wp_users)", nil,
+			"SELECT COUNT(*) FROM wp_usermeta WHERE user_id NOT IN (SELECT ID FROM wp_users)", 4},
+		{"INSERT INTO jos_user_usergroup_map (user_id, group_id) SELECT ?, group_id FROM jos_user_usergroup_map WHERE user_id = ?", args(int64(3), int64(5)),
+			"SELECT COUNT(*) FROM (SELECT 3, group_id FROM jos_user_usergroup_map WHERE user_id = 5) AS inserted", 2},
+		{"INSERT INTO jos_user_usergroup_map (user_id, group_id) VALUES (?, ?), (?, ?)", args(int64(3), int64(2), int64(3), int64(4)), "", 2},
+		{"DELETE m FROM wp_usermeta m LEFT JOIN wp_users u ON u.ID = m.user_id WHERE u.ID IS NULL", nil, "", -1},
+	} {
+		if tt.count != "" {
+			mock.ExpectQuery(regexp.QuoteMeta(tt.count)).
+				WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(tt.want))
+		}
+		res, err := c.ExecContext(ctx, tt.stmt, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := res.RowsAffected()
+		switch {
+		case tt.want < 0 && err == nil:
+			t.Errorf("%s: RowsAffected = %d, want an error", tt.stmt, n)
+		case tt.want >= 0 && (err != nil || n != tt.want):
+			t.Errorf("%s: RowsAffected = %d, %v, want %d", tt.stmt, n, err, tt.want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if strings.Contains(buf.String(), "COUNT") {
+		t.Errorf("count queries were written to the transcript:\n%s", buf)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cmsmgmt/database"
+	"cmsmgmt/joomla"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes returned by the tool. Scripts can rely on these staying stable.
+const (
+	exitFailure     = 1   // any error without a more specific code
+	exitUsage       = 2   // invalid flags or arguments
+	exitNoCMS       = 3   // no supported CMS found at --path
+	exitConnect     = 4   // the database could not be reached
+	exitReadOnly    = 5   // a write was refused because of --read-only
+	exitUnsupported = 6   // the command is not available for the detected CMS
+	exitNoTables    = 7   // the database holds none of the CMS's tables
+	exitInterrupted = 130 // stopped by Ctrl-C, like a shell reports SIGINT
+)
+
+// runState records what was learned about the install while a command ran,
+// so errors can be reported with that context.
+var runState struct {
+	cms    string
+	prefix string
+}
+
+// exitError attaches an exit code to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withCode(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// unsupported reports that what is not available for cmsType.
+func unsupported(what, cmsType string) error {
+	return withCode(exitUnsupported, fmt.Errorf("%s is not supported for %s", what, cmsType))
+}
+
+// exitCode maps err to the process exit code.
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	var ce *database.ConnectError
+	if errors.As(err, &ce) {
+		return exitConnect
+	}
+	if errors.Is(err, database.ErrReadOnly) {
+		return exitReadOnly
+	}
+	var nt *database.NoTablesError
+	if errors.As(err, &nt) {
+		return exitNoTables
+	}
+	return exitFailure
+}
+
+// reportError prints err for cmd, as JSON with --json-errors, and returns the exit code.
+func reportError(cmd *cobra.Command, err error) int {
+	code := exitCode(err)
+	if !jsonErrors {
+		log.Printf("Error: %v", err)
+		return code
+	}
+
+	command := ""
+	if cmd != nil {
+		command = cmd.CommandPath()
+	}
+	if encErr := json.NewEncoder(os.Stderr).Encode(struct {
+		Error   string `json:"error"`
+		Code    int    `json:"code"`
+		Command string `json:"command"`
+		CMS     string `json:"cms,omitempty"`
+		Prefix  string `json:"prefix,omitempty"`
+	}{err.Error(), code, command, runState.cms, runState.prefix}); encErr != nil {
+		log.Printf("Error: %v", err)
+	}
+	return code
+}
+
+// requireCMS detects the CMS at --path and records it for error reports.
+func requireCMS() (string, error) {
+	start := time.Now()
+	cmsType, err := detectCMS()
+	database.Phase("detect", start)
+	if err != nil {
+		return "", err
+	}
+	if cmsType == "" {
+		return "", withCode(exitNoCMS, fmt.Errorf("unsupported or no CMS detected at %q", cmsPath))
+	}
+	runState.cms = cmsType
+	return cmsType, nil
+}
+
+// processJoomla connects to the Joomla database and records the prefix in use,
+// which is the configured one unless --prefix names another detected install.
+func processJoomla() (*sql.DB, database.DBConfig, string, error) {
+	db, cfg, prefix, err := joomla.ProcessJoomla(cmsPath)
+	if err != nil {
+		return nil, cfg, "", err
+	}
+	if tablePrefix != "" {
+		prefixes, err := joomla.IdentifyPrefixes(db, cfg.Type)
+		if err != nil {
+			db.Close()
+			return nil, cfg, "", fmt.Errorf("failed to identify Joomla prefixes: %w", err)
+		}
+		if prefix, err = pickPrefix(prefixes); err != nil {
+			db.Close()
+			return nil, cfg, "", err
+		}
+	}
+	runState.prefix = prefix
+	return db, cfg, prefix, nil
+}
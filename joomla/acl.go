@@ -0,0 +1,189 @@
+package joomla
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Rules are the permissions stored in the rules column of an asset: for each
+// action, the setting of each group id, 1 for Allowed and 0 for Denied. Groups
+// without a setting inherit it.
+type Rules map[string]map[int]int
+
+// ParseRules decodes the rules JSON of an asset, e.g.
+// {"core.admin":{"8":1},"core.login.site":{"6":1,"2":1},"core.delete":[]}.
+// Actions without settings are stored as [] rather than {}.
+func ParseRules(src string) (Rules, error) {
+	var parsed map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(src), &parsed); err != nil {
+		return nil, fmt.Errorf("parse rules: %w", err)
+	}
+	rules := make(Rules, len(parsed))
+	for action, raw := range parsed {
+		var settings map[string]int
+		if string(raw) != "[]" {
+			if err := json.Unmarshal(raw, &settings); err != nil {
+				return nil, fmt.Errorf("parse %s rules: %w", action, err)
+			}
+		}
+		groups := make(map[int]int, len(settings))
+		for gid, v := range settings {
+			id, err := strconv.Atoi(gid)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s rules: group id %q", action, gid)
+			}
+			groups[id] = v
+		}
+		rules[action] = groups
+	}
+	return rules, nil
+}
+
+// Allowed reports whether the group with the given id and ancestors, listed
+// from the root group down, may perform action. As in Joomla, a Denied
+// anywhere on the way wins, and otherwise one Allowed is enough.
+func (r Rules) Allowed(action string, lineage []int) bool {
+	allowed := false
+	for _, id := range lineage {
+		v, ok := r[action][id]
+		if !ok {
+			continue
+		}
+		if v == 0 {
+			return false
+		}
+		allowed = allowed || v == 1
+	}
+	return allowed
+}
+
+// rootRules reads the rules of the root asset, from which every other asset
+// inherits.
+func rootRules(db *sql.DB, prefix string) (string, error) {
+	var rules string
+	q := fmt.Sprintf("SELECT rules FROM %s_assets WHERE parent_id = 0 ORDER BY lft LIMIT 1", prefix)
+	err := db.QueryRow(q).Scan(&rules)
+	return rules, err
+}
+
+// ViewLevel is an access level of <prefix>_viewlevels with the ids of the
+// groups it is granted to. The children of those groups are granted it too.
+type ViewLevel struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Groups []int  `json:"groups"`
+}
+
+// ListViewLevels returns the access levels in their configured order.
+func ListViewLevels(db *sql.DB, prefix string) ([]ViewLevel, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT id, title, rules FROM %s_viewlevels ORDER BY ordering, id", prefix))
+	if err != nil {
+		return nil, fmt.Errorf("list view levels: %w", err)
+	}
+	defer rows.Close()
+
+	var levels []ViewLevel
+	for rows.Next() {
+		var l ViewLevel
+		var groups string
+		if err := rows.Scan(&l.ID, &l.Title, &groups); err != nil {
+			return nil, fmt.Errorf("scan view level: %w", err)
+		}
+		if groups != "" {
+			if err := json.Unmarshal([]byte(groups), &l.Groups); err != nil {
+				return nil, fmt.Errorf("parse groups of view level %q: %w", l.Title, err)
+			}
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// GroupAccess is the access of one usergroup: the view levels it holds,
+// directly or through a parent group, and whether the root asset lets its
+// members administer the site or log in to the backend.
+type GroupAccess struct {
+	ID         int      `json:"id"`
+	Title      string   `json:"title"`
+	ParentID   int      `json:"parentId"`
+	Depth      int      `json:"depth"`
+	Users      int      `json:"users"`
+	ViewLevels []string `json:"viewLevels"`
+	Admin      bool     `json:"admin"`      // core.admin
+	AdminLogin bool     `json:"adminLogin"` // core.login.admin
+}
+
+// ListGroupAccess returns every usergroup in tree order with its view levels
+// and backend permissions, resolved through the group tree like Joomla does.
+// Super users (core.admin) may log in to the backend whatever core.login.admin says.
+func ListGroupAccess(db *sql.DB, prefix string) ([]GroupAccess, error) {
+	src, err := rootRules(db, prefix)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no root asset in %s_assets", prefix)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read root asset rules: %w", err)
+	}
+	rules, err := ParseRules(src)
+	if err != nil {
+		return nil, fmt.Errorf("root asset: %w", err)
+	}
+	levels, err := ListViewLevels(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT g.id, g.title, g.parent_id, COUNT(m.user_id)
+                      FROM %[1]s_usergroups g
+                      LEFT JOIN %[1]s_user_usergroup_map m ON m.group_id = g.id
+                      GROUP BY g.id, g.title, g.parent_id, g.lft
+                      ORDER BY g.lft`, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("list usergroups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []GroupAccess
+	for rows.Next() {
+		var g GroupAccess
+		if err := rows.Scan(&g.ID, &g.Title, &g.ParentID, &g.Users); err != nil {
+			return nil, fmt.Errorf("scan usergroup: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	parents := make(map[int]int, len(groups))
+	for _, g := range groups {
+		parents[g.ID] = g.ParentID
+	}
+	for i := range groups {
+		g := &groups[i]
+		// the group and its ancestors, root first; the depth guards against cycles
+		lineage := []int{g.ID}
+		for p := g.ParentID; p != 0 && len(lineage) <= len(groups); p = parents[p] {
+			lineage = append([]int{p}, lineage...)
+		}
+		g.Depth = len(lineage) - 1
+		g.Admin = rules.Allowed("core.admin", lineage)
+		g.AdminLogin = g.Admin || rules.Allowed("core.login.admin", lineage)
+
+		in := make(map[int]bool, len(lineage))
+		for _, id := range lineage {
+			in[id] = true
+		}
+		for _, l := range levels {
+			for _, id := range l.Groups {
+				if in[id] {
+					g.ViewLevels = append(g.ViewLevels, l.Title)
+					break
+				}
+			}
+		}
+	}
+	return groups, nil
+}
@@ -3,33 +3,56 @@ package joomla
 import (
 	"bufio"
 	"cmsmgmt/database"
+	"cmsmgmt/passhash"
 	"crypto/md5"
+	crand "crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
 )
 
+// ConfigFile, when set, is read instead of configuration.php in the CMS root.
+var ConfigFile string
+
+// ConfigPath returns the configuration.php to read for the install at cmsPath.
+func ConfigPath(cmsPath string) string {
+	if ConfigFile != "" {
+		return ConfigFile
+	}
+	return filepath.Join(cmsPath, "configuration.php")
+}
+
 // UserDetail represents a Joomla user.
 type UserDetail struct {
-	ID       int
-	Username string
-	Name     string
-	Email    string
-	Roles    []string
+	ID          int      `json:"id"`
+	Username    string   `json:"username"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Block       bool     `json:"block"`
+	SendEmail   bool     `json:"sendEmail"`
+	IsSuperUser bool     `json:"isSuperUser"`
 }
 
 // ExtractDBConfig extracts the database configuration from the given Joomla configuration file.
 // It also returns the configured table prefix, if found, to speed up later look‑ups.
+// It fails when the name, user or host of the database is missing or empty.
 func ExtractDBConfig(filePath string) (database.DBConfig, string, error) {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -37,29 +60,42 @@ func ExtractDBConfig(filePath string) (database.DBConfig, string, error) {
 	}
 
 	cfg := database.DBConfig{
-		Type: "mysql", // default to MySQL
-		Port: 3306,    // default MySQL port
+		Type:      "mysql", // default to MySQL
+		Port:      3306,    // default MySQL port
+		Charset:   database.DefaultCharset,
+		ParseTime: true,
 	}
 	var dbPrefix string
+	var hostHasPort bool
 
+	// settings may be minified, spaced differently, double-quoted or followed by
+	// comments; commented-out settings must not win over the real ones
+	src := stripPHPComments(string(content))
 	patterns := map[string]*regexp.Regexp{
-		"DBType":     regexp.MustCompile(`public \$dbtype\s*=\s*'([^']+)';`),
-		"DBName":     regexp.MustCompile(`public \$db\s*=\s*'([^']+)';`),
-		"DBUser":     regexp.MustCompile(`public \$user\s*=\s*'([^']+)';`),
-		"DBPassword": regexp.MustCompile(`public \$password\s*=\s*'([^']+)';`),
-		"DBHost":     regexp.MustCompile(`public \$host\s*=\s*'([^']+)';`),
-		"DBPrefix":   regexp.MustCompile(`public \$dbprefix\s*=\s*'([^']+)';`),
+		"DBType":     configSetting("dbtype"),
+		"DBName":     configSetting("db"),
+		"DBUser":     configSetting("user"),
+		"DBPassword": configSetting("password"),
+		"DBHost":     configSetting("host"),
+		"DBPrefix":   configSetting("dbprefix"),
+	}
+	if !patterns["DBName"].MatchString(src) {
+		return cfg, "", fmt.Errorf("no database settings found in %s", filePath)
 	}
 
 	for key, re := range patterns {
-		if m := re.FindStringSubmatch(string(content)); len(m) > 1 {
+		if m := re.FindStringSubmatch(src); len(m) > 1 {
+			m[1] = unquotePHP(m[1])
 			switch key {
 			case "DBType":
-				t := strings.ToLower(m[1])
-				if t == "mysqli" {
-					t = "mysql"
+				switch t := strings.ToLower(m[1]); t {
+				case "mysqli", "pdomysql":
+					cfg.Type = "mysql"
+				case "pgsql", "postgresql":
+					cfg.Type = "postgres"
+				default:
+					cfg.Type = t
 				}
-				cfg.Type = t
 			case "DBName":
 				cfg.DBName = m[1]
 			case "DBUser":
@@ -72,6 +108,7 @@ func ExtractDBConfig(filePath string) (database.DBConfig, string, error) {
 					cfg.Host = h
 					if pn, err := strconv.Atoi(p); err == nil {
 						cfg.Port = pn
+						hostHasPort = true
 					}
 				} else {
 					cfg.Host = hostPort
@@ -82,96 +119,1223 @@ func ExtractDBConfig(filePath string) (database.DBConfig, string, error) {
 			}
 		}
 	}
+
+	if cfg.Type == "postgres" && !hostHasPort {
+		cfg.Port = 5432
+	}
+
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"$db", cfg.DBName}, {"$user", cfg.User}, {"$host", cfg.Host},
+	} {
+		if f.value == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		return cfg, "", fmt.Errorf("%s does not set %s", filePath, strings.Join(missing, ", "))
+	}
 	return cfg, dbPrefix, nil
 }
 
-// IdentifyPrefixes returns prefixes that really belong to Joomla installations.
-func IdentifyPrefixes(db *sql.DB) ([]string, error) {
-	rows, err := db.Query("SHOW TABLES LIKE '%\\_users'")
+// configSetting matches a property of JConfig, such as public $db = 'x';,
+// capturing the quoted value.
+func configSetting(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?:public|var)\s+\$` + name + `\s*=\s*('(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*")\s*;`)
+}
+
+// scalarSetting returns the value of a JConfig property that may be a quoted
+// string or a bare literal such as true or 1, as Joomla 4 writes them.
+func scalarSetting(src, name string) (string, bool) {
+	re := regexp.MustCompile(`(?:public|var)\s+\$` + name + `\s*=\s*('(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"|[^;]*?)\s*;`)
+	m := re.FindStringSubmatch(src)
+	if m == nil {
+		return "", false
+	}
+	if m[1] != "" && (m[1][0] == '\'' || m[1][0] == '"') {
+		return unquotePHP(m[1]), true
+	}
+	return m[1], true
+}
+
+// phpTrue reports whether a setting value is truthy in PHP.
+func phpTrue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "0", "false", "null":
+		return false
+	}
+	return true
+}
+
+// unquotePHP returns the value of a single- or double-quoted PHP string
+// literal, undoing the common escapes.
+func unquotePHP(s string) string {
+	switch s[0] {
+	case '\'':
+		return strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(s[1 : len(s)-1])
+	case '"':
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\$`, `$`).Replace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+// stripPHPComments removes //, # and /* */ comments from PHP source, leaving
+// string literals alone.
+func stripPHPComments(src string) string {
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\'' || c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			b.WriteString(src[i : j+1])
+			i = j
+		case c == '#' || c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i+1 < len(src) && src[i+1] != '\n' && src[i+1] != '\r' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 3
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// IdentifyPrefixes returns prefixes that really belong to Joomla installations:
+// those with a <prefix>_users table and the companion _user_usergroup_map and
+// _usergroups tables. Tables are listed through database.ClassifyTables, so
+// MySQL and PostgreSQL databases are both supported.
+func IdentifyPrefixes(db *sql.DB, dbType string) ([]string, error) {
+	classes, err := database.ClassifyTables(db, dbType)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var prefixes []string
+	for _, c := range classes {
+		if c.Users && c.UserMap && c.UserGroups {
+			prefixes = append(prefixes, c.Prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+// checkPrefix warns when the prefix declared in configuration.php has no Joomla
+// tables, which usually means a migrated site whose config was not updated.
+func checkPrefix(configured string, prefixes []string) error {
+	for _, p := range prefixes {
+		if strings.EqualFold(p, configured) {
+			return nil
+		}
+	}
+	found := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		found[i] = p + "_"
+	}
+	return database.Warnf("configuration.php declares prefix %q but the database only has Joomla tables for %s",
+		configured+"_", strings.Join(found, ", "))
+}
+
+// SuperUserGroups returns the ids of the groups granted core.admin on the root asset.
+// It falls back to the stock "Super Users" group (id 8) when the rules cannot be read.
+func SuperUserGroups(db *sql.DB, prefix string) ([]int, error) {
+	src, err := rootRules(db, prefix)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			if err := database.Tolerate(fmt.Errorf("no root asset in %s_assets", prefix)); err != nil {
+				return nil, err
+			}
+			return []int{8}, nil
+		}
+		return nil, fmt.Errorf("read root asset rules: %w", err)
+	}
+
+	rules, err := ParseRules(src)
+	if err != nil {
+		if err := database.Tolerate(fmt.Errorf("root asset: %w", err)); err != nil {
+			return nil, err
+		}
+	}
+	var groups []int
+	for id, allowed := range rules["core.admin"] {
+		if allowed == 1 {
+			groups = append(groups, id)
+		}
+	}
+	if len(groups) == 0 {
+		if err := database.Tolerate(errors.New("no group is granted core.admin on the root asset")); err != nil {
+			return nil, err
+		}
+		return []int{8}, nil
+	}
+	sort.Ints(groups)
+	return groups, nil
+}
+
+// superUserIDs returns the ids of users that belong to a super-user group or any of its children.
+func superUserIDs(db *sql.DB, prefix string) (map[int]bool, error) {
+	groups, err := SuperUserGroups(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(groups)), ",")
+	args := make([]any, len(groups))
+	for i, g := range groups {
+		args[i] = g
+	}
+
+	q := fmt.Sprintf(`SELECT DISTINCT m.user_id
+                      FROM %[1]s_user_usergroup_map m
+                      JOIN %[1]s_usergroups g ON m.group_id = g.id
+                      JOIN %[1]s_usergroups a ON a.lft <= g.lft AND g.rgt <= a.rgt
+                      WHERE a.id IN (%[2]s)`, prefix, placeholders)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// AdminGroups returns the titles of the groups granted core.admin on the root
+// asset and of their child groups, whose members are Super Users.
+func AdminGroups(db *sql.DB, prefix string) ([]string, error) {
+	groups, err := SuperUserGroups(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(groups)), ",")
+	args := make([]any, len(groups))
+	for i, g := range groups {
+		args[i] = g
+	}
+
+	q := fmt.Sprintf(`SELECT DISTINCT g.title
+                      FROM %[1]s_usergroups g
+                      JOIN %[1]s_usergroups a ON a.lft <= g.lft AND g.rgt <= a.rgt
+                      WHERE a.id IN (%[2]s)`, prefix, placeholders)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list admin groups: %w", err)
+	}
+	defer rows.Close()
+
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, fmt.Errorf("scan admin group: %w", err)
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+// queryer is the reading half of *sql.DB and *sql.Tx, so a dry run can make
+// the checks of a change without opening a transaction.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// guardLastSuperUser refuses, through database.Guard, a role change in q
+// that leaves the site without an active Super User. The users with the ids
+// in except are not counted, so a dry run can check a change it has not made.
+func guardLastSuperUser(db *sql.DB, q queryer, prefix string, except ...int) error {
+	groups, err := SuperUserGroups(db, prefix)
+	if err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(groups)), ",")
+	args := make([]any, 0, len(groups)+len(except))
+	for _, g := range groups {
+		args = append(args, g)
+	}
+	skip := ""
+	if len(except) > 0 {
+		skip = " AND u.id NOT IN (" + strings.TrimSuffix(strings.Repeat("?,", len(except)), ",") + ")"
+		for _, id := range except {
+			args = append(args, id)
+		}
+	}
+
+	var n int
+	query := fmt.Sprintf(`SELECT COUNT(DISTINCT u.id)
+                      FROM %[1]s_users u
+                      JOIN %[1]s_user_usergroup_map m ON m.user_id = u.id
+                      JOIN %[1]s_usergroups g ON m.group_id = g.id
+                      JOIN %[1]s_usergroups a ON a.lft <= g.lft AND g.rgt <= a.rgt
+                      WHERE a.id IN (%[2]s) AND u.block = 0%[3]s`, prefix, placeholders, skip)
+	if err := q.QueryRow(query, args...).Scan(&n); err != nil {
+		return fmt.Errorf("count super users: %w", err)
+	}
+	if n == 0 {
+		return database.Guard("this change removes the last active Super User")
+	}
+	return nil
+}
+
+// ListAdmins returns the members of the groups granted core.admin on the root
+// asset and of their child groups, with their last visit.
+func ListAdmins(db *sql.DB, prefix string) ([]database.PrivilegedUser, error) {
+	groups, err := SuperUserGroups(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(groups)), ",")
+	args := make([]any, len(groups))
+	for i, g := range groups {
+		args[i] = g
+	}
+
+	// a user may be in several admin groups; report the one nearest the root
+	q := fmt.Sprintf(`SELECT u.id, u.username, u.email, a.title, u.lastvisitDate
+                      FROM %[1]s_users u
+                      JOIN %[1]s_user_usergroup_map m ON m.user_id = u.id
+                      JOIN %[1]s_usergroups g ON m.group_id = g.id
+                      JOIN %[1]s_usergroups a ON a.lft <= g.lft AND g.rgt <= a.rgt
+                      WHERE a.id IN (%[2]s)
+                      ORDER BY u.id, a.lft`, prefix, placeholders)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list admins: %w", err)
+	}
+	defer rows.Close()
+
+	var admins []database.PrivilegedUser
+	for rows.Next() {
+		var u database.PrivilegedUser
+		var visited database.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &visited); err != nil {
+			return nil, fmt.Errorf("scan admin: %w", err)
+		}
+		if n := len(admins); n > 0 && admins[n-1].ID == u.ID {
+			continue
+		}
+		// Joomla 3 stores never as 0000-00-00, which parses to the zero time
+		if visited.Valid && !visited.Time.IsZero() {
+			t := visited.Time
+			u.LastLogin = &t
+		}
+		admins = append(admins, u)
+	}
+	return admins, rows.Err()
+}
+
+// ListUsers retrieves user details for a single prefix, narrowed by filter.
+func ListUsers(db *sql.DB, prefix string, filter database.UserFilter) ([]UserDetail, error) {
+	var users []UserDetail
+	err := ListUsersFunc(db, prefix, filter, func(u UserDetail) error {
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListUsersFunc is like ListUsers but calls fn for each user as the rows are
+// read, stopping at the first error fn returns.
+func ListUsersFunc(db *sql.DB, prefix string, filter database.UserFilter, fn func(UserDetail) error) error {
+	defer database.Phase("list users "+prefix, time.Now())
+	conds, args := filter.RegisteredConds("u.registerDate")
+	emailConds, emailArgs := filter.EmailConds("u.email")
+	conds, args = append(conds, emailConds...), append(args, emailArgs...)
+	switch filter.Blocked {
+	case database.BlockedOnly:
+		conds = append(conds, "u.block = 1")
+	case database.BlockedExclude:
+		conds = append(conds, "u.block = 0")
+	}
+	var where string
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	if filter.NoRoles {
+		return listUsersNoRoles(db, prefix, where, args, fn)
+	}
+
+	supers, err := superUserIDs(db, prefix)
+	if err != nil {
+		return fmt.Errorf("resolve super users: %w", err)
+	}
+
+	q := fmt.Sprintf(`
+        SELECT u.id, u.username, u.name, u.email, u.block, u.sendEmail,
+               GROUP_CONCAT(ug.title SEPARATOR ',') AS roles
+        FROM %[1]s_users u
+        LEFT JOIN %[1]s_user_usergroup_map m ON u.id = m.user_id
+        LEFT JOIN %[1]s_usergroups ug ON m.group_id = ug.id
+        %[2]s
+        GROUP BY u.id`, prefix, where)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u UserDetail
+		var roles sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.Name, &u.Email, &u.Block, &u.SendEmail, &roles); err != nil {
+			return err
+		}
+		if roles.Valid {
+			u.Roles = strings.Split(roles.String, ",")
+		}
+		u.IsSuperUser = supers[u.ID]
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// listUsersNoRoles reads the users table alone; Roles and IsSuperUser stay empty.
+func listUsersNoRoles(db *sql.DB, prefix, where string, args []any, fn func(UserDetail) error) error {
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT u.id, u.username, u.name, u.email, u.block, u.sendEmail FROM %s_users u %s ORDER BY u.id", prefix, where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u UserDetail
+		if err := rows.Scan(&u.ID, &u.Username, &u.Name, &u.Email, &u.Block, &u.SendEmail); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetUserByUsername retrieves a user by username for the given prefix.
+func GetUserByUsername(db *sql.DB, prefix, username string) (UserDetail, error) {
+	q := fmt.Sprintf(`SELECT u.id, u.username, u.name, u.email, u.block, u.sendEmail,
+                             GROUP_CONCAT(ug.title) AS roles
+                      FROM %[1]s_users u
+                      LEFT JOIN %[1]s_user_usergroup_map m ON u.id = m.user_id
+                      LEFT JOIN %[1]s_usergroups ug        ON m.group_id = ug.id
+                      WHERE u.username = ?
+                      GROUP BY u.id`, prefix)
+	var u UserDetail
+	var roles sql.NullString
+	if err := db.QueryRow(q, username).Scan(&u.ID, &u.Username, &u.Name, &u.Email, &u.Block, &u.SendEmail, &roles); err != nil {
+		return UserDetail{}, err
+	}
+	if roles.Valid {
+		u.Roles = strings.Split(roles.String, ",")
+	}
+	supers, err := superUserIDs(db, prefix)
+	if err != nil {
+		return UserDetail{}, fmt.Errorf("resolve super users: %w", err)
+	}
+	u.IsSuperUser = supers[u.ID]
+	return u, nil
+}
+
+// GetUserByEmail returns every user of the given prefix whose e-mail is
+// email, as several accounts may share one address.
+func GetUserByEmail(db *sql.DB, prefix, email string) ([]database.UserMatch, error) {
+	q := fmt.Sprintf(`SELECT u.id, u.username, u.email, u.lastvisitDate,
+                             GROUP_CONCAT(ug.title ORDER BY ug.lft SEPARATOR ',') AS roles
+                      FROM %[1]s_users u
+                      LEFT JOIN %[1]s_user_usergroup_map m ON u.id = m.user_id
+                      LEFT JOIN %[1]s_usergroups ug        ON m.group_id = ug.id
+                      WHERE u.email = ?
+                      GROUP BY u.id
+                      ORDER BY u.id`, prefix)
+	rows, err := db.Query(q, email)
+	if err != nil {
+		return nil, fmt.Errorf("find by email: %w", err)
+	}
+	defer rows.Close()
+
+	var users []database.UserMatch
+	for rows.Next() {
+		u := database.UserMatch{Prefix: prefix}
+		var visited database.NullTime
+		var roles sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &visited, &roles); err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		if roles.Valid {
+			u.Roles = strings.Split(roles.String, ",")
+		}
+		if visited.Valid && !visited.Time.IsZero() {
+			t := visited.Time
+			u.LastLogin = &t
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UpdateUser updates name & e‑mail in the relevant tables for a given prefix,
+// in one transaction. It returns the number of rows that changed, 0 when the
+// values were already set, and an error when the user does not exist.
+func UpdateUser(db *sql.DB, prefix string, u UserDetail) (int64, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+
+	// only a changed address is validated, so legacy data does not block
+	// other edits
+	var current string
+	err = tx.QueryRow(fmt.Sprintf("SELECT email FROM %s_users WHERE id = ?", prefix), u.ID).Scan(&current)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return 0, fmt.Errorf("%w: id %d", database.ErrUserNotFound, u.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("check user: %w", err)
+	}
+	if u.Email != current {
+		if err := database.ValidateEmail(u.Email); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET name = ?, email = ? WHERE id = ?", prefix), u.Name, u.Email, u.ID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("update user: %w", err)
+	}
+	// MySQL counts changed rows only, so 0 just means the values were kept
+	n, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return n, nil
+}
+
+// CreateOptions controls how CreateUser sets up a new account.
+type CreateOptions struct {
+	// Activate makes the account usable at once, whatever the site's
+	// registration settings ask for.
+	Activate bool
+	// RequireReset sets requireReset, so the user must choose a new password at
+	// the first login (Joomla 3.4 and later).
+	RequireReset bool
+}
+
+// CreatedUser describes the account added by CreateUser.
+type CreatedUser struct {
+	ID         int      `json:"id"`
+	Block      bool     `json:"block"`
+	Activation string   `json:"activation,omitempty"`
+	Groups     []string `json:"groups"`
+}
+
+// CreateUser adds a user the way Joomla's registration does. Without roles the
+// user joins the site's "New User Registration Group". Unless opts.Activate is
+// set, a site requiring self or administrator activation gets a blocked user
+// with an activation token, like a genuine self-registration.
+func CreateUser(db *sql.DB, prefix, cmsPath, username, email, name, password string, roles []string, opts CreateOptions) (CreatedUser, error) {
+	if username == "" {
+		return CreatedUser{}, fmt.Errorf("username cannot be empty")
+	}
+	if err := database.ValidateEmail(email); err != nil {
+		return CreatedUser{}, err
+	}
+	if name == "" {
+		name = username
+	}
+	settings, err := registrationSettings(db, prefix)
+	if err != nil {
+		return CreatedUser{}, err
+	}
+	hashed, err := joomlaHashAuto(cmsPath, password)
+	if err != nil {
+		return CreatedUser{}, fmt.Errorf("hash password: %w", err)
+	}
+
+	created := CreatedUser{}
+	if settings.activation != 0 && !opts.Activate {
+		created.Block = true
+		if created.Activation, err = activationToken(cmsPath); err != nil {
+			return CreatedUser{}, err
+		}
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return CreatedUser{}, fmt.Errorf("begin tx: %w", err)
+	}
+
+	var taken int
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE username = ? OR email = ?", prefix)
+	if err := tx.QueryRow(q, username, email).Scan(&taken); err != nil {
+		tx.Rollback()
+		return CreatedUser{}, fmt.Errorf("check existing users: %w", err)
+	}
+	if taken > 0 {
+		tx.Rollback()
+		return CreatedUser{}, fmt.Errorf("username %q or e-mail %q is already taken", username, email)
+	}
+
+	var gids []int
+	if len(roles) == 0 {
+		var title string
+		q := fmt.Sprintf("SELECT title FROM %s_usergroups WHERE id = ?", prefix)
+		if err := tx.QueryRow(q, settings.group).Scan(&title); err != nil {
+			tx.Rollback()
+			return CreatedUser{}, fmt.Errorf("look up new user group %d: %w", settings.group, err)
+		}
+		gids = []int{settings.group}
+		created.Groups = []string{title}
+	}
+	for _, title := range roles {
+		var gid int
+		err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s_usergroups WHERE title = ?", prefix), title).Scan(&gid)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			return CreatedUser{}, fmt.Errorf("role %q does not exist", title)
+		}
+		if err != nil {
+			tx.Rollback()
+			return CreatedUser{}, fmt.Errorf("look up role %q: %w", title, err)
+		}
+		gids = append(gids, gid)
+		created.Groups = append(created.Groups, title)
+	}
+
+	res, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s_users (name, username, email, password, block, sendEmail, registerDate, activation, params)
+	                                 VALUES (?, ?, ?, ?, ?, 0, ?, ?, '{}')`, prefix),
+		name, username, email, hashed, created.Block, time.Now().UTC().Truncate(time.Second), created.Activation)
+	if err != nil {
+		tx.Rollback()
+		return CreatedUser{}, fmt.Errorf("insert user: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return CreatedUser{}, fmt.Errorf("read new user id: %w", err)
+	}
+	created.ID = int(id)
+
+	for _, gid := range gids {
+		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s_user_usergroup_map (user_id, group_id) VALUES (?, ?)", prefix), id, gid); err != nil {
+			tx.Rollback()
+			return CreatedUser{}, fmt.Errorf("insert role %d: %w", gid, err)
+		}
+	}
+	if opts.RequireReset {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET requireReset = 1 WHERE id = ?", prefix), id); err != nil {
+			tx.Rollback()
+			return CreatedUser{}, fmt.Errorf("set requireReset (Joomla 3.4 or later is needed): %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return CreatedUser{}, fmt.Errorf("commit: %w", err)
+	}
+	return created, nil
+}
+
+// registration holds the com_users options that shape a new account.
+type registration struct {
+	activation int // useractivation: 0 none, 1 self, 2 administrator
+	group      int // new_usertype
+}
+
+// registrationSettings reads the com_users options, falling back to Joomla's
+// defaults (no activation, group Registered) when they were never saved.
+func registrationSettings(db *sql.DB, prefix string) (registration, error) {
+	settings := registration{activation: 0, group: 2}
+	var raw sql.NullString
+	q := fmt.Sprintf("SELECT params FROM %s_extensions WHERE element = 'com_users' AND type = 'component'", prefix)
+	err := db.QueryRow(q).Scan(&raw)
+	if err == sql.ErrNoRows || !raw.Valid || raw.String == "" {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("read com_users options: %w", err)
+	}
+	var params map[string]any
+	if err := json.Unmarshal([]byte(raw.String), &params); err != nil {
+		if err := database.Tolerate(fmt.Errorf("decode com_users options: %w", err)); err != nil {
+			return settings, err
+		}
+		return settings, nil
+	}
+	// Joomla saves the values as strings, older sites sometimes as numbers
+	number := func(key string, def int) int {
+		switch v := params[key].(type) {
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n
+			}
+		case float64:
+			return int(v)
+		}
+		return def
+	}
+	settings.activation = number("useractivation", settings.activation)
+	settings.group = number("new_usertype", settings.group)
+	return settings, nil
+}
+
+// activationToken returns a token like Joomla's registration model makes it:
+// the MD5 of the site secret and a random password.
+func activationToken(cmsPath string) (string, error) {
+	var secret string
+	if content, err := os.ReadFile(ConfigPath(cmsPath)); err == nil {
+		if m := configSetting("secret").FindStringSubmatch(stripPHPComments(string(content))); len(m) > 1 {
+			secret = unquotePHP(m[1])
+		}
+	}
+	buf := make([]byte, 12)
+	if _, err := crand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate activation token: %w", err)
+	}
+	sum := md5.Sum([]byte(secret + base64.RawURLEncoding.EncodeToString(buf)))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CountUsers returns the number of users, or of users in the group titled
+// role when it is not empty.
+func CountUsers(db *sql.DB, prefix, role string) (int, error) {
+	var n int
+	var err error
+	if role == "" {
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM `%s_users`", prefix)).Scan(&n)
+	} else {
+		err = db.QueryRow(fmt.Sprintf(`SELECT COUNT(DISTINCT m.user_id)
+                                       FROM %[1]s_user_usergroup_map m
+                                       JOIN %[1]s_usergroups g ON m.group_id = g.id
+                                       WHERE g.title = ?`, prefix), role).Scan(&n)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("count users: %w", err)
+	}
+	return n, nil
+}
+
+// CountByRole returns the number of direct members of every user group, in
+// tree order.
+func CountByRole(db *sql.DB, prefix string) ([]database.RoleCount, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT g.title, COUNT(m.user_id)
+                                       FROM %[1]s_usergroups g
+                                       LEFT JOIN %[1]s_user_usergroup_map m ON m.group_id = g.id
+                                       GROUP BY g.id, g.title, g.lft
+                                       ORDER BY g.lft`, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("count users by group: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []database.RoleCount
+	for rows.Next() {
+		var c database.RoleCount
+		if err := rows.Scan(&c.Role, &c.Users); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// DuplicateGroup is a set of users sharing the same value in a field.
+type DuplicateGroup struct {
+	Value string       `json:"value"`
+	Users []UserDetail `json:"users"`
+}
+
+// UserStats returns the user totals of the install: all users, blocked users,
+// users registered at or after since, and the CountByRole breakdown.
+func UserStats(db *sql.DB, prefix string, since time.Time) (database.UserStats, error) {
+	stats := database.UserStats{Since: since.UTC()}
+	q := fmt.Sprintf(`SELECT COUNT(*),
+                             COALESCE(SUM(CASE WHEN block = 1 THEN 1 ELSE 0 END), 0),
+                             COALESCE(SUM(CASE WHEN registerDate >= ? THEN 1 ELSE 0 END), 0)
+                      FROM %s_users`, prefix)
+	if err := db.QueryRow(q, since.UTC()).Scan(&stats.Total, &stats.Blocked, &stats.Recent); err != nil {
+		return stats, fmt.Errorf("count users: %w", err)
+	}
+
+	var err error
+	if stats.Roles, err = CountByRole(db, prefix); err != nil {
+		return stats, err
+	}
+	if stats.Roles == nil {
+		stats.Roles = []database.RoleCount{}
+	}
+	return stats, nil
+}
+
+// FindDuplicateEmails returns the groups of users that share an e-mail address.
+func FindDuplicateEmails(db *sql.DB, prefix string) ([]DuplicateGroup, error) {
+	return FindDuplicates(db, prefix, "email")
+}
+
+// FindDuplicates returns the groups of users sharing the same (case-insensitive)
+// value in field, which is either "email" or "username".
+func FindDuplicates(db *sql.DB, prefix, field string) ([]DuplicateGroup, error) {
+	switch field {
+	case "email", "username":
+	default:
+		return nil, fmt.Errorf("unsupported duplicate field: %s", field)
+	}
+
+	q := fmt.Sprintf(`
+        SELECT u.id, u.username, u.name, u.email, u.block, d.v
+        FROM %[1]s_users u
+        JOIN (SELECT LOWER(%[2]s) AS v FROM %[1]s_users
+              GROUP BY LOWER(%[2]s) HAVING COUNT(*) > 1) d ON LOWER(u.%[2]s) = d.v
+        ORDER BY d.v, u.id`, prefix, field)
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var u UserDetail
+		var v string
+		if err := rows.Scan(&u.ID, &u.Username, &u.Name, &u.Email, &u.Block, &v); err != nil {
+			return nil, err
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Value != v {
+			groups = append(groups, DuplicateGroup{Value: v})
+		}
+		g := &groups[len(groups)-1]
+		g.Users = append(g.Users, u)
+	}
+	return groups, rows.Err()
+}
+
+// FindOrphans reports user group mappings that point at missing users or
+// groups, and users that belong to no group at all.
+func FindOrphans(db *sql.DB, prefix string) ([]database.IntegrityIssue, error) {
+	checks := []struct {
+		issue database.IntegrityIssue
+		query string
+	}{
+		{
+			database.IntegrityIssue{Table: prefix + "_user_usergroup_map", Issue: "mappings of missing users", Fixable: true},
+			fmt.Sprintf("SELECT COUNT(*) FROM %[1]s_user_usergroup_map WHERE user_id NOT IN (SELECT id FROM %[1]s_users)", prefix),
+		},
+		{
+			database.IntegrityIssue{Table: prefix + "_user_usergroup_map", Issue: "mappings to missing groups", Fixable: true},
+			fmt.Sprintf("SELECT COUNT(*) FROM %[1]s_user_usergroup_map WHERE group_id NOT IN (SELECT id FROM %[1]s_usergroups)", prefix),
+		},
+		{
+			database.IntegrityIssue{Table: prefix + "_users", Issue: "users without a group"},
+			fmt.Sprintf(`SELECT COUNT(*) FROM %[1]s_users u
+                         LEFT JOIN %[1]s_user_usergroup_map m ON m.user_id = u.id
+                         WHERE m.user_id IS NULL`, prefix),
+		},
+	}
+
+	issues := make([]database.IntegrityIssue, 0, len(checks))
+	for _, c := range checks {
+		if err := db.QueryRow(c.query).Scan(&c.issue.Rows); err != nil {
+			return nil, fmt.Errorf("check %s: %w", c.issue.Table, err)
+		}
+		issues = append(issues, c.issue)
+	}
+	return issues, nil
+}
+
+// FixOrphans deletes the group mappings of missing users and groups in one
+// transaction and returns the number of rows removed.
+func FixOrphans(db *sql.DB, prefix string) (int64, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+
+	res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %[1]s_user_usergroup_map
+                                     WHERE user_id NOT IN (SELECT id FROM %[1]s_users)
+                                        OR group_id NOT IN (SELECT id FROM %[1]s_usergroups)`, prefix))
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("delete orphaned mappings: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		if err := database.Tolerate(fmt.Errorf("count deleted mappings: %w", err)); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return n, nil
+}
+
+// RenameUser changes the username of oldName to newName and returns the
+// number of users rows updated.
+func RenameUser(db *sql.DB, prefix, oldName, newName string) (int64, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+
+	var id int
+	if err := tx.QueryRow(fmt.Sprintf("SELECT id FROM %s_users WHERE username = ?", prefix), oldName).Scan(&id); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("user %q not found", oldName)
+		}
+		return 0, fmt.Errorf("read user: %w", err)
+	}
+
+	// a case-only rename must not find the user itself
+	var taken int
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE username = ? AND id <> ?", prefix)
+	if err := tx.QueryRow(q, newName, id).Scan(&taken); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("check new username: %w", err)
+	}
+	if taken > 0 {
+		tx.Rollback()
+		return 0, fmt.Errorf("username %q is already taken", newName)
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET username = ? WHERE id = ?", prefix), newName, id)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("rename user: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		if err := database.Tolerate(fmt.Errorf("check rename: %w", err)); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return n, nil
+}
+
+// TouchLastVisit sets the lastvisitDate of username to t and returns the
+// previous value (nil when the user never visited).
+func TouchLastVisit(db *sql.DB, prefix, username string, t time.Time) (*time.Time, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+
+	var id int
+	var visited database.NullTime
+	q := fmt.Sprintf("SELECT id, lastvisitDate FROM %s_users WHERE username = ?", prefix)
+	if err := tx.QueryRow(q, username).Scan(&id, &visited); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user %q not found", username)
+		}
+		return nil, fmt.Errorf("read user: %w", err)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET lastvisitDate = ? WHERE id = ?", prefix),
+		t.UTC().Truncate(time.Second), id); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("update lastvisitDate: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	if !visited.Valid || visited.Time.IsZero() {
+		return nil, nil
+	}
+	return &visited.Time, nil
+}
+
+// LockStale blocks every active user who has neither visited nor registered
+// since cutoff. Members of the Super User groups are skipped unless
+// includeAdmins is set, and even then the last one is kept through
+// database.Guard. With dryRun nothing is written. It returns the affected users.
+func LockStale(db *sql.DB, prefix string, cutoff time.Time, includeAdmins, dryRun bool) ([]database.StaleUser, error) {
+	supers, err := superUserIDs(db, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("resolve super users: %w", err)
+	}
+
+	// Joomla 3 stores never as 0000-00-00, which sorts before any cutoff
+	cutoff = cutoff.UTC()
+	q := fmt.Sprintf(`SELECT id, username, email, registerDate, lastvisitDate
+                      FROM %s_users
+                      WHERE block = 0 AND registerDate < ? AND (lastvisitDate IS NULL OR lastvisitDate < ?)
+                      ORDER BY id`, prefix)
+	rows, err := db.Query(q, cutoff, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("list stale users: %w", err)
+	}
+	defer rows.Close()
+
+	var stale []database.StaleUser
+	for rows.Next() {
+		var u database.StaleUser
+		var registered, visited database.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &registered, &visited); err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		u.LastActive = registered.Time
+		if visited.Valid && visited.Time.After(u.LastActive) {
+			u.LastActive = visited.Time
+		}
+		u.Admin = supers[int(u.ID)]
+		if u.Admin && !includeAdmins {
+			continue
+		}
+		stale = append(stale, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list stale users: %w", err)
+	}
+	if dryRun || len(stale) == 0 {
+		return stale, nil
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	anyAdmin := false
+	for _, u := range stale {
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET block = 1 WHERE id = ?", prefix), u.ID); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("block %s: %w", u.Username, err)
+		}
+		anyAdmin = anyAdmin || u.Admin
+	}
+	if anyAdmin {
+		if err := guardLastSuperUser(db, tx, prefix); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return stale, nil
+}
+
+// EmailChange describes a single user e-mail rewrite.
+type EmailChange struct {
+	ID       int
+	Username string
+	Old      string
+	New      string
+}
+
+// RewriteEmailDomain replaces the from suffix of every matching user e-mail with to,
+// e.g. "@old.com" → "@new.com". All updates run in one transaction; with dryRun
+// nothing is written and the planned changes are returned.
+func RewriteEmailDomain(db *sql.DB, prefix, from, to string, dryRun bool) ([]EmailChange, error) {
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT id, username, email FROM `%s_users` WHERE email LIKE ?", prefix),
+		"%"+database.EscapeLike(from))
+	if err != nil {
+		return nil, fmt.Errorf("find matching users: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []EmailChange
 	for rows.Next() {
-		var tbl string
-		if err := rows.Scan(&tbl); err != nil {
+		var c EmailChange
+		if err := rows.Scan(&c.ID, &c.Username, &c.Old); err != nil {
 			return nil, err
 		}
-		prefix := strings.TrimSuffix(tbl, "_users")
-		// check companion tables exist
-		need := []string{prefix + "_user_usergroup_map", prefix + "_usergroups"}
-		ok := true
-		for _, t := range need {
-			var dummy string
-			if err := db.QueryRow("SHOW TABLES LIKE ?", t).Scan(&dummy); err != nil {
-				ok = false
-				break
+		// LIKE is case-insensitive under the default collations, so compare the same way
+		if !strings.HasSuffix(strings.ToLower(c.Old), strings.ToLower(from)) {
+			continue
+		}
+		c.New = c.Old[:len(c.Old)-len(from)] + to
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, applyEmailChanges(db, prefix, changes, dryRun)
+}
+
+// RewriteEmails applies exact old → new e-mail rewrites from mapping in one transaction.
+// Addresses that match no user are ignored.
+func RewriteEmails(db *sql.DB, prefix string, mapping map[string]string, dryRun bool) ([]EmailChange, error) {
+	olds := make([]string, 0, len(mapping))
+	for old := range mapping {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+
+	var changes []EmailChange
+	q := fmt.Sprintf("SELECT id, username, email FROM `%s_users` WHERE email = ?", prefix)
+	for _, old := range olds {
+		rows, err := db.Query(q, old)
+		if err != nil {
+			return nil, fmt.Errorf("find users with e-mail %s: %w", old, err)
+		}
+		for rows.Next() {
+			c := EmailChange{New: mapping[old]}
+			if err := rows.Scan(&c.ID, &c.Username, &c.Old); err != nil {
+				rows.Close()
+				return nil, err
 			}
+			changes = append(changes, c)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, applyEmailChanges(db, prefix, changes, dryRun)
+}
+
+// applyEmailChanges writes the e-mail changes in a single transaction. Every
+// new address is validated first, also in a dry run, so nothing is written
+// when one of them is malformed.
+func applyEmailChanges(db *sql.DB, prefix string, changes []EmailChange, dryRun bool) error {
+	for _, c := range changes {
+		if err := database.ValidateEmail(c.New); err != nil {
+			return fmt.Errorf("%s: %w", c.Username, err)
 		}
-		if ok {
-			prefixes = append(prefixes, prefix)
+	}
+	if dryRun || len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	q := fmt.Sprintf("UPDATE `%s_users` SET email = ? WHERE id = ?", prefix)
+	for _, c := range changes {
+		if _, err := tx.Exec(q, c.New, c.ID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("update e-mail of %s: %w", c.Username, err)
 		}
 	}
-	sort.Strings(prefixes)
-	return prefixes, nil
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// Session is a row of the Joomla session table. The session id itself is not exposed.
+type Session struct {
+	UserID   int       `json:"userId"`
+	Username string    `json:"username"`
+	ClientID int       `json:"clientId"` // 0 = site, 1 = administrator
+	Time     time.Time `json:"time"`
+	Guest    bool      `json:"guest"`
+}
+
+// ErrNoSessionTable is returned when the install keeps sessions outside the database.
+var ErrNoSessionTable = errors.New("sessions are not stored in the database (no session table)")
+
+// hasSessionTable reports whether <prefix>_session exists.
+func hasSessionTable(db *sql.DB, prefix string) (bool, error) {
+	var name string
+	err := db.QueryRow("SHOW TABLES LIKE ?", prefix+"_session").Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
 }
 
-// ListUsers retrieves user details for a single prefix.
-func ListUsers(db *sql.DB, prefix string) ([]UserDetail, error) {
-	q := fmt.Sprintf(`
-        SELECT u.id, u.username, u.name, u.email,
-               GROUP_CONCAT(ug.title SEPARATOR ',') AS roles
-        FROM %s_users u
-        LEFT JOIN %s_user_usergroup_map m ON u.id = m.user_id
-        LEFT JOIN %s_usergroups ug ON m.group_id = ug.id
-        GROUP BY u.id`, prefix, prefix, prefix)
-	rows, err := db.Query(q)
+// ListSessions returns the sessions currently stored in <prefix>_session, newest first.
+func ListSessions(db *sql.DB, prefix string) ([]Session, error) {
+	ok, err := hasSessionTable(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoSessionTable
+	}
+
+	// time is a varchar epoch on Joomla 3 and an int on Joomla 4+
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT COALESCE(userid, 0), COALESCE(username, ''), client_id, time, guest FROM `%s_session` ORDER BY time DESC",
+		prefix))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []UserDetail
+	var sessions []Session
 	for rows.Next() {
-		var u UserDetail
-		var roles sql.NullString
-		if err := rows.Scan(&u.ID, &u.Username, &u.Name, &u.Email, &roles); err != nil {
+		var s Session
+		var ts sql.NullString
+		if err := rows.Scan(&s.UserID, &s.Username, &s.ClientID, &ts, &s.Guest); err != nil {
 			return nil, err
 		}
-		if roles.Valid {
-			u.Roles = strings.Split(roles.String, ",")
+		if epoch, err := strconv.ParseInt(ts.String, 10, 64); err == nil {
+			s.Time = time.Unix(epoch, 0)
 		}
-		users = append(users, u)
+		sessions = append(sessions, s)
 	}
-	return users, nil
+	return sessions, rows.Err()
 }
 
-// GetUserByUsername retrieves a user by username for the given prefix.
-func GetUserByUsername(db *sql.DB, prefix, username string) (UserDetail, error) {
-	q := fmt.Sprintf(`SELECT u.id, u.username, u.name, u.email,
-                             GROUP_CONCAT(ug.title) AS roles
-                      FROM %[1]s_users u
-                      LEFT JOIN %[1]s_user_usergroup_map m ON u.id = m.user_id
-                      LEFT JOIN %[1]s_usergroups ug        ON m.group_id = ug.id
-                      WHERE u.username = ?
-                      GROUP BY u.id`, prefix)
-	var u UserDetail
-	var roles sql.NullString
-	if err := db.QueryRow(q, username).Scan(&u.ID, &u.Username, &u.Name, &u.Email, &roles); err != nil {
-		return UserDetail{}, err
+// Logout deletes the sessions of username, or of every user when username is empty,
+// and returns the number of sessions removed.
+func Logout(db *sql.DB, prefix, username string) (int64, error) {
+	ok, err := hasSessionTable(db, prefix)
+	if err != nil {
+		return 0, err
 	}
-	if roles.Valid {
-		u.Roles = strings.Split(roles.String, ",")
+	if !ok {
+		return 0, ErrNoSessionTable
 	}
-	return u, nil
-}
 
-// UpdateUser updates name & e‑mail in the relevant tables for a given prefix.
-func UpdateUser(db *sql.DB, prefix string, u UserDetail) error {
-	_, err := db.Exec(fmt.Sprintf("UPDATE %s_users SET name = ?, email = ? WHERE id = ?", prefix), u.Name, u.Email, u.ID)
-	return err
+	var res sql.Result
+	if username == "" {
+		res, err = database.Exec(db, fmt.Sprintf("DELETE FROM `%s_session`", prefix))
+	} else {
+		res, err = database.Exec(db, fmt.Sprintf("DELETE FROM `%s_session` WHERE username = ?", prefix), username)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("delete sessions: %w", err)
+	}
+	return res.RowsAffected()
 }
 
 // ---------------- public entry points ----------------
@@ -179,11 +1343,13 @@ func UpdateUser(db *sql.DB, prefix string, u UserDetail) error {
 // ProcessJoomla processes the Joomla installation at the given path.
 func ProcessJoomla(cmsPath string) (db *sql.DB, cfg database.DBConfig, defaultPrefix string, err error) {
 	// 1) Read Joomla config
-	configPath := filepath.Join(cmsPath, "configuration.php")
+	start := time.Now()
+	configPath := ConfigPath(cmsPath)
 	cfg, defaultPrefix, err = ExtractDBConfig(configPath)
 	if err != nil {
 		return nil, cfg, "", fmt.Errorf("failed to extract Joomla DB config: %w", err)
 	}
+	database.Phase("extract config", start)
 
 	// 2) Connect to DB
 	db, err = database.Connect(cfg)
@@ -192,48 +1358,157 @@ func ProcessJoomla(cmsPath string) (db *sql.DB, cfg database.DBConfig, defaultPr
 	}
 
 	// 3) Identify table prefixes
-	prefixes, err := IdentifyPrefixes(db)
+	start = time.Now()
+	prefixes, err := IdentifyPrefixes(db, cfg.Type)
 	if err != nil {
 		db.Close()
 		return nil, cfg, "", fmt.Errorf("failed to identify Joomla prefixes: %w", err)
 	}
-	if len(prefixes) == 0 && defaultPrefix != "" {
+	database.Phase("identify prefixes", start)
+	if len(prefixes) == 0 {
+		// trust the configured prefix as long as its users table is there
+		var n int
+		q := fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE 1 = 0", defaultPrefix)
+		if defaultPrefix == "" || db.QueryRow(q).Scan(&n) != nil {
+			db.Close()
+			return nil, cfg, "", &database.NoTablesError{CMS: "Joomla", DBName: cfg.DBName, Config: configPath}
+		}
 		prefixes = []string{defaultPrefix}
 	}
+	if err := checkPrefix(defaultPrefix, prefixes); err != nil {
+		db.Close()
+		return nil, cfg, "", err
+	}
 
 	// return db (open) and prefixes
 	return db, cfg, defaultPrefix, nil
 }
 
+// SecurityFinding is the result of one check of SecurityAudit.
+type SecurityFinding struct {
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// minSecretLength is the length of the secret the Joomla installer generates.
+const minSecretLength = 16
+
+// SecurityAudit checks configuration.php for settings that weaken a
+// production site: an empty or short $secret, $debug switched on, and SEF
+// settings that expose component names or rely on a missing rewrite file.
+// The secret itself never appears in the findings, only its length.
+func SecurityAudit(cmsPath string) ([]SecurityFinding, error) {
+	configPath := ConfigPath(cmsPath)
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	src := stripPHPComments(string(content))
+	var findings []SecurityFinding
+
+	secret, _ := scalarSetting(src, "secret")
+	switch n := len(secret); {
+	case n == 0:
+		findings = append(findings, SecurityFinding{"secret", false, "$secret is empty; sessions and tokens derived from it are predictable"})
+	case n < minSecretLength:
+		findings = append(findings, SecurityFinding{"secret", false, fmt.Sprintf("$secret has only %d characters, the installer writes %d", n, minSecretLength)})
+	default:
+		findings = append(findings, SecurityFinding{"secret", true, fmt.Sprintf("$secret is set (%d characters)", n)})
+	}
+
+	if debug, _ := scalarSetting(src, "debug"); phpTrue(debug) {
+		findings = append(findings, SecurityFinding{"debug", false, "$debug is on; queries, paths and profiling data are shown to visitors"})
+	} else {
+		findings = append(findings, SecurityFinding{"debug", true, "$debug is off"})
+	}
+
+	sef, found := scalarSetting(src, "sef")
+	switch {
+	case found && !phpTrue(sef):
+		findings = append(findings, SecurityFinding{"sef", false, "$sef is off; URLs such as index.php?option=com_users reveal the installed components"})
+	default:
+		findings = append(findings, SecurityFinding{"sef", true, "search engine friendly URLs are on"})
+	}
+	if rewrite, _ := scalarSetting(src, "sef_rewrite"); phpTrue(rewrite) {
+		_, htErr := os.Stat(filepath.Join(cmsPath, ".htaccess"))
+		_, wcErr := os.Stat(filepath.Join(cmsPath, "web.config"))
+		if htErr != nil && wcErr != nil {
+			findings = append(findings, SecurityFinding{"sef_rewrite", false, "$sef_rewrite is on but there is no .htaccess or web.config, so the exploit-blocking rules of Joomla's htaccess.txt are not active"})
+		} else {
+			findings = append(findings, SecurityFinding{"sef_rewrite", true, "$sef_rewrite is on and a rewrite file is present"})
+		}
+	}
+	return findings, nil
+}
+
 // ShowInfo displays general information about the Joomla installation.
 func ShowInfo(cmsPath string) error {
-	cfgPath := filepath.Join(cmsPath, "configuration.php")
-	cfg, _, err := ExtractDBConfig(cfgPath)
+	info, err := Info(cmsPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Joomla Information:")
+	fmt.Printf("DB Type  : %s\n", info.DBType)
+	fmt.Printf("DB Name  : %s\n", info.DBName)
+	fmt.Printf("DB User  : %s\n", info.DBUser)
+	fmt.Printf("DB Host  : %s\n", info.DBHost)
+	fmt.Printf("DB Port  : %d\n", info.DBPort)
+	fmt.Printf("Server   : %s\n", info.Server)
+	fmt.Printf("Prefixes : %v\n", info.Prefixes)
+	return nil
+}
+
+// Info collects the database settings, server and table prefixes of the
+// install, and its version when the version file can be read.
+func Info(cmsPath string) (database.InfoReport, error) {
+	info := database.InfoReport{CMS: "joomla"}
+	cfgPath := ConfigPath(cmsPath)
+	cfg, dbPrefix, err := ExtractDBConfig(cfgPath)
 	if err != nil {
-		return fmt.Errorf("extract Joomla DB config: %w", err)
+		return info, fmt.Errorf("extract Joomla DB config: %w", err)
 	}
 
 	db, err := database.Connect(cfg)
 	if err != nil {
-		return fmt.Errorf("connect to database: %w", err)
+		return info, fmt.Errorf("connect to database: %w", err)
 	}
 	defer db.Close()
 
-	prefixes, _ := IdentifyPrefixes(db)
+	prefixes, err := IdentifyPrefixes(db, cfg.Type)
+	if err != nil {
+		if err := database.Tolerate(fmt.Errorf("identify Joomla prefixes: %w", err)); err != nil {
+			return info, err
+		}
+	}
+	if len(prefixes) > 0 {
+		if err := checkPrefix(dbPrefix, prefixes); err != nil {
+			return info, err
+		}
+	}
 
-	fmt.Println("Joomla Information:")
-	fmt.Printf("DB Type  : %s\n", cfg.Type)
-	fmt.Printf("DB Name  : %s\n", cfg.DBName)
-	fmt.Printf("DB User  : %s\n", cfg.User)
-	fmt.Printf("DB Host  : %s\n", cfg.Host)
-	fmt.Printf("DB Port  : %d\n", cfg.Port)
-	fmt.Printf("Prefixes : %v\n", prefixes)
-	return nil
+	info.DBType = cfg.Type
+	info.DBName = cfg.DBName
+	info.DBUser = cfg.User
+	info.DBHost = cfg.Host
+	info.DBPort = cfg.Port
+	info.Server = database.DescribeServer(db)
+	info.Prefixes = prefixes
+	if info.Version, _, err = GetVersion(cmsPath); err != nil {
+		if err := database.Warnf("%v", err); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
 }
 
 // EditUser allows editing user details in the Joomla database.
-func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
+func EditUser(db *sql.DB, prefix, cmsPath, username string, assumeYes bool) error {
 	// 1) load
+	if err := database.Writable(); err != nil {
+		return err
+	}
 	user, err := GetUserByUsername(db, prefix, username)
 	if err != nil {
 		return fmt.Errorf("get user: %w", err)
@@ -254,18 +1529,62 @@ func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
 	if email == "" {
 		email = user.Email
 	}
+	if email != user.Email {
+		if err := database.ValidateEmail(email); err != nil {
+			return err
+		}
+	}
 
-	fmt.Print("New Password (Enter to keep): ")
-	passIn, _ := reader.ReadString('\n')
-	pass := strings.TrimSpace(passIn)
+	pass := NewPassword
+	if pass == "" {
+		fmt.Print("New Password (Enter to keep): ")
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			// never echo the password to the screen
+			buf, err := term.ReadPassword(fd)
+			fmt.Println()
+			if err != nil {
+				return fmt.Errorf("read password: %w", err)
+			}
+			pass = strings.TrimSpace(string(buf))
+		} else {
+			passIn, _ := reader.ReadString('\n')
+			pass = strings.TrimSpace(passIn)
+		}
+	}
 
 	fmt.Printf("Current Roles: %v\n", user.Roles)
 	fmt.Print("New Roles CSV (Enter to keep): ")
 	rolesIn, _ := reader.ReadString('\n')
 	rolesCSV := strings.TrimSpace(rolesIn)
 
+	var changes []database.FieldChange
+	if name != user.Name {
+		changes = append(changes, database.FieldChange{Field: "Name", Old: user.Name, New: name})
+	}
+	if email != user.Email {
+		changes = append(changes, database.FieldChange{Field: "Email", Old: user.Email, New: email})
+	}
+	if pass != "" {
+		changes = append(changes, database.FieldChange{Field: "Password", Secret: true})
+	}
+	if rolesCSV != "" {
+		var titles []string
+		for _, r := range strings.Split(rolesCSV, ",") {
+			titles = append(titles, strings.TrimSpace(r))
+		}
+		if newRoles := strings.Join(titles, ","); newRoles != strings.Join(user.Roles, ",") {
+			changes = append(changes, database.FieldChange{Field: "Roles", Old: strings.Join(user.Roles, ","), New: newRoles})
+		} else {
+			rolesCSV = ""
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+
 	// 3) begin transaction
-	tx, err := db.Begin()
+	tx, err := database.Begin(db)
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
@@ -277,7 +1596,6 @@ func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
 			tx.Rollback()
 			return fmt.Errorf("hash password: %w", err)
 		}
-		fmt.Println("Hashed password:", hashed)
 
 		res, err := tx.Exec(
 			fmt.Sprintf("UPDATE `%s_users` SET password = ? WHERE id = ?", prefix),
@@ -287,7 +1605,12 @@ func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
 			tx.Rollback()
 			return fmt.Errorf("update password: %w", err)
 		}
-		if n, _ := res.RowsAffected(); n != 1 {
+		if n, err := res.RowsAffected(); err != nil {
+			if err := database.Tolerate(fmt.Errorf("check password update: %w", err)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		} else if n != 1 {
 			tx.Rollback()
 			return fmt.Errorf("password update affected %d rows", n)
 		}
@@ -295,28 +1618,9 @@ func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
 
 	// 5) roles update
 	if rolesCSV != "" {
-		if _, err := tx.Exec(
-			fmt.Sprintf("DELETE FROM `%s_user_usergroup_map` WHERE user_id = ?", prefix),
-			user.ID,
-		); err != nil {
+		if err := replaceRoles(db, tx, prefix, user, strings.Split(rolesCSV, ",")); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("clear roles: %w", err)
-		}
-		for _, r := range strings.Split(rolesCSV, ",") {
-			title := strings.TrimSpace(r)
-			var gid int
-			if err := tx.QueryRow(
-				fmt.Sprintf("SELECT id FROM `%s_usergroups` WHERE title = ?", prefix),
-				title,
-			).Scan(&gid); err == nil {
-				if _, err := tx.Exec(
-					fmt.Sprintf("INSERT INTO `%s_user_usergroup_map` (user_id, group_id) VALUES (?,?)", prefix),
-					user.ID, gid,
-				); err != nil {
-					tx.Rollback()
-					return fmt.Errorf("insert role %q: %w", title, err)
-				}
-			}
+			return err
 		}
 	}
 
@@ -330,13 +1634,26 @@ func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
 			tx.Rollback()
 			return fmt.Errorf("update name/email: %w", err)
 		}
-		if n, _ := res.RowsAffected(); n != 1 {
+		if n, err := res.RowsAffected(); err != nil {
+			if err := database.Tolerate(fmt.Errorf("check name/email update: %w", err)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		} else if n != 1 {
 			tx.Rollback()
 			return fmt.Errorf("name/email update affected %d rows", n)
 		}
 	}
 
-	// 7) commit
+	// 7) confirm and commit
+	ok, err := database.ConfirmChanges(reader, changes, assumeYes)
+	if err != nil || !ok {
+		tx.Rollback()
+		if err == nil {
+			fmt.Println("Aborted, nothing was changed.")
+		}
+		return err
+	}
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit: %w", err)
 	}
@@ -345,40 +1662,334 @@ func EditUser(db *sql.DB, prefix, cmsPath, username string) error {
 	return nil
 }
 
-// GetVersion returns the full Joomla version, e.g. "3.10.6 (Stable)" or "4.4.2 (Stable)".
-func GetVersion(cmsPath string) (version string, relDate string, err error) {
-	// 1) Try the "old" property‑style file (Joomla 2.5 → 3.x < 3.8)
-	oldPath := filepath.Join(cmsPath, "libraries", "cms", "version", "version.php")
-	if buf, readErr := os.ReadFile(oldPath); readErr == nil {
-		content := string(buf)
+// resolveRoles returns the ids of the groups titled titles. Every title is
+// resolved first, so a typo cannot silently drop a group the user should keep.
+func resolveRoles(q queryer, prefix string, titles []string) ([]int, error) {
+	var gids []int
+	var unknown []string
+	for _, r := range titles {
+		title := strings.TrimSpace(r)
+		var gid int
+		err := q.QueryRow(
+			fmt.Sprintf("SELECT id FROM `%s_usergroups` WHERE title = ?", prefix),
+			title,
+		).Scan(&gid)
+		switch {
+		case err == sql.ErrNoRows:
+			unknown = append(unknown, title)
+		case err != nil:
+			return nil, fmt.Errorf("look up role %q: %w", title, err)
+		default:
+			gids = append(gids, gid)
+		}
+	}
+	if len(unknown) > 0 {
+		if !IgnoreUnknownRoles {
+			return nil, fmt.Errorf("unknown roles %s, nothing was changed (use --ignore-unknown-roles to skip them)", strings.Join(unknown, ", "))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: skipping unknown roles %s\n", strings.Join(unknown, ", "))
+	}
+	return gids, nil
+}
+
+// replaceRoles makes the groups titled titles the only groups of user in tx.
+// The caller rolls tx back on error.
+func replaceRoles(db *sql.DB, tx *sql.Tx, prefix string, user UserDetail, titles []string) error {
+	gids, err := resolveRoles(tx, prefix, titles)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		fmt.Sprintf("DELETE FROM `%s_user_usergroup_map` WHERE user_id = ?", prefix),
+		user.ID,
+	); err != nil {
+		return fmt.Errorf("clear roles: %w", err)
+	}
+	for _, gid := range gids {
+		if _, err := tx.Exec(
+			fmt.Sprintf("INSERT INTO `%s_user_usergroup_map` (user_id, group_id) VALUES (?,?)", prefix),
+			user.ID, gid,
+		); err != nil {
+			return fmt.Errorf("insert role %d: %w", gid, err)
+		}
+	}
+	if user.IsSuperUser {
+		return guardLastSuperUser(db, tx, prefix)
+	}
+	return nil
+}
+
+// SetRoles replaces the groups of username with the groups titled titles in
+// one transaction and returns the user's ID and the titles the user had. With
+// dryRun nothing is written; the roles and the last Super User are checked as
+// for the change.
+// An unknown user gives database.ErrUserNotFound.
+func SetRoles(db *sql.DB, prefix, username string, titles []string, dryRun bool) (int64, []string, error) {
+	user, err := GetUserByUsername(db, prefix, username)
+	if err == sql.ErrNoRows {
+		return 0, nil, fmt.Errorf("%w: %s", database.ErrUserNotFound, username)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("get user: %w", err)
+	}
+	if dryRun {
+		if _, err := resolveRoles(db, prefix, titles); err != nil {
+			return 0, nil, err
+		}
+		if !user.IsSuperUser {
+			return int64(user.ID), user.Roles, nil
+		}
+		admin, err := AdminGroups(db, prefix)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, t := range titles {
+			if slices.Contains(admin, strings.TrimSpace(t)) {
+				return int64(user.ID), user.Roles, nil // stays a Super User
+			}
+		}
+		if err := guardLastSuperUser(db, db, prefix, user.ID); err != nil {
+			return 0, nil, err
+		}
+		return int64(user.ID), user.Roles, nil
+	}
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, nil, fmt.Errorf("begin tx: %w", err)
+	}
+	if err := replaceRoles(db, tx, prefix, user, titles); err != nil {
+		tx.Rollback()
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("commit: %w", err)
+	}
+	return int64(user.ID), user.Roles, nil
+}
 
-		// property‑style regexes
-		reRel := regexp.MustCompile(`(?m)public\s+\$RELEASE\s*=\s*'([^']+)';`)
-		reLev := regexp.MustCompile(`(?m)public\s+\$DEV_LEVEL\s*=\s*'([^']+)';`)
-		reStat := regexp.MustCompile(`(?m)public\s+\$DEV_STATUS\s*=\s*'([^']+)';`)
-		reRelDat := regexp.MustCompile(`(?m)public\s+\$RELDATE\s*=\s*'([^']+)';`)
+// KeepSingleAdmin makes the group titled to the only group of every Super
+// User except keep, in one transaction, and returns the demoted accounts with
+// the admin group they were in. keep must be a Super User and to must not
+// grant core.admin, directly or through a parent group. Leaving no active
+// Super User, as when keep is blocked, is refused through database.Guard.
+// With dryRun nothing is written.
+func KeepSingleAdmin(db *sql.DB, prefix, keep, to string, dryRun bool) ([]database.PrivilegedUser, error) {
+	groups, err := SuperUserGroups(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(groups)), ",")
+	args := make([]any, 0, len(groups)+1)
+	for _, g := range groups {
+		args = append(args, g)
+	}
+	args = append(args, to)
+	var found, admin int
+	q := fmt.Sprintf(`SELECT COUNT(DISTINCT g.id), COUNT(a.id)
+                      FROM %[1]s_usergroups g
+                      LEFT JOIN %[1]s_usergroups a ON a.lft <= g.lft AND g.rgt <= a.rgt AND a.id IN (%[2]s)
+                      WHERE g.title = ?`, prefix, placeholders)
+	if err := db.QueryRow(q, args...).Scan(&found, &admin); err != nil {
+		return nil, fmt.Errorf("look up role %q: %w", to, err)
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("role %q does not exist", to)
+	}
+	if admin > 0 {
+		return nil, fmt.Errorf("role %q grants core.admin, choose a lower role with --to", to)
+	}
 
-		get := func(r *regexp.Regexp) string {
-			if m := r.FindStringSubmatch(content); len(m) == 2 {
-				return m[1]
+	admins, err := ListAdmins(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var demoted []database.PrivilegedUser
+	var ids []int
+	kept := false
+	for _, a := range admins {
+		if a.Username == keep {
+			kept = true
+		} else {
+			demoted = append(demoted, a)
+			ids = append(ids, int(a.ID))
+		}
+	}
+	if !kept {
+		return nil, fmt.Errorf("%s is not a Super User, nothing was changed", keep)
+	}
+	if dryRun {
+		if len(ids) > 0 {
+			if err := guardLastSuperUser(db, db, prefix, ids...); err != nil {
+				return nil, err
 			}
-			return ""
 		}
+		return demoted, nil
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	for _, u := range demoted {
+		user := UserDetail{ID: int(u.ID), Username: u.Username, IsSuperUser: true}
+		if err := replaceRoles(db, tx, prefix, user, []string{to}); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("demote %s: %w", u.Username, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return demoted, nil
+}
+
+// MergeUsers folds the account from into the account into and deletes from,
+// in one transaction: into joins the groups of from it is not in yet, and the
+// articles created by from are reassigned. Losing the last active Super User
+// is refused through database.Guard. With dryRun nothing is written and the
+// rows each step would change are counted instead. An unknown user gives
+// database.ErrUserNotFound.
+func MergeUsers(db *sql.DB, prefix, from, into string, dryRun bool) ([]database.MergeStep, error) {
+	if dryRun {
+		return mergeUsers(db, nil, prefix, from, into)
+	}
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	steps, err := mergeUsers(db, tx, prefix, from, into)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return steps, nil
+}
 
-		rel := get(reRel)
-		if rel == "" {
-			return "", "", fmt.Errorf("no RELEASE found in %s", oldPath)
+// mergeUsers makes the changes of MergeUsers in tx, or only counts them on db
+// when tx is nil.
+func mergeUsers(db *sql.DB, tx *sql.Tx, prefix, from, into string) ([]database.MergeStep, error) {
+	var q queryer = db
+	if tx != nil {
+		q = tx
+	}
+	ids := make([]int, 2)
+	blocked := make([]bool, 2)
+	for i, username := range []string{from, into} {
+		err := q.QueryRow(fmt.Sprintf("SELECT id, block FROM %s_users WHERE username = ?", prefix), username).Scan(&ids[i], &blocked[i])
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", database.ErrUserNotFound, username)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read user: %w", err)
 		}
+	}
+	fromID, intoID := ids[0], ids[1]
+	if fromID == intoID {
+		return nil, fmt.Errorf("%s and %s are the same user", from, into)
+	}
 
-		version = rel
-		if lvl := get(reLev); lvl != "" {
-			version += "." + lvl
+	userMap := prefix + "_user_usergroup_map"
+	var steps []database.MergeStep
+	for _, step := range []struct {
+		table, action, query string
+		args                 []any
+		count                string // the rows query would change, for dry runs
+		countArgs            []any
+	}{
+		{userMap, "add groups of " + from + " to " + into,
+			fmt.Sprintf(`INSERT INTO %[1]s (user_id, group_id)
+                         SELECT ?, group_id FROM %[1]s
+                         WHERE user_id = ? AND group_id NOT IN (SELECT group_id FROM %[1]s WHERE user_id = ?)`, userMap),
+			[]any{intoID, fromID, intoID},
+			fmt.Sprintf(`SELECT COUNT(*) FROM %[1]s
+                         WHERE user_id = ? AND group_id NOT IN (SELECT group_id FROM %[1]s WHERE user_id = ?)`, userMap),
+			[]any{fromID, intoID}},
+		{userMap, "drop group memberships of " + from,
+			fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", userMap), []any{fromID},
+			fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE user_id = ?", userMap), []any{fromID}},
+		{prefix + "_content", "reassign created_by",
+			fmt.Sprintf("UPDATE %s_content SET created_by = ? WHERE created_by = ?", prefix), []any{intoID, fromID},
+			fmt.Sprintf("SELECT COUNT(*) FROM %s_content WHERE created_by = ?", prefix), []any{fromID}},
+		{prefix + "_users", "delete " + from,
+			fmt.Sprintf("DELETE FROM %s_users WHERE id = ?", prefix), []any{fromID},
+			fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE id = ?", prefix), []any{fromID}},
+	} {
+		var n int64
+		if tx == nil {
+			if err := db.QueryRow(step.count, step.countArgs...).Scan(&n); err != nil {
+				return nil, fmt.Errorf("count rows in %s: %w", step.table, err)
+			}
+		} else {
+			res, err := tx.Exec(step.query, step.args...)
+			if err != nil {
+				return nil, fmt.Errorf("%s in %s: %w", step.action, step.table, err)
+			}
+			if n, err = res.RowsAffected(); err != nil {
+				return nil, fmt.Errorf("count rows in %s: %w", step.table, err)
+			}
 		}
-		if st := get(reStat); st != "" {
-			version += " (" + st + ")"
+		steps = append(steps, database.MergeStep{Table: step.table, Action: step.action, Rows: n})
+	}
+
+	if tx != nil {
+		if err := guardLastSuperUser(db, tx, prefix); err != nil {
+			return nil, err
 		}
-		relDate = get(reRelDat) // may be empty if not set
-		return version, relDate, nil
+		return steps, nil
+	}
+	// into inherits the groups of from, so the site keeps a Super User when
+	// either was one and into is active
+	supers, err := superUserIDs(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if (supers[fromID] || supers[intoID]) && !blocked[1] {
+		return steps, nil
+	}
+	if err := guardLastSuperUser(db, db, prefix, fromID); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// GetVersion returns the full Joomla version, e.g. "3.10.6 (Stable)" or "4.4.2 (Stable)".
+// The version files are read once per path and run.
+func GetVersion(cmsPath string) (version string, relDate string, err error) {
+	key := filepath.Clean(cmsPath)
+	versionCache.Lock()
+	defer versionCache.Unlock()
+	if v, ok := versionCache.m[key]; ok {
+		return v.version, v.relDate, v.err
+	}
+	version, relDate, err = readVersion(cmsPath)
+	if versionCache.m == nil {
+		versionCache.m = make(map[string]cachedVersion)
+	}
+	versionCache.m[key] = cachedVersion{version, relDate, err}
+	return version, relDate, err
+}
+
+// versionCache memoizes GetVersion per install path for the run, as hashing
+// a password asks for the version every time.
+var versionCache struct {
+	sync.Mutex
+	m map[string]cachedVersion
+}
+
+type cachedVersion struct {
+	version, relDate string
+	err              error
+}
+
+// readVersion reads the version file of the install at cmsPath.
+func readVersion(cmsPath string) (version string, relDate string, err error) {
+	// 1) Try the "old" property‑style file (Joomla 2.5 → 3.x < 3.8)
+	oldPath := filepath.Join(cmsPath, "libraries", "cms", "version", "version.php")
+	if buf, readErr := os.ReadFile(oldPath); readErr == nil {
+		return legacyVersion(oldPath, string(buf))
 	}
 
 	// 2) Fall back to the PSR‑4 constant‑style file (Joomla 3.8+)
@@ -390,8 +2001,44 @@ func GetVersion(cmsPath string) (version string, relDate string, err error) {
 			oldPath, newPath, err,
 		)
 	}
-	content := string(buf)
+	return psr4Version(newPath, string(buf))
+}
+
+// legacyVersion parses the property-style version.php of Joomla 1.5 to 3.7,
+// where Joomla 1.5 still declares the properties with var.
+func legacyVersion(path, content string) (version string, relDate string, err error) {
+	// property‑style regexes
+	reRel := regexp.MustCompile(`(?m)(?:public|var)\s+\$RELEASE\s*=\s*'([^']+)';`)
+	reLev := regexp.MustCompile(`(?m)(?:public|var)\s+\$DEV_LEVEL\s*=\s*'([^']+)';`)
+	reStat := regexp.MustCompile(`(?m)(?:public|var)\s+\$DEV_STATUS\s*=\s*'([^']+)';`)
+	reRelDat := regexp.MustCompile(`(?m)(?:public|var)\s+\$RELDATE\s*=\s*'([^']+)';`)
+
+	get := func(r *regexp.Regexp) string {
+		if m := r.FindStringSubmatch(content); len(m) == 2 {
+			return m[1]
+		}
+		return ""
+	}
+
+	rel := get(reRel)
+	if rel == "" {
+		return "", "", fmt.Errorf("no RELEASE found in %s", path)
+	}
+
+	version = rel
+	if lvl := get(reLev); lvl != "" {
+		version += "." + lvl
+	}
+	if st := get(reStat); st != "" {
+		version += " (" + st + ")"
+	}
+	relDate = get(reRelDat) // may be empty if not set
+	return version, relDate, nil
+}
 
+// psr4Version parses the constant-style libraries/src/Version.php of Joomla
+// 3.8 and later.
+func psr4Version(path, content string) (version string, relDate string, err error) {
 	// constants for Joomla 3.x
 	reCRel := regexp.MustCompile(`(?m)const\s+RELEASE\s*=\s*'([^']+)';`)
 	reCPatch := regexp.MustCompile(`(?m)const\s+DEV_LEVEL\s*=\s*'([^']+)';`)
@@ -428,7 +2075,7 @@ func GetVersion(cmsPath string) (version string, relDate string, err error) {
 	maj := getC(reMajor)
 	min := getC(reMinor)
 	if maj == "" || min == "" {
-		return "", "", fmt.Errorf("could not parse Joomla constants in %s", newPath)
+		return "", "", fmt.Errorf("could not parse Joomla constants in %s", path)
 	}
 	version = maj + "." + min
 	if p := getC(reP4Patch); p != "" && p != "0" {
@@ -441,6 +2088,78 @@ func GetVersion(cmsPath string) (version string, relDate string, err error) {
 	return version, relDate, nil
 }
 
+// manifestVersion reads the version the updater recorded in the
+// administrator/manifests/files/joomla.xml extension manifest.
+func manifestVersion(path, content string) (version string, relDate string, err error) {
+	m := regexp.MustCompile(`<version>\s*([^<]+?)\s*</version>`).FindStringSubmatch(content)
+	if m == nil {
+		return "", "", fmt.Errorf("no <version> found in %s", path)
+	}
+	if d := regexp.MustCompile(`<creationDate>\s*([^<]+?)\s*</creationDate>`).FindStringSubmatch(content); d != nil {
+		relDate = d[1]
+	}
+	return m[1], relDate, nil
+}
+
+// VersionSource is what one version file of an install says.
+type VersionSource struct {
+	File    string `json:"file"`
+	Version string `json:"version,omitempty"`
+	Release string `json:"release,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// VersionSources reads every file Joomla has kept its version in, where
+// GetVersion stops at the first. Files that do not exist are left out. On a
+// partially upgraded install they can disagree, see VersionsDisagree.
+func VersionSources(cmsPath string) []VersionSource {
+	var sources []VersionSource
+	for _, f := range []struct {
+		path  string
+		parse func(path, content string) (string, string, error)
+	}{
+		{filepath.Join(cmsPath, "libraries", "joomla", "version.php"), legacyVersion}, // 1.5
+		{filepath.Join(cmsPath, "libraries", "cms", "version", "version.php"), legacyVersion},
+		{filepath.Join(cmsPath, "libraries", "src", "Version.php"), psr4Version},
+		{filepath.Join(cmsPath, "administrator", "manifests", "files", "joomla.xml"), manifestVersion},
+	} {
+		buf, err := os.ReadFile(f.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		src := VersionSource{File: f.path}
+		if err == nil {
+			src.Version, src.Release, err = f.parse(f.path, string(buf))
+		}
+		if err != nil {
+			src.Error = err.Error()
+		}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
+// VersionsDisagree reports whether the readable sources name different
+// versions. Only the numbers are compared, so 4.4 and 4.4.0 (Stable) agree.
+func VersionsDisagree(sources []VersionSource) bool {
+	numbers := regexp.MustCompile(`^[0-9]+(?:\.[0-9]+)*`)
+	seen := ""
+	for _, src := range sources {
+		v := numbers.FindString(src.Version)
+		if v == "" {
+			continue
+		}
+		for strings.Count(v, ".") > 1 && strings.HasSuffix(v, ".0") {
+			v = strings.TrimSuffix(v, ".0")
+		}
+		if seen != "" && v != seen {
+			return true
+		}
+		seen = v
+	}
+	return false
+}
+
 // parseMajorVersion turns "3.10.6" or "4.2.0 (Stable)" into 3 or 4
 func parseMajorVersion(v string) (int, error) {
 	// split on dot or space
@@ -453,12 +2172,34 @@ func parseMajorVersion(v string) (int, error) {
 	return strconv.Atoi(f[0])
 }
 
+// IgnoreUnknownRoles makes EditUser skip role titles that match no group,
+// with a warning, instead of refusing the whole change.
+var IgnoreUnknownRoles bool
+
+// NewPassword, when set, is the password EditUser applies instead of prompting
+// for one, e.g. read with --password-stdin.
+var NewPassword string
+
+// HashAlgorithm, when set, overrides the password hash chosen from the
+// installed Joomla version: "md5", "bcrypt" or "argon2id".
+var HashAlgorithm string
+
+// HashAlgorithms lists the accepted values of HashAlgorithm.
+var HashAlgorithms = []string{"md5", "bcrypt", "argon2id"}
+
+// hashSchemes maps the HashAlgorithm values to passhash schemes.
+var hashSchemes = map[string]string{
+	"md5":      passhash.NameSaltedMD5,
+	"bcrypt":   passhash.NameBcrypt,
+	"argon2id": passhash.NameArgon2id,
+}
+
 // joomlaHashAuto picks the right algorithm based on the installed Joomla version.
 func joomlaHashAuto(cmsPath, password string) (string, error) {
 	ver, _, err := GetVersion(cmsPath)
 	var major int
 	if err != nil {
-		// Could not read Version.php — assume Joomla 1.5/2.5
+		// Could not read Version.php — assume Joomla 1.5/2.5
 		major = 2
 	} else {
 		major, err = parseMajorVersion(ver)
@@ -467,23 +2208,37 @@ func joomlaHashAuto(cmsPath, password string) (string, error) {
 		}
 	}
 
-	if major < 3 {
-		// MD5+salt for legacy
-		saltBytes := make([]byte, 16)
-		if _, err := rand.Read(saltBytes); err != nil {
-			return "", fmt.Errorf("salt gen: %w", err)
+	// Joomla 4 and 5 can also verify Argon2id hashes, but only write them when
+	// configured to, so that has to be asked for with HashAlgorithm
+	var h passhash.Hasher
+	if HashAlgorithm != "" {
+		if HashAlgorithm == "argon2id" && major < 4 {
+			return "", fmt.Errorf("Joomla %d cannot verify argon2id hashes", major)
 		}
-		salt := hex.EncodeToString(saltBytes)
-		sum := md5.Sum([]byte(password + salt))
-		return fmt.Sprintf("%x:%s", sum, salt), nil
+		h, err = passhash.ByName(hashSchemes[HashAlgorithm])
+	} else {
+		h, err = passhash.For("joomla", major)
 	}
-
-	// bcrypt for 3,4,5
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return "", fmt.Errorf("bcrypt hash: %w", err)
+		return "", err
+	}
+	return h.Hash(password)
+}
+
+// VerifyPassword reports whether candidate is the password of username.
+// bcrypt, Argon2id and the legacy md5:salt hashes are understood; a mismatch
+// returns false without an error.
+func VerifyPassword(db *sql.DB, prefix, username, candidate string) (bool, error) {
+	var hash string
+	q := fmt.Sprintf("SELECT password FROM `%s_users` WHERE username = ?", prefix)
+	if err := db.QueryRow(q, username).Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return false, fmt.Errorf("user %q not found", username)
+		}
+		return false, fmt.Errorf("read password hash: %w", err)
 	}
-	return string(hash), nil
+
+	return passhash.Verify(hash, candidate, passhash.NameBcrypt, passhash.NameArgon2id, passhash.NameSaltedMD5, passhash.NameMD5)
 }
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
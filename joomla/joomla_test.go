@@ -0,0 +1,402 @@
+package joomla
+
+import (
+	"cmsmgmt/database"
+	"cmsmgmt/passhash"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func q(sql string) string { return regexp.QuoteMeta(sql) }
+
+// expectRootRules expects the read of the root asset, which grants core.admin
+// to the stock Super Users group 8.
+func expectRootRules(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(q("SELECT rules FROM jos_assets WHERE parent_id = 0")).
+		WillReturnRows(sqlmock.NewRows([]string{"rules"}).AddRow(`{"core.admin":{"8":1}}`))
+}
+
+// expectMergeUsers expects the lookup of dup (5), merged into alice (3).
+func expectMergeUsers(mock sqlmock.Sqlmock, intoBlocked bool) {
+	mock.ExpectQuery(q("SELECT id, block FROM jos_users WHERE username = ?")).WithArgs("dup").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "block"}).AddRow(5, false))
+	mock.ExpectQuery(q("SELECT id, block FROM jos_users WHERE username = ?")).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "block"}).AddRow(3, intoBlocked))
+}
+
+var mergeSteps = []database.MergeStep{
+	{Table: "jos_user_usergroup_map", Action: "add groups of dup to alice", Rows: 1},
+	{Table: "jos_user_usergroup_map", Action: "drop group memberships of dup", Rows: 2},
+	{Table: "jos_content", Action: "reassign created_by", Rows: 4},
+	{Table: "jos_users", Action: "delete dup", Rows: 1},
+}
+
+func TestMergeUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectMergeUsers(mock, false)
+	mock.ExpectExec(q("INSERT INTO jos_user_usergroup_map (user_id, group_id)")).
+		WithArgs(3, 5, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("DELETE FROM jos_user_usergroup_map WHERE user_id = ?")).
+		WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q("UPDATE jos_content SET created_by = ? WHERE created_by = ?")).
+		WithArgs(3, 5).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(q("DELETE FROM jos_users WHERE id = ?")).
+		WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRootRules(mock)
+	mock.ExpectQuery(q("SELECT COUNT(DISTINCT u.id)")).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectCommit()
+
+	steps, err := MergeUsers(db, "jos", "dup", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(steps, mergeSteps) {
+		t.Errorf("steps = %+v, want %+v", steps, mergeSteps)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// expectMergeCounts expects the counts a dry run makes instead of the writes.
+func expectMergeCounts(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM jos_user_usergroup_map")).WithArgs(5, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM jos_user_usergroup_map WHERE user_id = ?")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(2))
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM jos_content WHERE created_by = ?")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(4))
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM jos_users WHERE id = ?")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+}
+
+// A dry run must not send a single write: MyISAM tables cannot roll one back.
+func TestMergeUsersDryRun(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		supers      []int
+		intoBlocked bool
+		counted     bool // the remaining Super Users are counted
+		remaining   int  // active Super Users besides dup
+		wantGuard   bool
+	}{
+		{"into inherits Super User", []int{5}, false, false, 0, false},
+		{"into already Super User", []int{3}, false, false, 0, false},
+		{"other Super User left", []int{1}, false, true, 1, false},
+		{"last Super User", []int{5}, true, true, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			database.ReadOnly = true
+			defer func() { database.ReadOnly = false }()
+
+			expectMergeUsers(mock, tt.intoBlocked)
+			expectMergeCounts(mock)
+			expectRootRules(mock)
+			supers := sqlmock.NewRows([]string{"user_id"})
+			for _, id := range tt.supers {
+				supers.AddRow(id)
+			}
+			mock.ExpectQuery(q("SELECT DISTINCT m.user_id")).WillReturnRows(supers)
+			if tt.counted {
+				expectRootRules(mock)
+				mock.ExpectQuery(q("SELECT COUNT(DISTINCT u.id)")).WithArgs(8, 5).
+					WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(tt.remaining))
+			}
+
+			steps, err := MergeUsers(db, "jos", "dup", "alice", true)
+			if tt.wantGuard {
+				if err == nil || !strings.Contains(err.Error(), "last active Super User") {
+					t.Errorf("err = %v, want the last Super User refused", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(steps, mergeSteps) {
+					t.Errorf("steps = %+v, want %+v", steps, mergeSteps)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMergeUsersSameUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for range 2 {
+		mock.ExpectQuery(q("SELECT id, block FROM jos_users")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "block"}).AddRow(3, false))
+	}
+	if _, err := MergeUsers(db, "jos", "alice", "Alice", true); err == nil || !strings.Contains(err.Error(), "same user") {
+		t.Errorf("err = %v, want the same user refused", err)
+	}
+}
+
+func TestSetRolesDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.ReadOnly = true
+	defer func() { database.ReadOnly = false }()
+
+	mock.ExpectQuery(q("FROM jos_users u")).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "name", "email", "block", "sendEmail", "roles"}).
+			AddRow(1, "alice", "Alice", "alice@example.com", false, true, "Super Users"))
+	expectRootRules(mock)
+	mock.ExpectQuery(q("SELECT DISTINCT m.user_id")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery(q("SELECT id FROM `jos_usergroups` WHERE title = ?")).WithArgs("Editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	// alice loses the Super Users group and is the only one
+	expectRootRules(mock)
+	mock.ExpectQuery(q("SELECT DISTINCT g.title")).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("Super Users"))
+	expectRootRules(mock)
+	mock.ExpectQuery(q("SELECT COUNT(DISTINCT u.id)")).WithArgs(8, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+
+	_, _, err = SetRoles(db, "jos", "alice", []string{"Editor"}, true)
+	if err == nil || !strings.Contains(err.Error(), "last active Super User") {
+		t.Errorf("err = %v, want the last Super User refused", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A PostgreSQL Joomla schema, listed from pg_tables of the configured schema,
+// next to a WordPress install that must not be taken for Joomla.
+func TestIdentifyPrefixesPostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.Schema = "cms"
+	defer func() { database.Schema = "public" }()
+
+	tables := sqlmock.NewRows([]string{"tablename"})
+	for _, tbl := range []string{
+		"jos_users", "jos_usergroups", "jos_user_usergroup_map", "jos_assets", "jos_content", "jos_session",
+		"j4_users", "j4_usergroups", "j4_user_usergroup_map",
+		"wp_users", "wp_posts", "wp_usermeta",
+	} {
+		tables.AddRow(tbl)
+	}
+	mock.ExpectQuery(q("FROM   pg_catalog.pg_tables")).WithArgs("cms").WillReturnRows(tables)
+
+	prefixes, err := IdentifyPrefixes(db, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"j4", "jos"}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("prefixes = %v, want %v", prefixes, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// joomlaSite writes the version file of the given Joomla release to a new
+// install directory, or none when file is empty.
+func joomlaSite(t *testing.T, file, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if file == "" {
+		return dir
+	}
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestJoomlaHashAuto(t *testing.T) {
+	const legacy, psr4 = "libraries/cms/version/version.php", "libraries/src/Version.php"
+	sites := []struct {
+		name          string
+		file, content string
+		auto          string // scheme written without --joomla-hash
+	}{
+		{"unknown", "", "", passhash.NameSaltedMD5},
+		{"1.5", legacy, "<?php class JVersion { var $RELEASE = '1.5'; var $DEV_LEVEL = '26'; }", passhash.NameSaltedMD5},
+		{"2.5", legacy, "<?php final class JVersion { public $RELEASE = '2.5'; public $DEV_LEVEL = '28'; }", passhash.NameSaltedMD5},
+		{"3.10", psr4, "<?php final class Version { const RELEASE = '3.10'; const DEV_LEVEL = '12'; }", passhash.NameBcrypt},
+		{"4.4", psr4, "<?php final class Version { const MAJOR_VERSION = 4; const MINOR_VERSION = 4; const PATCH_VERSION = 2; }", passhash.NameBcrypt},
+		{"5.1", psr4, "<?php final class Version { const MAJOR_VERSION = 5; const MINOR_VERSION = 1; const PATCH_VERSION = 0; }", passhash.NameBcrypt},
+	}
+	defer func() { HashAlgorithm = "" }()
+	for _, site := range sites {
+		dir := joomlaSite(t, site.file, site.content)
+		for _, algo := range append([]string{""}, HashAlgorithms...) {
+			HashAlgorithm = algo
+			want := site.auto
+			if algo != "" {
+				want = hashSchemes[algo]
+			}
+			hash, err := joomlaHashAuto(dir, "secret")
+			if algo == "argon2id" && (site.auto == passhash.NameSaltedMD5 || site.name == "3.10") {
+				if err == nil {
+					t.Errorf("Joomla %s: argon2id accepted, want refused", site.name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Joomla %s, --joomla-hash %q: %v", site.name, algo, err)
+				continue
+			}
+			h, err := passhash.Identify(hash, hashSchemes["md5"], hashSchemes["bcrypt"], hashSchemes["argon2id"])
+			if err != nil {
+				t.Errorf("Joomla %s, --joomla-hash %q: %v", site.name, algo, err)
+				continue
+			}
+			if got, _ := passhash.ByName(want); got != h {
+				t.Errorf("Joomla %s, --joomla-hash %q: wrote %T, want %s", site.name, algo, h, want)
+			}
+			if ok, err := h.Verify(hash, "secret"); !ok || err != nil {
+				t.Errorf("Joomla %s, --joomla-hash %q: hash does not verify: %v", site.name, algo, err)
+			}
+		}
+	}
+}
+
+func TestExtractDBConfigFormatting(t *testing.T) {
+	want := database.DBConfig{Type: "mysql", Host: "db.example.com", Port: 3307, DBName: "joomla_site",
+		User: "joomla", Password: "s3cret", Charset: database.DefaultCharset, ParseTime: true}
+	for _, file := range []string{"configuration-minified.php", "configuration-comments.php"} {
+		cfg, prefix, err := ExtractDBConfig("testdata/" + file)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, want) || prefix != "jos" {
+			t.Errorf("%s: got %+v, prefix %q, want %+v, prefix jos", file, cfg, prefix, want)
+		}
+	}
+}
+
+// With --db-parse-time=false the MySQL driver hands dates over as text.
+func TestListAdminsTextDates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expectRootRules(mock)
+	mock.ExpectQuery(q("SELECT u.id, u.username, u.email, a.title, u.lastvisitDate")).WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "title", "lastvisitDate"}).
+			AddRow(1, "alice", "alice@example.com", "Super Users", []byte("2024-03-01 10:20:30")).
+			AddRow(2, "bob", "bob@example.com", "Super Users", []byte("0000-00-00 00:00:00")).
+			AddRow(3, "carol", "carol@example.com", "Super Users", nil))
+
+	admins, err := ListAdmins(db, "jos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(admins) != 3 {
+		t.Fatalf("got %d admins, want 3", len(admins))
+	}
+	if want := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC); admins[0].LastLogin == nil || !admins[0].LastLogin.Equal(want) {
+		t.Errorf("alice: last login %v, want %v", admins[0].LastLogin, want)
+	}
+	for _, u := range admins[1:] {
+		if u.LastLogin != nil {
+			t.Errorf("%s: last login %v, want none", u.Username, u.LastLogin)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTouchLastVisitTextDate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("SELECT id, lastvisitDate FROM jos_users WHERE username = ?")).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lastvisitDate"}).AddRow(3, []byte("2024-03-01 10:20:30")))
+	mock.ExpectExec(q("UPDATE jos_users SET lastvisitDate = ? WHERE id = ?")).WithArgs(now, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	previous, err := TouchLastVisit(db, "jos", "alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC); previous == nil || !previous.Equal(want) {
+		t.Errorf("previous visit %v, want %v", previous, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLockStaleTextDates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expectRootRules(mock)
+	mock.ExpectQuery(q("SELECT DISTINCT m.user_id")).WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(q("SELECT id, username, email, registerDate, lastvisitDate")).WithArgs(cutoff, cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "registerDate", "lastvisitDate"}).
+			AddRow(4, "bob", "bob@example.com", []byte("2020-01-02 03:04:05"), []byte("2021-05-06 07:08:09")).
+			AddRow(5, "dave", "dave@example.com", []byte("2020-01-02 03:04:05"), []byte("0000-00-00 00:00:00")))
+
+	stale, err := LockStale(db, "jos", cutoff, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []database.StaleUser{
+		{ID: 4, Username: "bob", Email: "bob@example.com", LastActive: time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)},
+		{ID: 5, Username: "dave", Email: "dave@example.com", LastActive: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("got %+v, want %+v", stale, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,50 @@
+package joomla
+
+import (
+	"cmsmgmt/cms"
+	"cmsmgmt/database"
+	"path/filepath"
+)
+
+func init() {
+	cms.Register("joomla",
+		func(path string) cms.Evidence {
+			if ev := cms.Exists(path, cms.ConfigFound, "configuration.php"); ev != cms.NotFound {
+				return ev
+			}
+			return cms.Exists(path, cms.CoreFiles, filepath.Join("libraries", "src", "Version.php"))
+		},
+		func(path string) (cms.CMS, error) { return Site{Path: path}, nil })
+}
+
+// Site is a Joomla installation registered with the cms package.
+type Site struct {
+	Path string
+}
+
+// Name implements cms.CMS.
+func (Site) Name() string { return "joomla" }
+
+// DBConfig implements cms.CMS.
+func (s Site) DBConfig() (database.DBConfig, error) {
+	cfg, _, err := ExtractDBConfig(ConfigPath(s.Path))
+	return cfg, err
+}
+
+// Version implements cms.CMS.
+func (s Site) Version() (string, error) {
+	v, _, err := GetVersion(s.Path)
+	return v, err
+}
+
+// Release returns the release date of the installed version.
+func (s Site) Release() (string, error) {
+	_, rel, err := GetVersion(s.Path)
+	return rel, err
+}
+
+// ShowInfo implements cms.CMS.
+func (s Site) ShowInfo() error { return ShowInfo(s.Path) }
+
+// Info returns what ShowInfo prints, for JSON and CSV output.
+func (s Site) Info() (database.InfoReport, error) { return Info(s.Path) }
@@ -0,0 +1,11 @@
+<?php
+class JConfig {
+	// public $db = 'old_site';
+	public $dbtype = "mysqli"; // switched from mysql
+	public $host   =   "db.example.com:3307"; # moved in 2023
+	public $user = 'joomla';   /* read-write user */
+	public $password = 's3cret';
+	/* public $db = 'staging'; */
+	public $db = 'joomla_site';
+	public $dbprefix = 'jos_';
+}
@@ -0,0 +1 @@
+<?php class JConfig{public $dbtype='mysqli';public $host='db.example.com:3307';public $user='joomla';public $password='s3cret';public $db='joomla_site';public $dbprefix='jos_';public $secret='abc';}
\ No newline at end of file
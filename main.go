@@ -1,22 +1,79 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"cmsmgmt/cms"
+	"cmsmgmt/database"
 	"cmsmgmt/joomla"
+	"cmsmgmt/mediawiki"
+	"cmsmgmt/typo3"
 	"cmsmgmt/wordpress"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
-	cmsPath    string
-	appVersion = "0.1.21"
+	cmsPath      string
+	cmsPaths     []string
+	dbCharset    string
+	dbCollation  string
+	dbName       string
+	dbParseTime  bool
+	dbPort       int
+	dbTLS        bool
+	noColor      bool
+	readOnly     bool
+	outputFormat string
+	outputFile   string
+	prettyJSON   bool
+	csvDelimiter string
+	outputCloser io.Closer
+	sqlOut       string
+	sqlOutFile   *os.File
+	tablePrefix  string
+	allPrefixes  bool
+	jsonErrors   bool
+	strict       bool
+	gzipOutput   bool
+	configFile   string
+	defaultsFile string
+	clientCreds  database.ClientDefaults
+	commandStart time.Time
+	appVersion   = "0.1.21"
+
+	// set with -ldflags "-X main.gitCommit=... -X main.buildDate=..."; otherwise
+	// taken from the VCS stamp of the build
+	gitCommit string
+	buildDate string
 )
 
+// allPrefixesAnnotation marks the read-only commands that accept --all-prefixes.
+// Everything else writes to the database and must name its install.
+const allPrefixesAnnotation = "allPrefixes"
+
+// multiPathAnnotation marks the commands that accept --path more than once.
+const multiPathAnnotation = "multiPath"
+
+var readOnlyAnnotations = map[string]string{allPrefixesAnnotation: "true", multiPathAnnotation: "true"}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:     "cmsmgmt",
@@ -24,60 +81,440 @@ func main() {
 		Long:    "Content Management System Management - https://github.com/earentir/cmsmgmt",
 		Version: appVersion,
 
-		PersistentPreRunE: func(_ *cobra.Command, _ []string) error {
-			if cmsPath != "" {
-				if _, err := os.Stat(cmsPath); os.IsNotExist(err) {
-					return fmt.Errorf("The specified CMS path does not exist: %s", cmsPath)
+		SilenceErrors: true,
+
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			// flags and arguments are valid by now, later errors don't need the usage text
+			cmd.SilenceUsage = true
+			commandStart = time.Now()
+
+			if len(cmsPaths) > 1 {
+				if cmd.Annotations[multiPathAnnotation] == "" {
+					return withCode(exitUsage, fmt.Errorf("--path can only be given once for commands that change the database"))
+				}
+				if configFile != "" {
+					return withCode(exitUsage, fmt.Errorf("--config-file only applies to a single install, not to several --path values"))
+				}
+			}
+			for _, p := range cmsPaths {
+				if _, err := os.Stat(p); os.IsNotExist(err) {
+					return withCode(exitUsage, fmt.Errorf("the specified CMS path does not exist: %s", p))
+				}
+			}
+			if len(cmsPaths) > 0 {
+				cmsPath = cmsPaths[0]
+			}
+			if defaultsFile != "" {
+				d, err := database.ReadDefaultsFile(expandHome(defaultsFile))
+				if err != nil {
+					return withCode(exitUsage, fmt.Errorf("cannot read --defaults-file: %w", err))
+				}
+				clientCreds = d
+			}
+			if configFile != "" {
+				if _, err := os.Stat(configFile); err != nil {
+					return withCode(exitUsage, fmt.Errorf("cannot read --config-file: %w", err))
+				}
+			}
+			switch outputFormat {
+			case "text", "json", "csv":
+			default:
+				return withCode(exitUsage, fmt.Errorf("unsupported output format: %s", outputFormat))
+			}
+			if database.ConnectTimeout < 0 || database.QueryTimeout < 0 {
+				return withCode(exitUsage, fmt.Errorf("--timeout and --query-timeout must not be negative"))
+			}
+			if !slices.Contains(tableStyles, tableStyle) {
+				return withCode(exitUsage, fmt.Errorf("unsupported table style %q, use plain, ascii or markdown", tableStyle))
+			}
+			comma, err := parseCSVDelimiter(csvDelimiter)
+			if err != nil {
+				return withCode(exitUsage, err)
+			}
+			csvComma = comma
+			if outputFile != "" {
+				if !cmd.Flags().Changed("output") {
+					outputFormat = formatFromFile(outputFile)
+				}
+				if outputFormat == "text" {
+					return withCode(exitUsage, fmt.Errorf("--file requires --output json or csv"))
+				}
+			}
+			compress := gzipOutput || strings.HasSuffix(outputFile, ".gz")
+			if compress && outputFormat == "text" {
+				return withCode(exitUsage, fmt.Errorf("--gzip requires --output json or csv"))
+			}
+			// colors only ever go to a terminal, see https://no-color.org
+			if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+				database.Color = outputFormat == "text" && !noColor && os.Getenv("NO_COLOR") == ""
+			}
+			if allPrefixes && tablePrefix == "" && cmd.Annotations[allPrefixesAnnotation] == "" {
+				return withCode(exitUsage, fmt.Errorf("--all-prefixes is only allowed for read-only commands; use --prefix to pick one install"))
+			}
+			if cmd.Flags().Changed("db-port") && (dbPort < 1 || dbPort > 65535) {
+				return withCode(exitUsage, fmt.Errorf("--db-port must be between 1 and 65535"))
+			}
+			if cmd.Flags().Changed("db-name") && strings.TrimSpace(dbName) == "" {
+				return withCode(exitUsage, fmt.Errorf("--db-name cannot be empty"))
+			}
+			if database.Schema == "" {
+				return withCode(exitUsage, fmt.Errorf("--db-schema cannot be empty"))
+			}
+			if database.SSH.Host == "" && (cmd.Flags().Changed("ssh-user") || database.SSH.KeyFile != "" || database.SSH.KnownHosts != "") {
+				return withCode(exitUsage, fmt.Errorf("--ssh-user, --ssh-key and --ssh-known-hosts require --ssh-host"))
+			}
+			if database.SSH.Host != "" && database.SSH.User == "" {
+				// like ssh, default to the local login name
+				u, err := user.Current()
+				if err != nil {
+					return withCode(exitUsage, fmt.Errorf("--ssh-host requires --ssh-user: %w", err))
+				}
+				database.SSH.User = u.Username
+			}
+			database.SSH.KeyFile = expandHome(database.SSH.KeyFile)
+			database.SSH.KnownHosts = expandHome(database.SSH.KnownHosts)
+			if database.Pool.MaxOpen == 1 || database.Pool.MaxOpen < 0 {
+				return withCode(exitUsage, fmt.Errorf("--db-max-open must be 0 (unlimited) or at least 2"))
+			}
+			// the files are created last, so a usage error never truncates one
+			if outputFile != "" {
+				f, err := openOutput(outputFile)
+				if err != nil {
+					return err
+				}
+				outputCloser = f
+			}
+			if compress {
+				outputCloser = compressOutput(outputCloser)
+			}
+			if sqlOut != "" {
+				f, err := os.Create(sqlOut)
+				if err != nil {
+					return withCode(exitUsage, fmt.Errorf("cannot create --sql-out file: %w", err))
 				}
+				sqlOutFile = f
+				database.Transcript = f
+			}
+			if database.Force {
+				fmt.Fprintln(os.Stderr, "WARNING: --force is set: confirmations are skipped and safety checks are overridden.")
 			}
+			database.ReadOnly = readOnly
+			database.Strict = strict
+			return nil
+		},
+		PersistentPostRunE: func(_ *cobra.Command, _ []string) error {
+			database.Phase("total", commandStart)
 			return nil
 		},
 	}
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return withCode(exitUsage, err)
+	})
 
-	rootCmd.PersistentFlags().StringVarP(&cmsPath, "path", "p", "", "Path to the CMS root directory")
+	rootCmd.PersistentFlags().StringArrayVarP(&cmsPaths, "path", "p", nil, "Path to the CMS root directory; repeat to run a read-only command on several sites")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config-file", "", "Exact path of the CMS configuration file, for moved or renamed configs")
+	rootCmd.PersistentFlags().StringVar(&defaultsFile, "defaults-file", "", "MySQL option file (e.g. ~/.my.cnf) whose [client] host, port, user and password override the CMS config")
+	rootCmd.PersistentFlags().StringVar(&dbCharset, "db-charset", database.DefaultCharset, "MySQL connection charset")
+	rootCmd.PersistentFlags().StringVar(&dbCollation, "db-collation", "", "MySQL connection collation (server default if empty)")
+	rootCmd.PersistentFlags().BoolVar(&dbParseTime, "db-parse-time", true, "Parse MySQL DATE/DATETIME values into time.Time")
+	rootCmd.PersistentFlags().StringVar(&dbName, "db-name", "", "Database to connect to instead of the one named in the CMS configuration, e.g. a staging clone")
+	rootCmd.PersistentFlags().IntVar(&dbPort, "db-port", 0, "Database port, overriding the one from the CMS configuration")
+	rootCmd.PersistentFlags().BoolVar(&dbTLS, "db-tls", false, "Encrypt the database connection with TLS, verifying the server certificate")
+	rootCmd.PersistentFlags().DurationVar(&database.ConnectTimeout, "timeout", database.ConnectTimeout, "How long to wait for the database server when connecting (0 for no limit)")
+	rootCmd.PersistentFlags().DurationVar(&database.QueryTimeout, "query-timeout", database.QueryTimeout, "How long a single query may run, e.g. 5m for heavy listings on big sites (0 for no limit)")
+	rootCmd.PersistentFlags().BoolVar(&database.LocalOnly, "connect-only-localhost", false, "Refuse to connect to a database server other than localhost, a loopback address or a socket")
+	rootCmd.PersistentFlags().BoolVar(&database.Insecure, "insecure", false, "Accept an unencrypted connection to a remote database server (needed with --strict)")
+	rootCmd.PersistentFlags().StringVar(&database.Schema, "db-schema", database.Schema, "PostgreSQL schema holding the CMS tables")
+	rootCmd.PersistentFlags().IntVar(&database.Pool.MaxOpen, "db-max-open", database.Pool.MaxOpen, "Maximum open database connections (0 for unlimited)")
+	rootCmd.PersistentFlags().IntVar(&database.Pool.MaxIdle, "db-max-idle", database.Pool.MaxIdle, "Maximum idle database connections")
+	rootCmd.PersistentFlags().DurationVar(&database.Pool.ConnLifetime, "db-conn-lifetime", database.Pool.ConnLifetime, "Maximum time a database connection is reused (0 for no limit)")
+	rootCmd.PersistentFlags().StringVar(&database.SSH.Host, "ssh-host", "", "Reach the database through an SSH tunnel to this bastion (host or host:port)")
+	rootCmd.PersistentFlags().StringVar(&database.SSH.User, "ssh-user", os.Getenv("USER"), "User on the SSH bastion (default the local user)")
+	rootCmd.PersistentFlags().StringVar(&database.SSH.KeyFile, "ssh-key", "", "Private key for the SSH bastion (ssh-agent is used if empty)")
+	rootCmd.PersistentFlags().StringVar(&database.SSH.KnownHosts, "ssh-known-hosts", "", "known_hosts file to verify the bastion against (default ~/.ssh/known_hosts)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "Output format: text, json or csv")
+	rootCmd.PersistentFlags().BoolVar(&prettyJSON, "pretty", false, "Indent JSON output")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Never color text output (also set by the NO_COLOR environment variable)")
+	rootCmd.PersistentFlags().StringVar(&tableStyle, "table-style", tableStyle, "How text output draws tables: plain, ascii or markdown")
+	rootCmd.PersistentFlags().StringVar(&csvDelimiter, "csv-delimiter", ",", `Field delimiter of CSV output, a single character such as ";" or \t for tab`)
+	rootCmd.PersistentFlags().BoolVar(&csvNoHeader, "no-header", false, "Leave the header row out of CSV output")
+	rootCmd.PersistentFlags().StringVar(&outputFile, "file", "", "Write json/csv output to this file instead of stdout (format from the extension unless --output is set, gzipped for .gz)")
+	rootCmd.PersistentFlags().BoolVar(&gzipOutput, "gzip", false, "Gzip json/csv output")
+	rootCmd.PersistentFlags().BoolVar(&database.MaskEmails, "mask-email", false, "Partially mask e-mail addresses in listings and exports, e.g. j**n@e******.com")
+	rootCmd.PersistentFlags().BoolVar(&database.AllowInvalidEmail, "allow-invalid-email", false, "Write e-mail addresses even when they are malformed, e.g. to restore legacy data")
+	rootCmd.PersistentFlags().StringVar(&sqlOut, "sql-out", "", "Write the statements a command would execute to this .sql file instead of changing the database")
+	rootCmd.PersistentFlags().StringVar(&tablePrefix, "prefix", "", "Table prefix to use when the database holds several installs")
+	rootCmd.PersistentFlags().BoolVar(&allPrefixes, "all-prefixes", false, "Report on every install in the database, one section per table prefix (list and info commands only)")
+	rootCmd.PersistentFlags().BoolVar(&database.Force, "force", false, "For unattended runs: imply --yes and override safety checks such as keeping the last administrator (prints a warning)")
+	rootCmd.PersistentFlags().BoolVar(&readOnly, "read-only", false, "Refuse every database write (safe mode for audits)")
+	rootCmd.PersistentFlags().BoolVar(&strict, "strict", false, "Fail instead of warning about inconsistent data")
+	rootCmd.PersistentFlags().BoolVar(&jsonErrors, "json-errors", false, "Report errors on stderr as JSON objects")
+	rootCmd.PersistentFlags().BoolVar(&database.Profile, "profile", false, "Print how long detection, connecting, prefix identification and the queries took to stderr")
+	rootCmd.PersistentFlags().BoolVarP(&database.Verbose, "verbose", "v", false, "Print the database DSN (password masked) before connecting")
+
+	database.Override = func(cfg *database.DBConfig) {
+		clientCreds.Apply(cfg)
+
+		flags := rootCmd.PersistentFlags()
+		if flags.Changed("db-charset") {
+			cfg.Charset = dbCharset
+		}
+		if flags.Changed("db-collation") {
+			cfg.Collation = dbCollation
+		}
+		if flags.Changed("db-parse-time") {
+			cfg.ParseTime = dbParseTime
+		}
+		if flags.Changed("db-name") {
+			cfg.DBName = dbName
+		}
+		if flags.Changed("db-port") {
+			cfg.Port = dbPort
+		}
+		if flags.Changed("db-tls") {
+			cfg.TLS = dbTLS
+		}
+	}
 
 	usersCmd := &cobra.Command{
 		Use:   "users",
 		Short: "User management commands",
 	}
 
+	var onlyBlocked, includeBlocked, noRoles bool
+	var registeredSince, registeredBefore string
+	var emailDomains []string
 	listCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List users",
-		Run: func(_ *cobra.Command, _ []string) {
-			cmsType := detectCMS()
-			if cmsType == "" {
-				log.Fatal("Unable to detect CMS type. Make sure you're in the correct directory or specify the correct path using the -p flag.")
+		Use:         "list",
+		Short:       "List users",
+		Annotations: readOnlyAnnotations,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			var filter database.UserFilter
+			switch {
+			case onlyBlocked && !includeBlocked:
+				return withCode(exitUsage, fmt.Errorf("--only-blocked cannot be combined with --include-blocked=false"))
+			case onlyBlocked:
+				filter.Blocked = database.BlockedOnly
+			case !includeBlocked:
+				filter.Blocked = database.BlockedExclude
+			}
+			filter.NoRoles = noRoles
+			if registeredSince != "" {
+				if filter.Since, err = parseDate(registeredSince); err != nil {
+					return withCode(exitUsage, fmt.Errorf("--since: %w", err))
+				}
+			}
+			if registeredBefore != "" {
+				if filter.Before, err = parseDate(registeredBefore); err != nil {
+					return withCode(exitUsage, fmt.Errorf("--before: %w", err))
+				}
+			}
+			if !filter.Since.IsZero() && !filter.Before.IsZero() && !filter.Since.Before(filter.Before) {
+				return withCode(exitUsage, fmt.Errorf("--since must be earlier than --before"))
+			}
+			for _, d := range emailDomains {
+				d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+				if d == "" || strings.ContainsAny(d, "@ ") {
+					return withCode(exitUsage, fmt.Errorf("--email-domain wants a domain such as example.com"))
+				}
+				filter.EmailDomains = append(filter.EmailDomains, d)
+			}
+			if noRoles && filter.Blocked != database.BlockedAny && cmsType == "wordpress" {
+				return withCode(exitUsage, fmt.Errorf("--no-roles cannot be combined with blocked filters for WordPress, which derives blocking from roles"))
+			}
+			if len(listFields) > 0 {
+				var header []string
+				switch {
+				case cmsType == "wordpress" && noRoles:
+					header = wordpressBasicUserHeader
+				case cmsType == "wordpress":
+					header = wordpressUserHeader
+				case cmsType == "joomla" && noRoles:
+					header = joomlaBasicUserHeader
+				case cmsType == "joomla":
+					header = joomlaUserHeader
+				default:
+					return unsupported("--fields", cmsType)
+				}
+				if _, err := fieldIndexes(header, listFields); err != nil {
+					return withCode(exitUsage, fmt.Errorf("--fields: %w", err))
+				}
 			}
 
-			var err error
 			switch cmsType {
 			case "wordpress":
-				err = wordpress.ProcessWordPress(cmsPath)
+				if outputFormat != "text" || len(listFields) > 0 {
+					err = listWordPressData(cmd.Context(), filter)
+				} else {
+					err = wordpress.ProcessWordPress(cmd.Context(), cmsPath, filter)
+				}
 			case "joomla":
-				db, cfg, defaultPrefix, err2 := joomla.ProcessJoomla(cmsPath)
-				if err2 == nil {
-					fmt.Printf("Joomla DB Name: %s\n", cfg.DBName)
-					fmt.Printf("Joomla DB User: %s\n", cfg.User)
-					fmt.Printf("Identified Joomla table prefixes: %v\n", defaultPrefix)
-
-					users, err3 := joomla.ListUsers(db, defaultPrefix)
-					if err3 != nil {
-						log.Printf("list users for prefix %s: %v", defaultPrefix, err3)
-						fmt.Println(fmt.Errorf("list users for prefix %s: %w", defaultPrefix, err3))
-					} else {
-						fmt.Printf("\nUsers for prefix '%s':\n", defaultPrefix)
-						for _, u := range users {
-							fmt.Printf("ID:%d  Username:%s  Name:%s  Email:%s  Roles:%v\n", u.ID, u.Username, u.Name, u.Email, u.Roles)
-						}
+				err = listJoomla(filter)
+			case "typo3":
+				err = listTYPO3(filter)
+			case "mediawiki":
+				if !filter.IsZero() {
+					err = withCode(exitUnsupported, fmt.Errorf("blocked, date and e-mail domain filters and --no-roles are not supported for MediaWiki"))
+				} else {
+					err = listMediaWiki()
+				}
+			}
+
+			if err != nil {
+				return fmt.Errorf("processing %s: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	listCmd.Flags().BoolVar(&onlyBlocked, "only-blocked", false, "Only list blocked users (WordPress: users without a role)")
+	listCmd.Flags().BoolVar(&noRoles, "no-roles", false, "Skip the role lookups and only list id, username, name and e-mail (faster on large sites)")
+	listCmd.Flags().BoolVar(&includeBlocked, "include-blocked", true, "Include blocked users; set to false to list active users only")
+	listCmd.Flags().StringVar(&registeredSince, "since", "", "Only users registered at or after this date, e.g. 2024-01-01")
+	listCmd.Flags().StringVar(&registeredBefore, "before", "", "Only users registered before this date, e.g. 2024-06-01")
+	listCmd.Flags().StringSliceVar(&emailDomains, "email-domain", nil, "Only users with an e-mail address on this domain, e.g. example.com; repeat or comma-separate for several")
+	listCmd.Flags().StringSliceVar(&listFields, "fields", nil, "Comma-separated columns to output, e.g. id,username,email (WordPress and Joomla; default all)")
+
+	exportCmd := &cobra.Command{
+		Use:         "export",
+		Short:       "Export all users as JSON or CSV, record by record",
+		Annotations: readOnlyAnnotations,
+		Long: "Export every user to stdout or --file. The format follows --output, or the\n" +
+			"file extension (users.csv, users.json.gz); text output is exported as JSON.\n" +
+			"With --anonymize, logins, names and e-mail addresses are replaced by stable\n" +
+			"pseudonyms and profile meta is dropped; IDs and roles are kept.",
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			// before the run, so several --path values are joined as JSON
+			if outputFormat == "text" {
+				outputFormat = "json"
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if includeMeta {
+				switch {
+				case cmsType != "wordpress":
+					return unsupported("users export --include-meta", cmsType)
+				case anonymize:
+					return withCode(exitUsage, fmt.Errorf("--include-meta cannot be combined with --anonymize, the meta holds personal data"))
+				case outputFormat == "csv":
+					if err := database.Warnf("--include-meta is ignored for CSV output, which has no place for nested meta; use JSON"); err != nil {
+						return withCode(exitUsage, err)
 					}
+					includeMeta = false
+				}
+			}
+
+			if err := exportUsers(cmd.Context(), cmsType); err != nil {
+				return fmt.Errorf("exporting %s users: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	exportCmd.Flags().BoolVar(&anonymize, "anonymize", false, "Replace logins, names and e-mail addresses with stable pseudonyms and drop profile meta")
+	exportCmd.Flags().BoolVar(&includeMeta, "include-meta", false, "WordPress: add every usermeta row of each user as a nested meta object (JSON only; can be large)")
+
+	var countRole string
+	var countByRole bool
+	countCmd := &cobra.Command{
+		Use:         "count",
+		Short:       "Count users, optionally per role",
+		Annotations: readOnlyAnnotations,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if countRole != "" && countByRole {
+				return withCode(exitUsage, fmt.Errorf("--role cannot be combined with --by-role"))
+			}
+
+			if err := countUsers(cmsType, countRole, countByRole); err != nil {
+				return fmt.Errorf("counting %s users: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	countCmd.Flags().StringVar(&countRole, "role", "", "Only count users with this role (WordPress slug or Joomla group title)")
+	countCmd.Flags().BoolVar(&countByRole, "by-role", false, "Break the count down per role")
+
+	// create and verify read a piped password anyway; there the flag makes sure
+	// they never stop at a prompt, edit needs it to change the password at all
+	var passwordStdin bool
+	passwordStdinUsage := "Read the password from the first line of stdin and fail instead of prompting when stdin is a terminal"
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify [USERNAME]",
+		Short: "Check a password against the stored hash without changing it",
+		Long: "Prompt for a password (not echoed) and check it against the user's stored hash.\n" +
+			"When stdin is not a terminal the first line is read instead. Exits 1 on mismatch.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			username := args[0]
+			if passwordStdin {
+				if err := requirePipedStdin(); err != nil {
+					return err
 				}
-				err = err2
 			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := verifyPassword(cmsType, username); err != nil {
+				return fmt.Errorf("verifying %s password: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	verifyCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, passwordStdinUsage)
+
+	adminsCmd := &cobra.Command{
+		Use:         "admins",
+		Short:       "List the accounts with full administrative rights",
+		Annotations: readOnlyAnnotations,
+		Long: "List WordPress users whose role grants manage_options, or Joomla users in a group\n" +
+			"granted core.admin on the root asset, with their e-mail and last login.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := listAdmins(cmd.Context(), cmsType); err != nil {
+				return fmt.Errorf("listing %s admins: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
 
+	capabilitiesCmd := &cobra.Command{
+		Use:         "capabilities [USERNAME]",
+		Short:       "Show every role and capability a WordPress user holds",
+		Annotations: readOnlyAnnotations,
+		Long: "Decode the user's <prefix>_capabilities and <prefix>_user_level meta and expand\n" +
+			"each role into the capabilities it grants according to <prefix>_user_roles.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			cmsType, err := requireCMS()
 			if err != nil {
-				log.Printf("Error processing %s: %v", cmsType, err)
+				return err
+			}
+
+			if err := showCapabilities(cmsType, args[0]); err != nil {
+				return fmt.Errorf("showing %s capabilities: %w", cmsType, err)
 			}
+			return nil
 		},
 	}
 
@@ -89,117 +526,3491 @@ func main() {
 		},
 	}
 
+	var editYes bool
 	editCmd := &cobra.Command{
 		Use:   "edit [USERNAME]",
 		Short: "Edit user details",
 		Args:  cobra.ExactArgs(1),
-		Run: func(_ *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			username := args[0]
-			cmsType := detectCMS()
-			if cmsType == "" {
-				log.Fatal("Unable to detect CMS type. Make sure you're in the correct directory or specify the correct path using the -p flag.")
+			if joomla.HashAlgorithm != "" && !slices.Contains(joomla.HashAlgorithms, joomla.HashAlgorithm) {
+				return withCode(exitUsage, fmt.Errorf("--joomla-hash must be one of %s", strings.Join(joomla.HashAlgorithms, ", ")))
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if joomla.HashAlgorithm != "" && cmsType != "joomla" {
+				return withCode(exitUsage, fmt.Errorf("--joomla-hash only applies to Joomla sites"))
+			}
+			if passwordStdin {
+				if cmsType != "joomla" {
+					return withCode(exitUsage, fmt.Errorf("--password-stdin only applies to Joomla sites, WordPress edit does not change passwords"))
+				}
+				// read before the other prompts, which take the following lines
+				if joomla.NewPassword, err = readSecret("New password: "); err != nil {
+					return err
+				}
+				if joomla.NewPassword == "" {
+					return withCode(exitUsage, fmt.Errorf("the password must not be empty"))
+				}
 			}
 
-			var err error
 			switch cmsType {
 			case "wordpress":
-				err = wordpress.EditUser(cmsPath, username)
+				var db *sql.DB
+				var prefixes []string
+				if db, _, prefixes, err = wordpress.OpenWordPress(cmsPath); err == nil {
+					defer db.Close()
+					var prefix string
+					if prefix, err = pickPrefix(prefixes); err == nil {
+						err = wordpress.EditUser(cmd.Context(), db, prefix, username, editYes || database.Force)
+					}
+				}
 			case "joomla":
-				db, _, defaultPrefix, err2 := joomla.ProcessJoomla(cmsPath)
+				db, _, defaultPrefix, err2 := processJoomla()
 				if err2 == nil {
-					err = joomla.EditUser(db, defaultPrefix, cmsPath, username)
+					defer db.Close()
+					err = joomla.EditUser(db, defaultPrefix, cmsPath, username, editYes || database.Force)
 				} else {
 					err = err2
 				}
+			default:
+				err = unsupported("editing users", cmsType)
 			}
 
 			if err != nil {
-				log.Printf("Error editing %s user: %v", cmsType, err)
+				return fmt.Errorf("editing %s user: %w", cmsType, err)
 			}
+			return nil
 		},
 	}
 
-	usersCmd.AddCommand(listCmd)
-	usersCmd.AddCommand(userInfoCmd)
-	usersCmd.AddCommand(editCmd)
+	editCmd.Flags().BoolVarP(&editYes, "yes", "y", false, "Apply the changes without asking for confirmation; safety checks still apply (see --force)")
+	editCmd.Flags().BoolVar(&joomla.IgnoreUnknownRoles, "ignore-unknown-roles", false, "Joomla: skip role titles that match no group instead of refusing the change")
+	editCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Joomla: read the new password from the first line of stdin, before the other prompts")
+	editCmd.Flags().StringVar(&joomla.HashAlgorithm, "joomla-hash", "", "Hash new Joomla passwords with md5, bcrypt or argon2id instead of the version's default")
 
-	infoCmd := &cobra.Command{
-		Use:   "info",
-		Short: "Show CMS information",
+	resetLinkCmd := &cobra.Command{
+		Use:   "reset-link [USERNAME]",
+		Short: "Generate a WordPress password reset link",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			username := args[0]
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "wordpress" {
+				return unsupported("reset-link", cmsType)
+			}
+
+			link, err := wordpressResetLink(username)
+			if err != nil {
+				return fmt.Errorf("generating %s reset link: %w", cmsType, err)
+			}
+			fmt.Println(link)
+			return nil
+		},
 	}
 
-	dbCmd := &cobra.Command{
-		Use:   "db",
-		Short: "Show db information",
-		Run: func(_ *cobra.Command, _ []string) {
-			cmsType := detectCMS()
-			if cmsType == "" {
-				log.Fatal("Unable to detect CMS type. Make sure you're in the correct directory or specify the correct path using the -p flag.")
+	var rewriteFrom, rewriteTo, rewriteMap string
+	var rewriteDryRun bool
+	rewriteEmailCmd := &cobra.Command{
+		Use:   "rewrite-email",
+		Short: "Rewrite user e-mail domains or addresses in bulk",
+		Long: "Rewrite user e-mails in a single transaction, either replacing a domain suffix\n" +
+			"(--from @old.com --to @new.com) or applying exact rewrites from a CSV file of\n" +
+			"oldemail,newemail rows (--map).",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if (rewriteMap == "") == (rewriteFrom == "" || rewriteTo == "") {
+				return withCode(exitUsage, fmt.Errorf("specify either --from and --to, or --map"))
 			}
 
-			var err error
-			switch cmsType {
-			case "wordpress":
-				err = wordpress.ShowInfo(cmsPath)
-			case "joomla":
-				err = joomla.ShowInfo(cmsPath)
+			if err := rewriteEmails(cmsType, rewriteFrom, rewriteTo, rewriteMap, rewriteDryRun); err != nil {
+				return fmt.Errorf("rewriting %s e-mails: %w", cmsType, err)
 			}
+			return nil
+		},
+	}
+	rewriteEmailCmd.Flags().StringVar(&rewriteFrom, "from", "", "E-mail suffix to replace, e.g. @old.com")
+	rewriteEmailCmd.Flags().StringVar(&rewriteTo, "to", "", "Replacement suffix, e.g. @new.com")
+	rewriteEmailCmd.Flags().StringVar(&rewriteMap, "map", "", "CSV file of oldemail,newemail rows for exact rewrites")
+	rewriteEmailCmd.Flags().BoolVar(&rewriteDryRun, "dry-run", false, "Show the changes without writing them")
 
+	var duplicateField string
+	duplicatesCmd := &cobra.Command{
+		Use:   "duplicates",
+		Short: "Find accounts sharing an e-mail address or username",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
 			if err != nil {
-				log.Printf("Error showing %s info: %v", cmsType, err)
+				return err
 			}
+			if duplicateField != "email" && duplicateField != "username" {
+				return withCode(exitUsage, fmt.Errorf("--field must be email or username, got %q", duplicateField))
+			}
+
+			if err := showDuplicates(cmsType, duplicateField); err != nil {
+				return fmt.Errorf("finding duplicate %s users: %w", cmsType, err)
+			}
+			return nil
 		},
 	}
+	duplicatesCmd.Flags().StringVar(&duplicateField, "field", "email", "Field to check for duplicates: email or username")
 
-	versionCmd := &cobra.Command{
-		Use:   "version",
-		Short: "Show CMS version information",
-		Run: func(_ *cobra.Command, _ []string) {
-			cmsType := detectCMS()
-			if cmsType == "" {
-				log.Fatal("Unable to detect CMS type. Make sure you're in the correct directory or specify the correct path using the -p flag.")
+	var logoutAll bool
+	logoutCmd := &cobra.Command{
+		Use:   "logout [USERNAME]",
+		Short: "End a user's Joomla sessions (or everyone's with --all)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "joomla" {
+				return unsupported("logout", cmsType)
+			}
+			if (len(args) == 1) == logoutAll {
+				return withCode(exitUsage, fmt.Errorf("specify either a USERNAME or --all"))
 			}
 
-			var version, rel string
-			var err error
-			switch cmsType {
-			case "wordpress":
-				version, err = wordpress.GetVersion(cmsPath)
-			case "joomla":
-				version, rel, err = joomla.GetVersion(cmsPath)
+			var username string
+			if len(args) == 1 {
+				username = args[0]
+			}
+			db, _, prefix, err := processJoomla()
+			if err != nil {
+				return fmt.Errorf("logging out %s users: %w", cmsType, err)
 			}
+			defer db.Close()
 
+			n, err := joomla.Logout(db, prefix, username)
 			if err != nil {
-				log.Printf("Error showing %s version: %v", cmsType, err)
-			} else {
-				fmt.Printf("%s Version: %s\n", cmsType, version)
-				if cmsType == "joomla" {
-					fmt.Printf("Release: %s\n", rel)
+				return fmt.Errorf("logging out %s users: %w", cmsType, err)
+			}
+			fmt.Printf("%d sessions removed\n", n)
+			return nil
+		},
+	}
+	logoutCmd.Flags().BoolVar(&logoutAll, "all", false, "Remove every session")
+
+	var createEmail, createDisplay string
+	var createRoles []string
+	var createOpts joomla.CreateOptions
+	createCmd := &cobra.Command{
+		Use:   "create [LOGIN]",
+		Short: "Create a WordPress or Joomla user",
+		Long: "Create a user with the given roles. The password is prompted for (not echoed),\n" +
+			"or read from the first line of stdin when it is not a terminal. New Joomla users\n" +
+			"follow the site's registration settings: they join the new user group and stay\n" +
+			"blocked with an activation token when the site requires activation.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(args[0]) == "" {
+				return withCode(exitUsage, fmt.Errorf("the login must not be empty"))
+			}
+			if createEmail == "" {
+				return withCode(exitUsage, fmt.Errorf("--email is required"))
+			}
+			if err := database.ValidateEmail(createEmail); err != nil {
+				return withCode(exitUsage, err)
+			}
+			if passwordStdin {
+				if err := requirePipedStdin(); err != nil {
+					return err
 				}
 			}
+
+			if err := createUser(cmsType, args[0], createEmail, createDisplay, createRoles, createOpts); err != nil {
+				return fmt.Errorf("creating %s user: %w", cmsType, err)
+			}
+			return nil
 		},
 	}
+	createCmd.Flags().StringVar(&createEmail, "email", "", "E-mail address of the new user")
+	createCmd.Flags().StringVar(&createDisplay, "display-name", "", "Display name (defaults to the login)")
+	createCmd.Flags().StringSliceVar(&createRoles, "role", nil, "Role to grant, a WordPress slug or Joomla group title; repeat for several (default subscriber, or the Joomla new user group)")
+	createCmd.Flags().BoolVar(&createOpts.Activate, "activate", false, "Joomla: activate the account now even if the site requires activation")
+	createCmd.Flags().BoolVar(&createOpts.RequireReset, "require-reset", false, "Require a new password at the first login (Joomla requireReset, WordPress --reset-meta-key meta)")
+	createCmd.Flags().BoolVar(&createOpts.RequireReset, "force-reset", false, "Same as --require-reset")
+	createCmd.Flags().StringVar(&wordpress.ResetRequiredKey, "reset-meta-key", wordpress.ResetRequiredKey, "WordPress: user meta key set to 1 by --require-reset, for the plugin that enforces it")
+	createCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, passwordStdinUsage)
 
-	infoCmd.AddCommand(dbCmd)
-	infoCmd.AddCommand(versionCmd)
+	renameCmd := &cobra.Command{
+		Use:   "rename [OLD] [NEW]",
+		Short: "Change a user's login name",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(args[1]) == "" {
+				return withCode(exitUsage, fmt.Errorf("the new name must not be empty"))
+			}
 
-	rootCmd.AddCommand(usersCmd)
-	rootCmd.AddCommand(infoCmd)
+			if err := renameUser(cmsType, args[0], args[1]); err != nil {
+				return fmt.Errorf("renaming %s user: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	setEmailCmd := &cobra.Command{
+		Use:   "set-email [USERNAME] [EMAIL]",
+		Short: "Change a user's e-mail address",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := database.ValidateEmail(args[1]); err != nil {
+				return withCode(exitUsage, err)
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
 
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+			if err := setUserField(cmd.Context(), cmsType, args[0], "email", args[1]); err != nil {
+				return fmt.Errorf("setting %s e-mail: %w", cmsType, err)
+			}
+			return nil
+		},
 	}
-}
 
-func detectCMS() string {
-	wpConfig := filepath.Join(cmsPath, "wp-config.php")
-	joomlaConfig := filepath.Join(cmsPath, "configuration.php")
+	setNameCmd := &cobra.Command{
+		Use:   "set-name [USERNAME] [NAME]",
+		Short: "Change a user's display name",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if strings.TrimSpace(args[1]) == "" {
+				return withCode(exitUsage, fmt.Errorf("the name must not be empty"))
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
 
-	if _, err := os.Stat(wpConfig); err == nil {
-		return "wordpress"
+			if err := setUserField(cmd.Context(), cmsType, args[0], "name", args[1]); err != nil {
+				return fmt.Errorf("setting %s name: %w", cmsType, err)
+			}
+			return nil
+		},
 	}
-	if _, err := os.Stat(joomlaConfig); err == nil {
-		return "joomla"
+
+	var touchLastVisit string
+	touchCmd := &cobra.Command{
+		Use:   "touch [USERNAME]",
+		Short: "Set a user's last visit time, e.g. to test dormant-account handling",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			if touchLastVisit == "" {
+				return withCode(exitUsage, fmt.Errorf("--last-visit is required"))
+			}
+			t := time.Now()
+			if touchLastVisit != "now" {
+				var err error
+				if t, err = time.Parse(time.RFC3339, touchLastVisit); err != nil {
+					return withCode(exitUsage, fmt.Errorf("--last-visit must be now or an RFC 3339 time such as 2024-01-31T12:00:00Z"))
+				}
+				if t.After(time.Now()) {
+					return withCode(exitUsage, fmt.Errorf("--last-visit %s is in the future", touchLastVisit))
+				}
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := touchUser(cmsType, args[0], t); err != nil {
+				return fmt.Errorf("touching %s user: %w", cmsType, err)
+			}
+			return nil
+		},
 	}
-	return ""
+	touchCmd.Flags().StringVar(&touchLastVisit, "last-visit", "", "New last visit time: now or an RFC 3339 time")
+
+	var staleDays int
+	var staleDryRun, staleAdmins bool
+	lockStaleCmd := &cobra.Command{
+		Use:   "lock-stale",
+		Short: "Block accounts without activity for --days (WordPress: remove their roles)",
+		Long: "Find active users whose last recorded login, or registration when there is none,\n" +
+			"is older than --days, and block them in one transaction: Joomla sets block, and\n" +
+			"WordPress, which has no block flag, removes all their roles. Administrators are\n" +
+			"skipped unless --include-admins is given. Every affected user is listed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if staleDays < 1 {
+				return withCode(exitUsage, fmt.Errorf("--days must be at least 1"))
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			cutoff := time.Now().AddDate(0, 0, -staleDays)
+			if err := lockStale(cmd.Context(), cmsType, cutoff, staleAdmins, staleDryRun); err != nil {
+				return fmt.Errorf("locking stale %s users: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	lockStaleCmd.Flags().IntVar(&staleDays, "days", 180, "Lock users without activity for this many days")
+	lockStaleCmd.Flags().BoolVar(&staleDryRun, "dry-run", false, "Show the users that would be locked without changing them")
+	lockStaleCmd.Flags().BoolVar(&staleAdmins, "include-admins", false, "Also lock stale administrators (the last one is kept unless --force)")
+
+	var mergeFrom, mergeInto string
+	var mergeDryRun bool
+	mergeCmd := &cobra.Command{
+		Use:   "merge",
+		Short: "Merge a duplicate account into another and delete it",
+		Long: "Move what belongs to --from over to --into, then delete --from, in one transaction.\n" +
+			"WordPress: the posts are reassigned and the user meta is moved, keeping the value of\n" +
+			"--into for keys both have. Joomla: --into joins the groups of --from and the\n" +
+			"articles are reassigned. --dry-run writes nothing and counts how many rows each\n" +
+			"step would touch.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if mergeFrom == "" || mergeInto == "" {
+				return withCode(exitUsage, fmt.Errorf("both --from and --into are required"))
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := mergeUsers(cmd.Context(), cmsType, mergeFrom, mergeInto, mergeDryRun); err != nil {
+				return fmt.Errorf("merging %s users: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	mergeCmd.Flags().StringVar(&mergeFrom, "from", "", "Username of the duplicate account, deleted after the merge")
+	mergeCmd.Flags().StringVar(&mergeInto, "into", "", "Username of the account that is kept")
+	mergeCmd.Flags().BoolVar(&mergeDryRun, "dry-run", false, "Show what would change without committing it")
+
+	var keepAdminTo string
+	var keepAdminDryRun bool
+	keepAdminCmd := &cobra.Command{
+		Use:   "keep-single-admin [USERNAME]",
+		Short: "Demote every administrator except one",
+		Long: "Give every administrator (WordPress) or Super User (Joomla) except USERNAME the\n" +
+			"role named by --to instead of their current roles, in one transaction. USERNAME\n" +
+			"must be an administrator, and --to must not be an administrative role. The\n" +
+			"demoted accounts are listed; --dry-run lists them without changing anything.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := keepSingleAdmin(cmd.Context(), cmsType, args[0], keepAdminTo, keepAdminDryRun); err != nil {
+				return fmt.Errorf("demoting %s administrators: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	keepAdminCmd.Flags().StringVar(&keepAdminTo, "to", "", "Role the other administrators get, a WordPress slug or Joomla group title (default editor, or Editor on Joomla)")
+	keepAdminCmd.Flags().BoolVar(&keepAdminDryRun, "dry-run", false, "Show who would be demoted without committing it")
+
+	var assignFile string
+	var assignDryRun bool
+	assignRolesCmd := &cobra.Command{
+		Use:   "assign-roles",
+		Short: "Set the roles of many users from a CSV file",
+		Long: "Read username,roles rows from --file and make the listed roles the user's only\n" +
+			"roles. Roles are WordPress slugs or Joomla group titles, separated by semicolons\n" +
+			"(or commas inside a quoted field). Every row is applied in its own transaction,\n" +
+			"so a failing row does not undo the others. Unknown users are skipped with a warning.",
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if assignFile == "" {
+				return withCode(exitUsage, fmt.Errorf("--file is required"))
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := assignRoles(cmsType, assignFile, assignDryRun); err != nil {
+				return fmt.Errorf("assigning %s roles: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	assignRolesCmd.Flags().StringVar(&assignFile, "file", "", "CSV file of username,roles rows")
+	assignRolesCmd.Flags().BoolVar(&assignDryRun, "dry-run", false, "Check every row and show the changes without writing them")
+	assignRolesCmd.Flags().BoolVar(&joomla.IgnoreUnknownRoles, "ignore-unknown-roles", false, "Joomla: skip role titles that match no group instead of failing the row")
+
+	findByEmailCmd := &cobra.Command{
+		Use:         "find-by-email [EMAIL]",
+		Short:       "Find the users registered with an e-mail address",
+		Annotations: readOnlyAnnotations,
+		Long: "Look up the accounts whose e-mail is exactly EMAIL in every install of the database,\n" +
+			"or only the one chosen with --prefix, and show their username, ID, roles and last\n" +
+			"login. Every match is listed when several accounts share the address.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := findByEmail(cmd.Context(), cmsType, strings.TrimSpace(args[0])); err != nil {
+				return fmt.Errorf("finding %s users by e-mail: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	usersCmd.AddCommand(listCmd)
+	usersCmd.AddCommand(findByEmailCmd)
+	usersCmd.AddCommand(exportCmd)
+	usersCmd.AddCommand(countCmd)
+	usersCmd.AddCommand(adminsCmd)
+	usersCmd.AddCommand(capabilitiesCmd)
+	usersCmd.AddCommand(verifyCmd)
+	usersCmd.AddCommand(userInfoCmd)
+	usersCmd.AddCommand(editCmd)
+	usersCmd.AddCommand(createCmd)
+	usersCmd.AddCommand(renameCmd)
+	usersCmd.AddCommand(setEmailCmd)
+	usersCmd.AddCommand(setNameCmd)
+	usersCmd.AddCommand(touchCmd)
+	usersCmd.AddCommand(resetLinkCmd)
+	usersCmd.AddCommand(rewriteEmailCmd)
+	usersCmd.AddCommand(duplicatesCmd)
+	usersCmd.AddCommand(logoutCmd)
+	usersCmd.AddCommand(assignRolesCmd)
+	usersCmd.AddCommand(lockStaleCmd)
+	usersCmd.AddCommand(mergeCmd)
+	usersCmd.AddCommand(keepAdminCmd)
+
+	infoCmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show CMS information",
+	}
+
+	var infoJSON bool
+	dbCmd := &cobra.Command{
+		Use:         "db",
+		Aliases:     []string{"general"},
+		Short:       "Show db information",
+		Annotations: readOnlyAnnotations,
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if infoJSON {
+				outputFormat = "json"
+			}
+			return nil
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			site, err := cms.Open(cmsType, cmsPath)
+			if err == nil {
+				if outputFormat != "text" {
+					err = showInfoReport(site)
+				} else {
+					err = site.ShowInfo()
+				}
+			}
+
+			if err != nil {
+				return fmt.Errorf("showing %s info: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	dbCmd.Flags().BoolVar(&infoJSON, "json", false, "Print the information as JSON (same as --output json)")
+
+	var versionAll bool
+	versionCmd := &cobra.Command{
+		Use:         "version",
+		Short:       "Show CMS version information",
+		Annotations: readOnlyAnnotations,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if versionAll {
+				if cmsType != "joomla" {
+					return unsupported("info version --all", cmsType)
+				}
+				return showVersionSources()
+			}
+
+			site, err := cms.Open(cmsType, cmsPath)
+			if err != nil {
+				return err
+			}
+			report := versionReport{CMS: cmsType}
+			if report.Version, err = site.Version(); err != nil {
+				return fmt.Errorf("showing %s version: %w", cmsType, err)
+			}
+			// only some CMSes publish a release date
+			r, hasRelease := site.(interface{ Release() (string, error) })
+			if hasRelease {
+				if report.Release, err = r.Release(); err != nil {
+					return fmt.Errorf("showing %s release: %w", cmsType, err)
+				}
+			}
+
+			if outputFormat != "text" {
+				if cmsType == "wordpress" {
+					current, expected, err := wordpressDBVersions()
+					if err != nil {
+						if err := database.Warnf("%v", err); err != nil {
+							return err
+						}
+					} else {
+						report.DBVersion, report.ExpectedDBVersion = &current, &expected
+					}
+				}
+				row := []string{report.CMS, report.Version, report.Release, "", ""}
+				if report.DBVersion != nil {
+					row[3], row[4] = strconv.Itoa(*report.DBVersion), strconv.Itoa(*report.ExpectedDBVersion)
+				}
+				return printData(report, []string{"cms", "version", "release", "dbVersion", "expectedDbVersion"}, [][]string{row})
+			}
+
+			fmt.Printf("%s Version: %s\n", cmsType, report.Version)
+			if hasRelease {
+				fmt.Printf("Release: %s\n", report.Release)
+			}
+			if cmsType == "wordpress" {
+				return showWordPressDBVersion()
+			}
+			return nil
+		},
+	}
+
+	sessionsCmd := &cobra.Command{
+		Use:         "sessions",
+		Short:       "Show active Joomla sessions",
+		Annotations: readOnlyAnnotations,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "joomla" {
+				return unsupported("sessions", cmsType)
+			}
+
+			if err := showJoomlaSessions(); err != nil {
+				return fmt.Errorf("showing %s sessions: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	dbSizeCmd := &cobra.Command{
+		Use:         "db-size",
+		Short:       "Show row counts and sizes of the install's tables",
+		Annotations: readOnlyAnnotations,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := showTableSizes(cmsType); err != nil {
+				return fmt.Errorf("showing %s table sizes: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	tablesCmd := &cobra.Command{
+		Use:         "tables",
+		Short:       "List every table grouped by the prefix it is attributed to",
+		Annotations: readOnlyAnnotations,
+		Long: "Show how prefix detection sees the database: each prefix found from the\n" +
+			"_users, _posts, _user_usergroup_map and _usergroups tables, which of those it has,\n" +
+			"whether it counts as an install, and the tables that match no prefix.",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := showTables(cmsType); err != nil {
+				return fmt.Errorf("classifying %s tables: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	var summaryDays int
+	usersSummaryCmd := &cobra.Command{
+		Use:         "users-summary",
+		Short:       "Show user totals: all, blocked, recently registered and per role",
+		Annotations: readOnlyAnnotations,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if summaryDays < 1 {
+				return withCode(exitUsage, fmt.Errorf("--days must be at least 1"))
+			}
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if err := showUserSummary(cmsType, summaryDays); err != nil {
+				return fmt.Errorf("summarizing %s users: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	usersSummaryCmd.Flags().IntVar(&summaryDays, "days", 30, "Count users registered within this many days as recent")
+
+	var integrityFix, integrityYes bool
+	integrityCmd := &cobra.Command{
+		Use:         "integrity",
+		Short:       "Report orphaned user rows and users without roles",
+		Annotations: readOnlyAnnotations,
+		Long: "Count user meta (WordPress) or group mapping (Joomla) rows that point at missing\n" +
+			"users or groups, and users without any role. --fix --yes deletes the orphaned rows\n" +
+			"in a single transaction.",
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if integrityFix && len(cmsPaths) > 1 {
+				return withCode(exitUsage, fmt.Errorf("--fix changes the database and takes a single --path"))
+			}
+			return nil
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+
+			if integrityFix && allPrefixes && tablePrefix == "" {
+				return withCode(exitUsage, fmt.Errorf("--fix cannot be combined with --all-prefixes; pick one install with --prefix"))
+			}
+
+			if err := checkIntegrity(cmsType, integrityFix, integrityYes || database.Force); err != nil {
+				return fmt.Errorf("checking %s integrity: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	integrityCmd.Flags().BoolVar(&integrityFix, "fix", false, "Delete the orphaned rows")
+	integrityCmd.Flags().BoolVar(&integrityYes, "yes", false, "Confirm --fix")
+
+	securityCmd := &cobra.Command{
+		Use:         "security",
+		Short:       "Audit configuration.php for a weak secret, debug mode and risky SEF settings",
+		Annotations: readOnlyAnnotations,
+		Long: "Check the Joomla configuration without connecting to the database: $secret must\n" +
+			"be set and at least 16 characters long, $debug must be off, and SEF URLs should\n" +
+			"be on, with a rewrite file present when $sef_rewrite is. The secret is never printed.",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "joomla" {
+				return unsupported("info security", cmsType)
+			}
+
+			if err := showSecurityAudit(); err != nil {
+				return fmt.Errorf("auditing %s configuration: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	wooCmd := &cobra.Command{
+		Use:         "woocommerce",
+		Short:       "Show WooCommerce customer, order and revenue totals",
+		Annotations: readOnlyAnnotations,
+		Long: "Detect WooCommerce from active_plugins and the <prefix>_wc_* tables and report\n" +
+			"the users with the customer role, the number of orders and the revenue of the\n" +
+			"completed, processing and on-hold ones. Orders are read from the HPOS wc_orders\n" +
+			"table when the store uses it, and from the shop_order posts otherwise.",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "wordpress" {
+				return unsupported("info woocommerce", cmsType)
+			}
+
+			if err := showWooCommerce(); err != nil {
+				return fmt.Errorf("showing WooCommerce stats: %w", err)
+			}
+			return nil
+		},
+	}
+
+	configDiffCmd := &cobra.Command{
+		Use:         "config-diff",
+		Short:       "Compare the database settings of two installs",
+		Annotations: map[string]string{multiPathAnnotation: "true"},
+		Long: "Read the database settings from the configuration files of the two installs\n" +
+			"given with --path, e.g. staging and production, and list every field with\n" +
+			"whether it is the same or different. Passwords are never printed, only\n" +
+			"compared. Nothing is connected to.",
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if len(cmsPaths) != 2 {
+				return withCode(exitUsage, fmt.Errorf("info config-diff needs exactly two --path values"))
+			}
+			return showConfigDiff(cmsPaths[0], cmsPaths[1])
+		},
+	}
+
+	aclCmd := &cobra.Command{
+		Use:         "acl",
+		Short:       "Show each Joomla usergroup's view levels and backend access",
+		Annotations: readOnlyAnnotations,
+		Long: "List the usergroups as a tree with the members of each, the view levels of\n" +
+			"<prefix>_viewlevels they hold directly or through a parent group, and the groups\n" +
+			"the root asset's rules in <prefix>_assets grant core.admin (full administration)\n" +
+			"or core.login.admin (backend login). A Denied setting on a group overrides what\n" +
+			"its parents are allowed, as in Joomla.",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "joomla" {
+				return unsupported("info acl", cmsType)
+			}
+
+			if err := showACL(); err != nil {
+				return fmt.Errorf("showing %s ACL: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+
+	infoCmd.AddCommand(dbCmd)
+	infoCmd.AddCommand(dbSizeCmd)
+	infoCmd.AddCommand(integrityCmd)
+	infoCmd.AddCommand(tablesCmd)
+	infoCmd.AddCommand(usersSummaryCmd)
+	versionCmd.Flags().BoolVar(&versionAll, "all", false, "Joomla: report every version file found and whether they disagree")
+	infoCmd.AddCommand(versionCmd)
+	infoCmd.AddCommand(sessionsCmd)
+	infoCmd.AddCommand(wooCmd)
+	infoCmd.AddCommand(securityCmd)
+	infoCmd.AddCommand(aclCmd)
+	infoCmd.AddCommand(configDiffCmd)
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check that the CMS, its configuration and database are reachable",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if !runCheck() {
+				return errors.New("pre-flight check failed")
+			}
+			return nil
+		},
+	}
+
+	detectCmd := &cobra.Command{
+		Use:         "detect [PATH...]",
+		Short:       "Report which CMS and version is installed in each directory",
+		Annotations: map[string]string{multiPathAnnotation: "true"},
+		Long: "Detect the CMS in every given directory, or in --path when none is given, and\n" +
+			"report its version. Directories without a CMS are listed with an empty cms.",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) > 0 && configFile != "" {
+				return withCode(exitUsage, fmt.Errorf("--config-file only applies to a single install, not to detect with paths"))
+			}
+			if len(args) == 0 && len(cmsPaths) > 1 {
+				args = cmsPaths
+			}
+			return detectPaths(args)
+		},
+	}
+
+	var migrateFrom, migrateTo string
+	var migrateYes bool
+	migratePrefixCmd := &cobra.Command{
+		Use:   "migrate-prefix",
+		Short: "Rename a WordPress table prefix, e.g. from wp_ to something less guessable",
+		Long: "Rename every table of the install from one prefix to another and rewrite the\n" +
+			"user meta keys and the user_roles option that embed the prefix. Every rename is\n" +
+			"printed; nothing is changed without --yes. Update $table_prefix in wp-config.php\n" +
+			"afterwards.",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			cmsType, err := requireCMS()
+			if err != nil {
+				return err
+			}
+			if cmsType != "wordpress" {
+				return unsupported("migrate-prefix", cmsType)
+			}
+			from, to := strings.TrimSuffix(migrateFrom, "_"), strings.TrimSuffix(migrateTo, "_")
+			if from == "" || to == "" {
+				return withCode(exitUsage, fmt.Errorf("both --from and --to are required"))
+			}
+			if !regexp.MustCompile(`^[A-Za-z0-9_]+$`).MatchString(to) {
+				return withCode(exitUsage, fmt.Errorf("--to may only contain letters, digits and underscores"))
+			}
+
+			if err := migratePrefix(from, to, migrateYes || database.Force); err != nil {
+				return fmt.Errorf("migrating %s prefix: %w", cmsType, err)
+			}
+			return nil
+		},
+	}
+	migratePrefixCmd.Flags().StringVar(&migrateFrom, "from", "", "Current table prefix, e.g. wp_")
+	migratePrefixCmd.Flags().StringVar(&migrateTo, "to", "", "New table prefix")
+	migratePrefixCmd.Flags().BoolVar(&migrateYes, "yes", false, "Really rename; without it the plan is only printed")
+
+	var versionJSON bool
+	toolVersionCmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show the version of cmsmgmt itself (use info version for the CMS)",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			info := toolVersion()
+			if versionJSON || outputFormat != "text" {
+				return printData(info, []string{"version", "goVersion", "commit", "buildDate", "modified"},
+					[][]string{{info.Version, info.GoVersion, info.Commit, info.BuildDate, strconv.FormatBool(info.Modified)}})
+			}
+
+			fmt.Printf("cmsmgmt %s\n", info.Version)
+			fmt.Printf("Go:         %s\n", info.GoVersion)
+			if info.Commit != "" {
+				dirty := ""
+				if info.Modified {
+					dirty = " (modified)"
+				}
+				fmt.Printf("Commit:     %s%s\n", info.Commit, dirty)
+			}
+			if info.BuildDate != "" {
+				fmt.Printf("Build date: %s\n", info.BuildDate)
+			}
+			return nil
+		},
+	}
+	toolVersionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print the version as JSON (same as --output json)")
+
+	rootCmd.AddCommand(usersCmd)
+	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(detectCmd)
+	rootCmd.AddCommand(migratePrefixCmd)
+	rootCmd.AddCommand(toolVersionCmd)
+	forEachPathCommands(rootCmd)
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	// cobra skips PersistentPostRunE when the command fails, so the output
+	// is closed here, where every path passes
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	if cerr := closeOutput(err != nil); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cancel()
+		os.Exit(reportError(cmd, err))
+	}
+}
+
+// dateLayouts are the formats accepted by parseDate, most specific first.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+	"02.01.2006",
+	"2006-01",
+}
+
+// parseDate parses a date given on the command line. Times without a zone
+// are taken as UTC, which is how the CMSes store registration dates.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date, use e.g. 2024-01-31 or 2024-01-31T12:00:00Z", s)
+}
+
+// expandHome replaces a leading ~/ in path with the user's home directory.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// listJoomla prints the Joomla users for the configured prefix, or for every
+// install in the database with --all-prefixes.
+func listJoomla(filter database.UserFilter) error {
+	db, cfg, configured, err := processJoomla()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	prefixes, err := joomlaReportPrefixes(db, cfg.Type, configured)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat != "text" || len(listFields) > 0 {
+		header, record := joomlaUserHeader, func(u joomla.UserDetail) (any, []string) { return u, joomlaUserRow(u) }
+		if filter.NoRoles {
+			header, record = joomlaBasicUserHeader, joomlaBasicUserRecord
+		}
+		return forEachPrefix(prefixes, func(prefix string) error {
+			w, err := userWriter(header)
+			if err != nil {
+				return err
+			}
+			err = joomla.ListUsersFunc(db, prefix, filter, func(u joomla.UserDetail) error {
+				u.Email = database.DisplayEmail(u.Email)
+				if anonymize {
+					anonymizeJoomlaUser(&u)
+				}
+				return w.Write(record(u))
+			})
+			if err != nil {
+				return fmt.Errorf("list users for prefix %s: %w", prefix, err)
+			}
+			return w.Close()
+		})
+	}
+
+	fmt.Printf("Joomla DB Name: %s\n", cfg.DBName)
+	fmt.Printf("Joomla DB User: %s\n", cfg.User)
+	fmt.Printf("Identified Joomla table prefixes: %v\n", prefixes)
+	return forEachPrefix(prefixes, func(prefix string) error {
+		fmt.Printf("\nUsers for prefix '%s':\n", prefix)
+		privileged, err := privilegedRoles("joomla", db, prefix)
+		if err != nil {
+			return err
+		}
+		err = joomla.ListUsersFunc(db, prefix, filter, func(u joomla.UserDetail) error {
+			u.Email = database.DisplayEmail(u.Email)
+			if filter.NoRoles {
+				fmt.Printf("ID:%d  Username:%s  Name:%s  Email:%s  Blocked:%t  SendEmail:%t\n",
+					u.ID, u.Username, u.Name, u.Email, u.Block, u.SendEmail)
+				return nil
+			}
+			u.Roles = highlightRoles(u.Roles, privileged)
+			fmt.Printf("ID:%d  Username:%s  Name:%s  Email:%s  Roles:%v  Blocked:%t  SendEmail:%t  SuperUser:%t\n",
+				u.ID, u.Username, u.Name, u.Email, u.Roles, u.Block, u.SendEmail, u.IsSuperUser)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list users for prefix %s: %w", prefix, err)
+		}
+		return nil
+	})
+}
+
+// listTYPO3 prints the TYPO3 backend users.
+func listTYPO3(filter database.UserFilter) error {
+	db, cfg, err := typo3.ProcessTYPO3(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if outputFormat != "text" {
+		w := newRecordWriter(typo3UserHeader)
+		err := typo3.ListUsersFunc(db, cfg.Type, filter, func(u typo3.UserDetail) error {
+			u.Email = database.DisplayEmail(u.Email)
+			if anonymize {
+				anonymizeTYPO3User(&u)
+			}
+			return w.Write(u, typo3UserRow(u))
+		})
+		if err != nil {
+			return fmt.Errorf("list backend users: %w", err)
+		}
+		return w.Close()
+	}
+
+	fmt.Printf("TYPO3 DB Name: %s\n", cfg.DBName)
+	fmt.Printf("TYPO3 DB User: %s\n", cfg.User)
+	fmt.Println("\nBackend users:")
+	err = typo3.ListUsersFunc(db, cfg.Type, filter, func(u typo3.UserDetail) error {
+		fmt.Printf("ID:%d  Username:%s  Name:%s  Email:%s  Admin:%t  Disabled:%t\n",
+			u.ID, u.Username, u.RealName, database.DisplayEmail(u.Email), u.Admin, u.Disabled)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list backend users: %w", err)
+	}
+	return nil
+}
+
+// listMediaWiki prints the MediaWiki users and their groups.
+func listMediaWiki() error {
+	db, cfg, prefix, err := mediawiki.ProcessMediaWiki(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if outputFormat != "text" {
+		w := newRecordWriter(mediawikiUserHeader)
+		err := mediawiki.ListUsersFunc(db, prefix, func(u mediawiki.UserDetail) error {
+			u.Email = database.DisplayEmail(u.Email)
+			if anonymize {
+				anonymizeMediaWikiUser(&u)
+			}
+			return w.Write(u, mediawikiUserRow(u))
+		})
+		if err != nil {
+			return fmt.Errorf("list users: %w", err)
+		}
+		return w.Close()
+	}
+
+	fmt.Printf("MediaWiki DB Name: %s\n", cfg.DBName)
+	fmt.Printf("MediaWiki DB User: %s\n", cfg.User)
+	fmt.Printf("\nUsers for prefix %q:\n", prefix)
+	err = mediawiki.ListUsersFunc(db, prefix, func(u mediawiki.UserDetail) error {
+		fmt.Printf("ID:%d  Username:%s  Name:%s  Email:%s  Groups:%v\n",
+			u.ID, u.Username, u.RealName, database.DisplayEmail(u.Email), u.Groups)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list users: %w", err)
+	}
+	return nil
+}
+
+// exportUsers writes every user of the install as JSON or CSV. The listing
+// helpers stream rows straight to the output for anything but text.
+func exportUsers(ctx context.Context, cmsType string) error {
+	var all database.UserFilter
+	switch cmsType {
+	case "wordpress":
+		return listWordPressData(ctx, all)
+	case "joomla":
+		return listJoomla(all)
+	case "typo3":
+		return listTYPO3(all)
+	case "mediawiki":
+		return listMediaWiki()
+	}
+	return nil
+}
+
+// countUsers prints the number of users, for one role or broken down per role.
+func countUsers(cmsType, role string, byRole bool) error {
+	var db *sql.DB
+	var prefixes []string
+	var err error
+	var count func(*sql.DB, string, string) (int, error)
+	var countByRole func(*sql.DB, string) ([]database.RoleCount, error)
+
+	switch cmsType {
+	case "wordpress":
+		var detected []string
+		db, _, detected, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = reportPrefixes(detected); err != nil {
+			return err
+		}
+		count, countByRole = wordpress.CountUsers, wordpress.CountByRole
+	case "joomla":
+		var cfg database.DBConfig
+		var configured string
+		db, cfg, configured, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = joomlaReportPrefixes(db, cfg.Type, configured); err != nil {
+			return err
+		}
+		count, countByRole = joomla.CountUsers, joomla.CountByRole
+	default:
+		return unsupported("users count", cmsType)
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		result := struct {
+			Prefix string               `json:"prefix"`
+			Role   string               `json:"role,omitempty"`
+			Users  int                  `json:"users"`
+			Roles  []database.RoleCount `json:"roles,omitempty"`
+		}{Prefix: prefix, Role: role}
+
+		if result.Users, err = count(db, prefix, role); err != nil {
+			return err
+		}
+		if byRole {
+			if result.Roles, err = countByRole(db, prefix); err != nil {
+				return err
+			}
+		}
+
+		if outputFormat != "text" {
+			rows := [][]string{{role, strconv.Itoa(result.Users)}}
+			if role == "" {
+				rows[0][0] = "all"
+			}
+			for _, c := range result.Roles {
+				rows = append(rows, []string{c.Role, strconv.Itoa(c.Users)})
+			}
+			return printData(result, []string{"role", "users"}, rows)
+		}
+
+		if role != "" {
+			fmt.Printf("Users with role %s: %d\n", role, result.Users)
+		} else {
+			fmt.Printf("Users: %d\n", result.Users)
+		}
+		for _, c := range result.Roles {
+			fmt.Printf("  %-20s %d\n", c.Role, c.Users)
+		}
+		return nil
+	})
+}
+
+// showTables prints the tables of the CMS database grouped by prefix.
+func showTables(cmsType string) error {
+	site, err := cms.Open(cmsType, cmsPath)
+	if err != nil {
+		return err
+	}
+	cfg, err := site.DBConfig()
+	if err != nil {
+		return err
+	}
+	db, err := database.Connect(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	classes, err := database.ClassifyTables(db, cfg.Type)
+	if err != nil {
+		return err
+	}
+	// prefixes in order, the unattributed tables last
+	list := make([]database.TableClass, 0, len(classes))
+	for _, c := range classes {
+		list = append(list, c)
+	}
+	slices.SortFunc(list, func(a, b database.TableClass) int {
+		if (a.Prefix == "") != (b.Prefix == "") {
+			if a.Prefix == "" {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	if outputFormat != "text" {
+		var rows [][]string
+		for _, c := range list {
+			for _, t := range c.Tables {
+				rows = append(rows, []string{c.Prefix, strconv.FormatBool(c.Detected), t})
+			}
+		}
+		return printData(list, []string{"prefix", "detected", "table"}, rows)
+	}
+
+	for i, c := range list {
+		if i > 0 {
+			fmt.Println()
+		}
+		if c.Prefix == "" {
+			fmt.Printf("Tables matching no prefix (%d):\n", len(c.Tables))
+		} else {
+			var markers []string
+			for _, m := range []struct {
+				name string
+				ok   bool
+			}{{"_users", c.Users}, {"_posts", c.Posts}, {"_user_usergroup_map", c.UserMap}, {"_usergroups", c.UserGroups}} {
+				if m.ok {
+					markers = append(markers, m.name)
+				}
+			}
+			verdict := "not detected"
+			if c.Detected {
+				verdict = "detected"
+			}
+			fmt.Printf("Prefix %s_ (%s; has %s), %d tables:\n", c.Prefix, verdict, strings.Join(markers, ", "), len(c.Tables))
+		}
+		for _, t := range c.Tables {
+			fmt.Printf("  %s\n", t)
+		}
+	}
+	return nil
+}
+
+// showInfoReport prints the information of info db as JSON or CSV, for the
+// CMSes that collect it in a database.InfoReport.
+func showInfoReport(site cms.CMS) error {
+	r, ok := site.(interface {
+		Info() (database.InfoReport, error)
+	})
+	if !ok {
+		return unsupported("JSON and CSV output of info db", site.Name())
+	}
+	info, err := r.Info()
+	if err != nil {
+		return err
+	}
+	return printData(info, []string{"cms", "dbType", "dbName", "dbUser", "dbHost", "dbPort", "server", "prefixes", "version"},
+		[][]string{{info.CMS, info.DBType, info.DBName, info.DBUser, info.DBHost, strconv.Itoa(info.DBPort),
+			info.Server, strings.Join(info.Prefixes, ","), info.Version}})
+}
+
+// showWordPressDBVersion prints the db_version of the database next to the
+// one the files expect, and why they may differ. A database that cannot be
+// read is only a warning, the file version has been printed already.
+func showWordPressDBVersion() error {
+	current, expected, err := wordpressDBVersions()
+	if err != nil {
+		return database.Warnf("%v", err)
+	}
+
+	fmt.Printf("DB schema version: %d (files expect %d)\n", current, expected)
+	switch {
+	case current < expected:
+		fmt.Println(database.Highlight("The database needs an upgrade: run wp-admin/upgrade.php or wp core update-db.", "33"))
+	case current > expected:
+		fmt.Println(database.Highlight("The database is newer than the files: an update is in progress or the files were rolled back.", "33"))
+	}
+	return nil
+}
+
+// wordpressDBVersions returns the schema version of the database and the one
+// the files expect.
+func wordpressDBVersions() (current, expected int, err error) {
+	expected, err = wordpress.ExpectedDBVersion(cmsPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	current, err = func() (int, error) {
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return 0, err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return 0, err
+		}
+		return wordpress.GetDBVersion(db, prefix)
+	}()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot read the database schema version: %v", err)
+	}
+	return current, expected, nil
+}
+
+// versionReport is the JSON and CSV form of info version. The schema
+// versions are only reported for WordPress.
+type versionReport struct {
+	CMS               string `json:"cms"`
+	Version           string `json:"version"`
+	Release           string `json:"release,omitempty"`
+	DBVersion         *int   `json:"dbVersion,omitempty"`
+	ExpectedDBVersion *int   `json:"expectedDbVersion,omitempty"`
+}
+
+// showVersionSources prints what each Joomla version file says and warns when
+// they disagree, as on a partially upgraded install.
+func showVersionSources() error {
+	sources := joomla.VersionSources(cmsPath)
+	if len(sources) == 0 {
+		return fmt.Errorf("no Joomla version file found under %s", cmsPath)
+	}
+	disagree := joomla.VersionsDisagree(sources)
+
+	if outputFormat != "text" {
+		var rows [][]string
+		for _, src := range sources {
+			rows = append(rows, []string{src.File, src.Version, src.Release, src.Error})
+		}
+		result := struct {
+			Sources  []joomla.VersionSource `json:"sources"`
+			Disagree bool                   `json:"disagree"`
+		}{sources, disagree}
+		if err := printData(result, []string{"file", "version", "release", "error"}, rows); err != nil {
+			return err
+		}
+	} else {
+		for _, src := range sources {
+			switch {
+			case src.Error != "":
+				fmt.Printf("%s: error: %s\n", src.File, src.Error)
+			case src.Release != "":
+				fmt.Printf("%s: %s (released %s)\n", src.File, src.Version, src.Release)
+			default:
+				fmt.Printf("%s: %s\n", src.File, src.Version)
+			}
+		}
+	}
+	if disagree {
+		return database.Warnf("the version files disagree; the install may be partially upgraded")
+	}
+	return nil
+}
+
+// detection is the result of detect for one directory.
+type detection struct {
+	Path    string `json:"path"`
+	CMS     string `json:"cms"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// showConfigDiff prints the field-by-field comparison of the database
+// settings of the installs at a and b, and warns when both name the same
+// database.
+func showConfigDiff(a, b string) error {
+	var configs []database.DBConfig
+	for _, path := range []string{a, b} {
+		cmsType, err := detectDefaultCMS(path)
+		if err != nil {
+			return err
+		}
+		if cmsType == "" {
+			return withCode(exitNoCMS, fmt.Errorf("unsupported or no CMS detected at %q", path))
+		}
+		site, err := cms.Open(cmsType, path)
+		if err != nil {
+			return err
+		}
+		cfg, err := site.DBConfig()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	diffs := database.DiffConfigs(configs[0], configs[1])
+	var rows [][]string
+	differ := 0
+	for _, d := range diffs {
+		status := "same"
+		if !d.Same {
+			status = "different"
+			differ++
+		}
+		rows = append(rows, []string{d.Field, d.A, d.B, status})
+	}
+	if outputFormat != "text" {
+		return printData(diffs, []string{"field", "a", "b", "status"}, rows)
+	}
+
+	if err := printTable([]string{"Field", a, b, "Status"}, rows); err != nil {
+		return err
+	}
+	fmt.Printf("%d of %d fields differ\n", differ, len(diffs))
+	// the same server and database name means both installs share the data
+	x, y := configs[0], configs[1]
+	if x.Type == y.Type && strings.EqualFold(x.Host, y.Host) && x.Port == y.Port && x.DBName == y.DBName {
+		return database.Warnf("both installs use database %s on %s", x.DBName, x.Host)
+	}
+	return nil
+}
+
+// detectPaths reports the CMS and version found in each path, or in --path
+// when paths is empty. A path that cannot be inspected is reported and the
+// scan goes on; the command fails at the end.
+func detectPaths(paths []string) error {
+	var results []detection
+	failed := 0
+	detect := func(path string, find func() (string, error)) {
+		d := detection{Path: path}
+		if _, err := os.Stat(path); err != nil {
+			d.Error = err.Error()
+		} else if d.CMS, err = find(); err != nil {
+			d.Error = err.Error()
+		} else if d.CMS != "" {
+			var site cms.CMS
+			if site, err = cms.Open(d.CMS, path); err == nil {
+				d.Version, err = site.Version()
+			}
+			if err != nil {
+				d.Error = err.Error()
+			}
+		}
+		if d.Error != "" {
+			failed++
+		}
+		results = append(results, d)
+	}
+	if len(paths) == 0 {
+		path := cmsPath
+		if path == "" {
+			path = "."
+		}
+		detect(path, detectCMS)
+	}
+	for _, p := range paths {
+		detect(p, func() (string, error) { return detectDefaultCMS(p) })
+	}
+
+	if outputFormat != "text" {
+		var rows [][]string
+		for _, d := range results {
+			rows = append(rows, []string{d.Path, d.CMS, d.Version, d.Error})
+		}
+		if err := printData(results, []string{"path", "cms", "version", "error"}, rows); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range results {
+			switch {
+			case d.Error != "":
+				fmt.Printf("%s: error: %s\n", d.Path, d.Error)
+			case d.CMS == "":
+				fmt.Printf("%s: no CMS found\n", d.Path)
+			default:
+				fmt.Printf("%s: %s %s\n", d.Path, d.CMS, d.Version)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d paths could not be inspected", failed, len(results))
+	}
+	return nil
+}
+
+// showUserSummary prints the user totals of the selected install, counting
+// registrations of the last days as recent.
+func showUserSummary(cmsType string, days int) error {
+	var db *sql.DB
+	var prefixes []string
+	var err error
+	var summarize func(*sql.DB, string, time.Time) (database.UserStats, error)
+
+	switch cmsType {
+	case "wordpress":
+		var detected []string
+		db, _, detected, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = reportPrefixes(detected); err != nil {
+			return err
+		}
+		summarize = wordpress.UserStats
+	case "joomla":
+		var cfg database.DBConfig
+		var configured string
+		db, cfg, configured, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = joomlaReportPrefixes(db, cfg.Type, configured); err != nil {
+			return err
+		}
+		summarize = joomla.UserStats
+	default:
+		return unsupported("info users-summary", cmsType)
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	return forEachPrefix(prefixes, func(prefix string) error {
+		stats, err := summarize(db, prefix, since)
+		if err != nil {
+			return err
+		}
+
+		if outputFormat != "text" {
+			result := struct {
+				Prefix string `json:"prefix"`
+				Days   int    `json:"days"`
+				database.UserStats
+			}{prefix, days, stats}
+			rows := [][]string{
+				{"total", "", strconv.Itoa(stats.Total)},
+				{"blocked", "", strconv.Itoa(stats.Blocked)},
+				{"recent", "", strconv.Itoa(stats.Recent)},
+			}
+			for _, c := range stats.Roles {
+				rows = append(rows, []string{"role", c.Role, strconv.Itoa(c.Users)})
+			}
+			return printData(result, []string{"metric", "role", "users"}, rows)
+		}
+
+		fmt.Printf("Users: %d\n", stats.Total)
+		fmt.Printf("Blocked: %d\n", stats.Blocked)
+		fmt.Printf("Registered in the last %d days: %d\n", days, stats.Recent)
+		fmt.Println("Per role:")
+		for _, c := range stats.Roles {
+			fmt.Printf("  %-20s %d\n", c.Role, c.Users)
+		}
+		return nil
+	})
+}
+
+// showSecurityAudit prints the findings of the Joomla configuration audit.
+func showSecurityAudit() error {
+	findings, err := joomla.SecurityAudit(cmsPath)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat != "text" {
+		var rows [][]string
+		for _, f := range findings {
+			rows = append(rows, []string{f.Check, strconv.FormatBool(f.OK), f.Detail})
+		}
+		return printData(findings, []string{"check", "ok", "detail"}, rows)
+	}
+
+	for _, f := range findings {
+		status := database.Highlight(" OK ", "32")
+		if !f.OK {
+			status = database.Highlight("WARN", "33")
+		}
+		fmt.Printf("%s %s: %s\n", status, f.Check, f.Detail)
+	}
+	return nil
+}
+
+// showACL prints the usergroups of the selected Joomla installs with their
+// view levels and backend permissions.
+func showACL() error {
+	db, cfg, configured, err := processJoomla()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	prefixes, err := joomlaReportPrefixes(db, cfg.Type, configured)
+	if err != nil {
+		return err
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		groups, err := joomla.ListGroupAccess(db, prefix)
+		if err != nil {
+			return err
+		}
+
+		if outputFormat != "text" {
+			var rows [][]string
+			for _, g := range groups {
+				rows = append(rows, []string{strconv.Itoa(g.ID), g.Title, strconv.Itoa(g.ParentID), strconv.Itoa(g.Users),
+					strings.Join(g.ViewLevels, ";"), strconv.FormatBool(g.Admin), strconv.FormatBool(g.AdminLogin)})
+			}
+			return printData(groups, []string{"id", "title", "parentId", "users", "viewLevels", "admin", "adminLogin"}, rows)
+		}
+
+		var rows [][]string
+		for _, g := range groups {
+			backend := "-"
+			switch {
+			case g.Admin:
+				backend = "core.admin"
+			case g.AdminLogin:
+				backend = "backend login"
+			}
+			rows = append(rows, []string{strings.Repeat("  ", g.Depth) + g.Title, strconv.Itoa(g.Users), backend,
+				strings.Join(g.ViewLevels, ", ")})
+		}
+		return printTable([]string{"Group", "Users", "Backend", "View levels"}, rows)
+	})
+}
+
+// showWooCommerce prints the WooCommerce totals of the selected installs.
+func showWooCommerce() error {
+	db, _, detected, err := wordpress.OpenWordPress(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	prefixes, err := reportPrefixes(detected)
+	if err != nil {
+		return err
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		stats, err := wordpress.WooCommerceStats(db, prefix)
+		if err != nil {
+			return err
+		}
+
+		if outputFormat != "text" {
+			result := struct {
+				Prefix string `json:"prefix"`
+				wordpress.WooStats
+			}{prefix, stats}
+			rows := [][]string{{prefix, strconv.FormatBool(stats.Active), strconv.FormatBool(stats.Tables),
+				strconv.FormatBool(stats.HPOS), strconv.Itoa(stats.Customers), strconv.Itoa(stats.Orders),
+				strconv.FormatFloat(stats.Revenue, 'f', 2, 64), stats.Currency}}
+			return printData(result, []string{"prefix", "active", "tables", "hpos", "customers", "orders", "revenue", "currency"}, rows)
+		}
+
+		if !stats.Detected() {
+			fmt.Println("WooCommerce is not installed on this site.")
+			return nil
+		}
+		if !stats.Active {
+			fmt.Println("WooCommerce tables found, but the plugin is not active.")
+		}
+		storage := "posts (legacy)"
+		if stats.HPOS {
+			storage = "wc_orders (HPOS)"
+		}
+		fmt.Printf("Order storage: %s\n", storage)
+		fmt.Printf("Customers: %d\n", stats.Customers)
+		fmt.Printf("Orders: %d\n", stats.Orders)
+		fmt.Printf("Revenue: %.2f %s\n", stats.Revenue, stats.Currency)
+		return nil
+	})
+}
+
+// listAdmins prints the privileged accounts of the selected install.
+func listAdmins(ctx context.Context, cmsType string) error {
+	var db *sql.DB
+	var prefixes []string
+	var err error
+	var list func(*sql.DB, string) ([]database.PrivilegedUser, error)
+
+	switch cmsType {
+	case "wordpress":
+		var detected []string
+		db, _, detected, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = reportPrefixes(detected); err != nil {
+			return err
+		}
+		list = func(db *sql.DB, prefix string) ([]database.PrivilegedUser, error) {
+			return wordpress.ListAdmins(ctx, db, prefix)
+		}
+	case "joomla":
+		var cfg database.DBConfig
+		var configured string
+		db, cfg, configured, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = joomlaReportPrefixes(db, cfg.Type, configured); err != nil {
+			return err
+		}
+		list = joomla.ListAdmins
+	default:
+		return unsupported("users admins", cmsType)
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		admins, err := list(db, prefix)
+		if err != nil {
+			return err
+		}
+		return printAdmins(admins)
+	})
+}
+
+// printAdmins prints the privileged accounts of one install.
+func printAdmins(admins []database.PrivilegedUser) error {
+	for i := range admins {
+		admins[i].Email = database.DisplayEmail(admins[i].Email)
+	}
+
+	if outputFormat != "text" {
+		return printData(admins, adminHeader, adminRows(admins))
+	}
+	if len(admins) == 0 {
+		fmt.Println("No administrators found.")
+		return nil
+	}
+	var rows [][]string
+	for _, a := range admins {
+		rows = append(rows, []string{strconv.FormatInt(a.ID, 10), a.Username, a.Email, a.Role, formatLastLogin(a.LastLogin, "never")})
+	}
+	return printTable([]string{"ID", "Username", "Email", "Role", "Last login"}, rows)
+}
+
+// findByEmail prints the users registered with email in every install of the
+// database, or in the one selected with --prefix.
+func findByEmail(ctx context.Context, cmsType, email string) error {
+	var db *sql.DB
+	var prefixes []string
+	var find func(prefix string) ([]database.UserMatch, error)
+
+	switch cmsType {
+	case "wordpress":
+		var err error
+		db, _, prefixes, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		find = func(prefix string) ([]database.UserMatch, error) {
+			return wordpress.GetUserByEmail(ctx, db, prefix, email)
+		}
+	case "joomla":
+		var cfg database.DBConfig
+		var configured string
+		var err error
+		db, cfg, configured, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = joomla.IdentifyPrefixes(db, cfg.Type); err != nil {
+			return err
+		}
+		if len(prefixes) == 0 {
+			prefixes = []string{configured}
+		}
+		find = func(prefix string) ([]database.UserMatch, error) {
+			return joomla.GetUserByEmail(db, prefix, email)
+		}
+	default:
+		return unsupported("users find-by-email", cmsType)
+	}
+	if tablePrefix != "" {
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		prefixes = []string{prefix}
+	}
+
+	var matches []database.UserMatch
+	for _, prefix := range prefixes {
+		found, err := find(prefix)
+		if err != nil {
+			return fmt.Errorf("prefix %s_: %w", prefix, err)
+		}
+		matches = append(matches, found...)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no user with e-mail %s: %w", email, database.ErrUserNotFound)
+	}
+
+	if outputFormat != "text" {
+		var rows [][]string
+		for i := range matches {
+			u := &matches[i]
+			u.Email = database.DisplayEmail(u.Email)
+			rows = append(rows, []string{u.Prefix, strconv.FormatInt(u.ID, 10), u.Username, u.Email,
+				strings.Join(u.Roles, ";"), formatLastLogin(u.LastLogin, "")})
+		}
+		return printData(matches, []string{"prefix", "id", "username", "email", "roles", "lastLogin"}, rows)
+	}
+	if len(matches) > 1 {
+		fmt.Printf("%d users share the e-mail %s\n", len(matches), database.DisplayEmail(email))
+	}
+	privileged := make(map[string]map[string]bool)
+	var rows [][]string
+	for _, u := range matches {
+		if _, ok := privileged[u.Prefix]; !ok {
+			p, err := privilegedRoles(cmsType, db, u.Prefix)
+			if err != nil {
+				return err
+			}
+			privileged[u.Prefix] = p
+		}
+		rows = append(rows, []string{u.Prefix + "_", strconv.FormatInt(u.ID, 10), u.Username,
+			strings.Join(highlightRoles(u.Roles, privileged[u.Prefix]), ", "), formatLastLogin(u.LastLogin, "never")})
+	}
+	return printTable([]string{"Prefix", "ID", "Username", "Roles", "Last login"}, rows)
+}
+
+// privilegedRoles returns the administrative roles of the install with the
+// given prefix, WordPress role slugs or Joomla group titles, for
+// highlightRoles. Without colors nothing is looked up.
+func privilegedRoles(cmsType string, db *sql.DB, prefix string) (map[string]bool, error) {
+	if !database.Color {
+		return nil, nil
+	}
+	var roles []string
+	var err error
+	switch cmsType {
+	case "wordpress":
+		roles, err = wordpress.PrivilegedRoles(db, prefix)
+	case "joomla":
+		roles, err = joomla.AdminGroups(db, prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		set[r] = true
+	}
+	return set, nil
+}
+
+// highlightRoles returns roles with the privileged ones highlighted.
+func highlightRoles(roles []string, privileged map[string]bool) []string {
+	out := make([]string, len(roles))
+	for i, r := range roles {
+		out[i] = r
+		if privileged[r] {
+			out[i] = database.HighlightPrivileged(r)
+		}
+	}
+	return out
+}
+
+// createUser prompts for a password and adds the user to the selected install.
+// opts.Activate only applies to Joomla.
+func createUser(cmsType, login, email, display string, roles []string, opts joomla.CreateOptions) error {
+	readPassword := func() (string, error) {
+		password, err := readSecret(fmt.Sprintf("Password for %s: ", login))
+		if err != nil {
+			return "", err
+		}
+		if password == "" {
+			return "", withCode(exitUsage, fmt.Errorf("the password must not be empty"))
+		}
+		return password, nil
+	}
+
+	switch cmsType {
+	case "wordpress":
+		if opts.Activate {
+			return withCode(exitUsage, fmt.Errorf("--activate only applies to Joomla sites"))
+		}
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if len(roles) == 0 {
+			roles = []string{"subscriber"}
+		}
+
+		id, err := wordpress.CreateUser(db, prefix, login, email, display, password, roles, opts.RequireReset)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created user %s with ID %s\n", login, database.InsertID(int64(id)))
+		if opts.RequireReset {
+			fmt.Printf("Set %s; the user must choose a new password at the next login if a plugin honours it\n", wordpress.ResetRequiredKey)
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+
+		u, err := joomla.CreateUser(db, prefix, cmsPath, login, email, display, password, roles, opts)
+		if err != nil {
+			return err
+		}
+		if outputFormat != "text" {
+			return printData(u, []string{"id", "block", "activation", "groups"},
+				[][]string{{strconv.Itoa(u.ID), strconv.FormatBool(u.Block), u.Activation, strings.Join(u.Groups, ",")}})
+		}
+		fmt.Printf("Created user %s with ID %s in %s\n", login, database.InsertID(int64(u.ID)), strings.Join(u.Groups, ", "))
+		if u.Block {
+			fmt.Printf("The site requires activation: the account is blocked until activated with token %s\n", u.Activation)
+		}
+		if opts.RequireReset {
+			fmt.Println("The user must choose a new password at the first login")
+		}
+	default:
+		return unsupported("users create", cmsType)
+	}
+	return nil
+}
+
+// renameUser changes the login of oldName to newName in the selected install.
+func renameUser(cmsType, oldName, newName string) error {
+	var n int64
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		if n, err = wordpress.RenameUser(db, prefix, oldName, newName); err != nil {
+			return err
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if n, err = joomla.RenameUser(db, prefix, oldName, newName); err != nil {
+			return err
+		}
+	default:
+		return unsupported("users rename", cmsType)
+	}
+
+	fmt.Printf("Renamed %s to %s, %d rows updated\n", oldName, newName, n)
+	fmt.Fprintln(os.Stderr, "Note: content refers to users by ID and keeps its author, but anything that stores the old name itself (plugin settings, hardcoded references) is not updated.")
+	return nil
+}
+
+// setUserField changes the e-mail or the display name of username, the
+// fields UpdateUser writes, leaving the other one as it is.
+func setUserField(ctx context.Context, cmsType, username, field, value string) error {
+	var n int64
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		user, err := wordpress.GetUserByUsername(ctx, db, prefix, username)
+		if err != nil {
+			return err
+		}
+		if field == "email" {
+			user["Email"] = value
+		} else {
+			user["Name"] = value
+		}
+		if n, err = wordpress.UpdateUser(db, prefix, user); err != nil {
+			return err
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		user, err := joomla.GetUserByUsername(db, prefix, username)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %s", database.ErrUserNotFound, username)
+		}
+		if err != nil {
+			return err
+		}
+		if field == "email" {
+			user.Email = value
+		} else {
+			user.Name = value
+		}
+		if n, err = joomla.UpdateUser(db, prefix, user); err != nil {
+			return err
+		}
+	default:
+		return unsupported("users set-"+field, cmsType)
+	}
+
+	fmt.Printf("Set the %s of %s to %s, %d rows updated\n", field, username, value, n)
+	return nil
+}
+
+// touchUser sets the last visit of username to t and prints the change.
+func touchUser(cmsType, username string, t time.Time) error {
+	var previous *time.Time
+	var field string
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		field = wordpress.LastLoginKey + " meta"
+		if previous, err = wordpress.TouchLastLogin(db, prefix, username, t); err != nil {
+			return err
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		field = "lastvisitDate"
+		if previous, err = joomla.TouchLastVisit(db, prefix, username, t); err != nil {
+			return err
+		}
+	default:
+		return unsupported("users touch", cmsType)
+	}
+
+	fmt.Printf("%s %s: %s -> %s\n", username, field, formatLastLogin(previous, "never"), t.UTC().Truncate(time.Second).Format(time.RFC3339))
+	return nil
+}
+
+// showCapabilities prints the decoded roles and capabilities of a WordPress user.
+func showCapabilities(cmsType, username string) error {
+	if cmsType != "wordpress" {
+		return unsupported("users capabilities", cmsType)
+	}
+	db, _, detected, err := wordpress.OpenWordPress(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	prefixes, err := reportPrefixes(detected)
+	if err != nil {
+		return err
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		caps, err := wordpress.GetCapabilities(db, prefix, username)
+		if err != nil {
+			return err
+		}
+
+		if outputFormat != "text" {
+			rows := make([][]string, 0, len(caps.Capabilities))
+			for _, c := range caps.Capabilities {
+				rows = append(rows, []string{c.Kind, c.Name, strconv.FormatBool(c.Granted), c.Source})
+			}
+			return printData(caps, []string{"kind", "name", "granted", "source"}, rows)
+		}
+
+		level := "not set"
+		if caps.UserLevel != nil {
+			level = strconv.Itoa(*caps.UserLevel)
+		}
+		fmt.Printf("User: %s\nUser level: %s\n", caps.Username, level)
+		if len(caps.Capabilities) == 0 {
+			fmt.Println("No roles or capabilities.")
+			return nil
+		}
+		var rows [][]string
+		for _, c := range caps.Capabilities {
+			rows = append(rows, []string{c.Kind, c.Name, strconv.FormatBool(c.Granted), c.Source})
+		}
+		return printTable([]string{"Kind", "Name", "Granted", "Source"}, rows)
+	})
+}
+
+// errPasswordMismatch is returned by verifyPassword so a mismatch exits non-zero.
+var errPasswordMismatch = errors.New("password does not match")
+
+// verifyPassword prompts for a password and checks it against username's hash.
+func verifyPassword(cmsType, username string) error {
+	var db *sql.DB
+	var prefix string
+	var err error
+	var verify func(*sql.DB, string, string, string) (bool, error)
+
+	switch cmsType {
+	case "wordpress":
+		var prefixes []string
+		db, _, prefixes, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefix, err = pickPrefix(prefixes); err != nil {
+			return err
+		}
+		verify = wordpress.VerifyPassword
+	case "joomla":
+		db, _, prefix, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		verify = joomla.VerifyPassword
+	default:
+		return unsupported("users verify", cmsType)
+	}
+
+	candidate, err := readSecret(fmt.Sprintf("Password for %s: ", username))
+	if err != nil {
+		return err
+	}
+	ok, err := verify(db, prefix, username, candidate)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat != "text" {
+		result := struct {
+			Username string `json:"username"`
+			Match    bool   `json:"match"`
+		}{username, ok}
+		if err := printData(result, []string{"username", "match"}, [][]string{{username, strconv.FormatBool(ok)}}); err != nil {
+			return err
+		}
+	} else if ok {
+		fmt.Println("Password matches")
+	}
+	if !ok {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+// requirePipedStdin refuses --password-stdin when stdin is a terminal, so a
+// script that forgot to pipe the password fails instead of waiting at a prompt.
+func requirePipedStdin() error {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return withCode(exitUsage, fmt.Errorf("--password-stdin needs the password piped to stdin, which is a terminal"))
+	}
+	return nil
+}
+
+// readSecret reads one line from stdin, without echo when stdin is a terminal.
+// The prompt goes to stderr so it never mixes with the output. This is how
+// every command takes passwords; there is deliberately no --password flag,
+// which would leak them into the shell history and ps.
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		buf, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		return string(buf), nil
+	}
+
+	// byte by byte, so the lines after it stay in stdin for later prompts
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// migratePrefix prints every rename needed to move the WordPress install from
+// one table prefix to another and performs them when yes is set.
+func migratePrefix(from, to string, yes bool) error {
+	db, cfg, _, err := wordpress.OpenWordPress(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	runState.prefix = from
+
+	plan, err := wordpress.PlanPrefixMigration(db, cfg.Type, from, to)
+	if err != nil {
+		return err
+	}
+	for _, r := range plan {
+		fmt.Printf("%-8s %s -> %s\n", r.Kind, r.Old, r.New)
+	}
+	if !yes {
+		return withCode(exitUsage, fmt.Errorf("%d renames planned, nothing changed; re-run with --yes to apply", len(plan)))
+	}
+	if err := database.Writable(); err != nil {
+		return err
+	}
+	if cfg.Type != "postgres" {
+		fmt.Fprintln(os.Stderr, "Warning: MySQL cannot roll back table renames; they are done in one atomic statement and reverted if the key rewrite fails.")
+	}
+
+	if err := wordpress.MigratePrefix(db, cfg.Type, from, to, plan); err != nil {
+		return err
+	}
+	fmt.Printf("Renamed %d items. Set $table_prefix = '%s_'; in wp-config.php now.\n", len(plan), to)
+	return nil
+}
+
+// showTableSizes prints the size of every table of the install, largest first.
+func showTableSizes(cmsType string) error {
+	var db *sql.DB
+	var prefixes []string
+	var sep string // between a WordPress or Joomla prefix and the table name
+	var err error
+
+	switch cmsType {
+	case "wordpress":
+		var detected []string
+		db, _, detected, err = wordpress.OpenWordPress(cmsPath)
+		if err == nil {
+			defer db.Close()
+			prefixes, err = reportPrefixes(detected)
+			sep = "_"
+		}
+	case "joomla":
+		var cfg database.DBConfig
+		var configured string
+		db, cfg, configured, err = processJoomla()
+		if err == nil {
+			defer db.Close()
+			prefixes, err = joomlaReportPrefixes(db, cfg.Type, configured)
+			sep = "_"
+		}
+	case "typo3":
+		// TYPO3 tables are not prefixed
+		db, _, err = typo3.ProcessTYPO3(cmsPath)
+		if err == nil {
+			defer db.Close()
+			prefixes = []string{""}
+		}
+	case "mediawiki":
+		var prefix string
+		db, _, prefix, err = mediawiki.ProcessMediaWiki(cmsPath)
+		if err == nil {
+			defer db.Close()
+			prefixes = []string{prefix}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		stats, err := database.TableStats(db, prefix+sep)
+		if err != nil {
+			return err
+		}
+		total := database.TableStat{Name: "total"}
+		for _, t := range stats {
+			total.Rows += t.Rows
+			total.DataBytes += t.DataBytes
+			total.IndexBytes += t.IndexBytes
+			total.TotalBytes += t.TotalBytes
+		}
+
+		if outputFormat != "text" {
+			if stats == nil {
+				stats = []database.TableStat{}
+			}
+			result := struct {
+				Tables []database.TableStat `json:"tables"`
+				Total  database.TableStat   `json:"total"`
+			}{stats, total}
+			rows := make([][]string, 0, len(stats)+1)
+			for _, t := range append(stats, total) {
+				rows = append(rows, []string{t.Name, strconv.FormatInt(t.Rows, 10),
+					strconv.FormatInt(t.DataBytes, 10), strconv.FormatInt(t.IndexBytes, 10), strconv.FormatInt(t.TotalBytes, 10)})
+			}
+			return printData(result, []string{"name", "rows", "dataBytes", "indexBytes", "totalBytes"}, rows)
+		}
+
+		var rows [][]string
+		for _, t := range append(stats, total) {
+			rows = append(rows, []string{t.Name, strconv.FormatInt(t.Rows, 10),
+				formatBytes(t.DataBytes), formatBytes(t.IndexBytes), formatBytes(t.TotalBytes)})
+		}
+		return printTable([]string{"Table", "Rows (est.)", "Data", "Index", "Total"}, rows)
+	})
+}
+
+// checkIntegrity prints the orphan counts of the selected install and, with
+// fix and yes, deletes the orphaned rows.
+func checkIntegrity(cmsType string, fix, yes bool) error {
+	var db *sql.DB
+	var prefixes []string
+	var err error
+	var find func(*sql.DB, string) ([]database.IntegrityIssue, error)
+	var repair func(*sql.DB, string) (int64, error)
+
+	switch cmsType {
+	case "wordpress":
+		var detected []string
+		db, _, detected, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = reportPrefixes(detected); err != nil {
+			return err
+		}
+		find, repair = wordpress.FindOrphans, wordpress.FixOrphans
+	case "joomla":
+		var cfg database.DBConfig
+		var configured string
+		db, cfg, configured, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if prefixes, err = joomlaReportPrefixes(db, cfg.Type, configured); err != nil {
+			return err
+		}
+		find, repair = joomla.FindOrphans, joomla.FixOrphans
+	default:
+		return unsupported("info integrity", cmsType)
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		issues, err := find(db, prefix)
+		if err != nil {
+			return err
+		}
+		fixable := 0
+		for _, i := range issues {
+			if i.Fixable {
+				fixable += i.Rows
+			}
+		}
+
+		if outputFormat != "text" {
+			rows := make([][]string, 0, len(issues))
+			for _, i := range issues {
+				rows = append(rows, []string{i.Table, i.Issue, strconv.Itoa(i.Rows), strconv.FormatBool(i.Fixable)})
+			}
+			if err := printData(issues, []string{"table", "issue", "rows", "fixable"}, rows); err != nil {
+				return err
+			}
+		} else {
+			rows := make([][]string, 0, len(issues))
+			for _, i := range issues {
+				rows = append(rows, []string{i.Table, i.Issue, strconv.Itoa(i.Rows)})
+			}
+			if err := printTable([]string{"Table", "Issue", "Rows"}, rows); err != nil {
+				return err
+			}
+		}
+
+		if !fix {
+			return nil
+		}
+		if !yes {
+			return withCode(exitUsage, fmt.Errorf("--fix deletes %d rows; pass --yes to confirm", fixable))
+		}
+		if fixable == 0 {
+			fmt.Fprintln(os.Stderr, "Nothing to fix.")
+			return nil
+		}
+		n, err := repair(db, prefix)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted %d orphaned rows.\n", n)
+		return nil
+	})
+}
+
+// formatBytes renders n as a short human-readable size, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// showDuplicates prints the groups of accounts sharing the same value in field.
+func showDuplicates(cmsType, field string) error {
+	type account struct{ id, username, name, email string }
+	type group struct {
+		value    string
+		accounts []account
+	}
+	var groups []group
+	var raw any
+
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+
+		wpGroups, err := wordpress.FindDuplicates(db, prefix, field)
+		if err != nil {
+			return err
+		}
+		for i := range wpGroups {
+			g := &wpGroups[i]
+			if field == "email" {
+				g.Value = database.DisplayEmail(g.Value)
+			}
+			for j := range g.Users {
+				g.Users[j].Email = database.DisplayEmail(g.Users[j].Email)
+			}
+		}
+		raw = wpGroups
+		for _, g := range wpGroups {
+			grp := group{value: g.Value}
+			for _, u := range g.Users {
+				grp.accounts = append(grp.accounts, account{strconv.FormatInt(u.ID, 10), u.Username, u.Name, u.Email})
+			}
+			groups = append(groups, grp)
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		jGroups, err := joomla.FindDuplicates(db, prefix, field)
+		if err != nil {
+			return err
+		}
+		for i := range jGroups {
+			g := &jGroups[i]
+			if field == "email" {
+				g.Value = database.DisplayEmail(g.Value)
+			}
+			for j := range g.Users {
+				g.Users[j].Email = database.DisplayEmail(g.Users[j].Email)
+			}
+		}
+		raw = jGroups
+		for _, g := range jGroups {
+			grp := group{value: g.Value}
+			for _, u := range g.Users {
+				grp.accounts = append(grp.accounts, account{strconv.Itoa(u.ID), u.Username, u.Name, u.Email})
+			}
+			groups = append(groups, grp)
+		}
+	default:
+		return fmt.Errorf("duplicate detection is not supported for %s", cmsType)
+	}
+
+	if outputFormat != "text" {
+		var rows [][]string
+		for _, g := range groups {
+			for _, a := range g.accounts {
+				rows = append(rows, []string{g.value, a.id, a.username, a.name, a.email})
+			}
+		}
+		return printData(raw, []string{field, "id", "username", "name", "email"}, rows)
+	}
+
+	if len(groups) == 0 {
+		fmt.Printf("No duplicate %ss found\n", field)
+		return nil
+	}
+	for _, g := range groups {
+		fmt.Printf("%s %q (%d accounts):\n", field, g.value, len(g.accounts))
+		for _, a := range g.accounts {
+			fmt.Printf("  ID:%s  Username:%s  Name:%s  Email:%s\n", a.id, a.username, a.name, a.email)
+		}
+	}
+	return nil
+}
+
+// showJoomlaSessions prints the sessions stored in the Joomla database.
+func showJoomlaSessions() error {
+	db, cfg, configured, err := processJoomla()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	prefixes, err := joomlaReportPrefixes(db, cfg.Type, configured)
+	if err != nil {
+		return err
+	}
+
+	return forEachPrefix(prefixes, func(prefix string) error {
+		sessions, err := joomla.ListSessions(db, prefix)
+		if errors.Is(err, joomla.ErrNoSessionTable) {
+			fmt.Println("Sessions are not database-backed on this site (no session table).")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if outputFormat != "text" {
+			if sessions == nil {
+				sessions = []joomla.Session{}
+			}
+			return printData(sessions, sessionHeader, sessionRows(sessions))
+		}
+
+		clients := map[int]string{0: "site", 1: "admin"}
+		for _, s := range sessions {
+			who := s.Username
+			if s.Guest {
+				who = "(guest)"
+			}
+			fmt.Printf("User:%s  ID:%d  Client:%s  Time:%s\n", who, s.UserID, clients[s.ClientID], s.Time.Format(time.DateTime))
+		}
+		fmt.Printf("%d sessions\n", len(sessions))
+		return nil
+	})
+}
+
+// rewriteEmails runs the e-mail rewrite for the detected CMS and prints every change.
+func rewriteEmails(cmsType, from, to, mapFile string, dryRun bool) error {
+	var mapping map[string]string
+	if mapFile != "" {
+		var err error
+		if mapping, err = readEmailMap(mapFile); err != nil {
+			return err
+		}
+	}
+
+	type change struct{ id, username, old, new string }
+	var changes []change
+
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+
+		var wpChanges []wordpress.EmailChange
+		if mapping != nil {
+			wpChanges, err = wordpress.RewriteEmails(db, prefix, mapping, dryRun)
+		} else {
+			wpChanges, err = wordpress.RewriteEmailDomain(db, prefix, from, to, dryRun)
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range wpChanges {
+			changes = append(changes, change{c.ID, c.Username, c.Old, c.New})
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var jChanges []joomla.EmailChange
+		if mapping != nil {
+			jChanges, err = joomla.RewriteEmails(db, prefix, mapping, dryRun)
+		} else {
+			jChanges, err = joomla.RewriteEmailDomain(db, prefix, from, to, dryRun)
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range jChanges {
+			changes = append(changes, change{strconv.Itoa(c.ID), c.Username, c.Old, c.New})
+		}
+	default:
+		return fmt.Errorf("rewriting e-mails is not supported for %s", cmsType)
+	}
+
+	for _, c := range changes {
+		fmt.Printf("ID:%s  Username:%s  %s -> %s\n", c.id, c.username, c.old, c.new)
+	}
+	if dryRun {
+		fmt.Printf("%d users would be updated (dry run)\n", len(changes))
+	} else {
+		fmt.Printf("%d users updated\n", len(changes))
+	}
+	return nil
+}
+
+// readEmailMap reads oldemail,newemail rows from a CSV file. A header row is skipped.
+func readEmailMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	mapping := make(map[string]string, len(records))
+	for i, rec := range records {
+		if i == 0 && !strings.Contains(rec[0], "@") {
+			continue // header
+		}
+		mapping[strings.TrimSpace(rec[0])] = strings.TrimSpace(rec[1])
+	}
+	return mapping, nil
+}
+
+// lockStale locks the users without activity since cutoff and lists them.
+func lockStale(ctx context.Context, cmsType string, cutoff time.Time, includeAdmins, dryRun bool) error {
+	var stale []database.StaleUser
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		if stale, err = wordpress.LockStale(ctx, db, prefix, cutoff, includeAdmins, dryRun); err != nil {
+			return err
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if stale, err = joomla.LockStale(db, prefix, cutoff, includeAdmins, dryRun); err != nil {
+			return err
+		}
+	default:
+		return unsupported("users lock-stale", cmsType)
+	}
+
+	if outputFormat != "text" {
+		if stale == nil {
+			stale = []database.StaleUser{}
+		}
+		var rows [][]string
+		for i := range stale {
+			u := &stale[i]
+			u.Email = database.DisplayEmail(u.Email)
+			rows = append(rows, []string{strconv.FormatInt(u.ID, 10), u.Username, u.Email,
+				u.LastActive.Format(time.RFC3339), strconv.FormatBool(u.Admin)})
+		}
+		return printData(stale, []string{"id", "username", "email", "lastActive", "admin"}, rows)
+	}
+
+	for _, u := range stale {
+		admin := ""
+		if u.Admin {
+			admin = "  (admin)"
+		}
+		fmt.Printf("ID:%d  Username:%s  Email:%s  LastActive:%s%s\n",
+			u.ID, u.Username, database.DisplayEmail(u.Email), u.LastActive.Format(time.DateOnly), admin)
+	}
+	if dryRun {
+		fmt.Printf("%d users would be locked (dry run)\n", len(stale))
+	} else {
+		fmt.Printf("%d users locked\n", len(stale))
+	}
+	return nil
+}
+
+// mergeUsers merges the account from into the account into on the selected
+// install and prints what each step changed.
+func mergeUsers(ctx context.Context, cmsType, from, into string, dryRun bool) error {
+	var steps []database.MergeStep
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		if steps, err = wordpress.MergeUsers(ctx, db, prefix, from, into, dryRun); err != nil {
+			return err
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if steps, err = joomla.MergeUsers(db, prefix, from, into, dryRun); err != nil {
+			return err
+		}
+	default:
+		return unsupported("users merge", cmsType)
+	}
+
+	var rows [][]string
+	for _, s := range steps {
+		rows = append(rows, []string{s.Table, s.Action, strconv.FormatInt(s.Rows, 10)})
+	}
+	if outputFormat != "text" {
+		return printData(steps, []string{"table", "action", "rows"}, rows)
+	}
+
+	if err := printTable([]string{"Table", "Action", "Rows"}, rows); err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("%s would be merged into %s (dry run, nothing was changed)\n", from, into)
+	} else {
+		fmt.Printf("%s merged into %s\n", from, into)
+	}
+	return nil
+}
+
+// keepSingleAdmin demotes every administrator except keep to the role to and
+// lists the demoted accounts.
+func keepSingleAdmin(ctx context.Context, cmsType, keep, to string, dryRun bool) error {
+	var demoted []database.PrivilegedUser
+	switch cmsType {
+	case "wordpress":
+		db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			return err
+		}
+		if to == "" {
+			to = "editor"
+		}
+		if demoted, err = wordpress.KeepSingleAdmin(ctx, db, prefix, keep, to, dryRun); err != nil {
+			return err
+		}
+	case "joomla":
+		db, _, prefix, err := processJoomla()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if to == "" {
+			to = "Editor"
+		}
+		if demoted, err = joomla.KeepSingleAdmin(db, prefix, keep, to, dryRun); err != nil {
+			return err
+		}
+	default:
+		return unsupported("users keep-single-admin", cmsType)
+	}
+
+	var rows [][]string
+	for i, u := range demoted {
+		demoted[i].Email = database.DisplayEmail(u.Email)
+		rows = append(rows, []string{strconv.FormatInt(u.ID, 10), u.Username, demoted[i].Email, u.Role})
+	}
+	if outputFormat != "text" {
+		return printData(demoted, []string{"id", "username", "email", "role"}, rows)
+	}
+
+	if len(demoted) > 0 {
+		if err := printTable([]string{"ID", "Username", "Email", "Former role"}, rows); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		fmt.Printf("%d accounts would be demoted to %s, %s stays the only administrator (dry run, nothing was changed)\n", len(demoted), to, keep)
+	} else {
+		fmt.Printf("Demoted %d accounts to %s, %s is the only administrator\n", len(demoted), to, keep)
+	}
+	return nil
+}
+
+// roleRow is one username,roles row of an assign-roles file.
+type roleRow struct {
+	line     int
+	username string
+	roles    []string
+}
+
+// readRoleMap reads username,roles rows from a CSV file. The roles may be one
+// field separated by semicolons or commas, or several fields. A header row
+// starting with "username" is skipped.
+func readRoleMap(path string) ([]roleRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	var rows []roleRow
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		username := strings.TrimSpace(rec[0])
+		if len(rows) == 0 && strings.EqualFold(username, "username") {
+			continue // header
+		}
+		if username == "" && len(rec) == 1 {
+			continue // blank line
+		}
+		row := roleRow{line: line, username: username}
+		for _, field := range rec[1:] {
+			for _, role := range strings.FieldsFunc(field, func(r rune) bool { return r == ';' || r == ',' }) {
+				if role = strings.TrimSpace(role); role != "" {
+					row.roles = append(row.roles, role)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// roleAssignment is the outcome of one row of assign-roles.
+type roleAssignment struct {
+	Line     int      `json:"line"`
+	ID       int64    `json:"id,omitempty"`
+	Username string   `json:"username"`
+	OldRoles []string `json:"oldRoles"`
+	NewRoles []string `json:"newRoles"`
+	Status   string   `json:"status"`
+	// Retried is set when the row ran again after a lost connection; if the
+	// first commit went through unacknowledged, OldRoles are the new roles.
+	Retried bool   `json:"retried,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// assignRoles sets the roles of every user listed in path, each row in its own
+// transaction, and reports every row. Unknown users are skipped with a
+// warning; any other failure is reported and the command fails at the end.
+// When the connection cannot be restored the rows so far are still reported,
+// and the rest are left for a rerun.
+func assignRoles(cmsType, path string, dryRun bool) error {
+	rows, err := readRoleMap(path)
+	if err != nil {
+		return err
+	}
+
+	var db *sql.DB
+	var cfg database.DBConfig
+	var setRoles func(db *sql.DB, username string, roles []string) (int64, []string, error)
+	switch cmsType {
+	case "wordpress":
+		var prefixes []string
+		db, cfg, prefixes, err = wordpress.OpenWordPress(cmsPath)
+		if err != nil {
+			return err
+		}
+		prefix, err := pickPrefix(prefixes)
+		if err != nil {
+			db.Close()
+			return err
+		}
+		setRoles = func(db *sql.DB, username string, roles []string) (int64, []string, error) {
+			return wordpress.SetRoles(db, prefix, username, roles, dryRun)
+		}
+	case "joomla":
+		var prefix string
+		db, cfg, prefix, err = processJoomla()
+		if err != nil {
+			return err
+		}
+		setRoles = func(db *sql.DB, username string, roles []string) (int64, []string, error) {
+			return joomla.SetRoles(db, prefix, username, roles, dryRun)
+		}
+	default:
+		return unsupported("users assign-roles", cmsType)
+	}
+	// db is replaced when the connection is lost
+	defer func() { db.Close() }()
+
+	updated := "updated"
+	if dryRun {
+		updated = "would update"
+	}
+	var results []roleAssignment
+	failed := 0
+	var lastDone string // the last user that was changed, to resume after
+	var lost error      // set when the connection could not be restored
+	for _, row := range rows {
+		a := roleAssignment{Line: row.line, Username: row.username, NewRoles: row.roles}
+		var err error
+		if len(row.roles) == 0 {
+			err = fmt.Errorf("no roles given, refusing to remove all roles")
+		} else {
+			// Every row is its own transaction, so after a dropped connection
+			// the rows before are done and this one is simply run again. If
+			// the commit went through unacknowledged, setting the same roles
+			// twice changes nothing, but the roles read back are the new ones,
+			// so such rows are flagged as retried.
+			for attempt := 1; ; attempt++ {
+				a.ID, a.OldRoles, err = setRoles(db, row.username, row.roles)
+				a.Retried = attempt > 1
+				if !database.ConnectionLost(err) {
+					break
+				}
+				if attempt == 3 {
+					lost = err
+					break
+				}
+				if database.Verbose {
+					fmt.Fprintf(os.Stderr, "line %d: %v\n", row.line, err)
+				}
+				fresh, rerr := database.Reconnect(db, cfg)
+				if rerr != nil {
+					err, lost = rerr, rerr
+					break
+				}
+				db = fresh
+			}
+		}
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			a.Status = "skipped"
+			a.Error = err.Error()
+			if werr := database.Warnf("line %d: %v, skipped", row.line, err); werr != nil {
+				a.Status = "failed"
+				failed++
+			}
+		case err != nil:
+			a.Status = "failed"
+			a.Error = err.Error()
+			failed++
+		default:
+			a.Status = updated
+			lastDone = fmt.Sprintf("user ID %d (%s, line %d)", a.ID, row.username, row.line)
+		}
+		results = append(results, a)
+		if lost != nil {
+			// the rows after this one would only fail the same way
+			break
+		}
+	}
+
+	if outputFormat != "text" {
+		if results == nil {
+			results = []roleAssignment{}
+		}
+		var out [][]string
+		for _, a := range results {
+			id := ""
+			if a.ID != 0 {
+				id = strconv.FormatInt(a.ID, 10)
+			}
+			out = append(out, []string{strconv.Itoa(a.Line), id, a.Username, strings.Join(a.OldRoles, ";"),
+				strings.Join(a.NewRoles, ";"), a.Status, strconv.FormatBool(a.Retried), a.Error})
+		}
+		if err := printData(results, []string{"line", "id", "username", "oldRoles", "newRoles", "status", "retried", "error"}, out); err != nil {
+			return err
+		}
+	} else {
+		for _, a := range results {
+			switch a.Status {
+			case "skipped":
+				// already warned about on stderr
+			case "failed":
+				fmt.Printf("line %d: %s: failed: %s\n", a.Line, a.Username, a.Error)
+			default:
+				status := a.Status
+				if a.Retried {
+					status += ", retried: the old roles may already be the new ones"
+				}
+				fmt.Printf("line %d: %s (ID %d): %s -> %s (%s)\n", a.Line, a.Username, a.ID,
+					strings.Join(a.OldRoles, ","), strings.Join(a.NewRoles, ","), status)
+			}
+		}
+	}
+
+	if lost != nil {
+		if lastDone == "" {
+			lastDone = "none"
+		}
+		return fmt.Errorf("connection lost at line %d, last user changed: %s: %w",
+			results[len(results)-1].Line, lastDone, lost)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", failed, len(rows))
+	}
+	return nil
+}
+
+// runCheck runs the pre-flight steps in order, printing a pass/fail line for
+// each, and reports whether all of them succeeded. Steps after a failure are skipped.
+func runCheck() bool {
+	ok := true
+	step := func(name string, fn func() (string, error)) {
+		if !ok {
+			fmt.Printf("%s %s\n", database.Highlight("SKIP", "33"), name)
+			return
+		}
+		detail, err := fn()
+		if err != nil {
+			ok = false
+			fmt.Printf("%s %s: %v\n", database.Highlight("FAIL", "31"), name, err)
+			return
+		}
+		fmt.Printf("%s %s: %s\n", database.Highlight(" OK ", "32"), name, detail)
+	}
+
+	var cmsType string
+	var cfg database.DBConfig
+	var db *sql.DB
+
+	step("detect CMS", func() (string, error) {
+		var err error
+		if cmsType, err = detectCMS(); err != nil {
+			return "", err
+		}
+		if cmsType == "" {
+			return "", fmt.Errorf("no supported CMS configuration found")
+		}
+		return cmsType, nil
+	})
+	step("read configuration", func() (string, error) {
+		site, err := cms.Open(cmsType, cmsPath)
+		if err != nil {
+			return "", err
+		}
+		if cfg, err = site.DBConfig(); err != nil {
+			return "", err
+		}
+		// report what Connect will use, after the command line overrides
+		shown := cfg
+		database.Override(&shown)
+		return fmt.Sprintf("%s database %s on %s:%d", shown.Type, shown.DBName, shown.Host, shown.Port), nil
+	})
+	step("connect to database", func() (string, error) {
+		var err error
+		db, err = database.Connect(cfg)
+		if err != nil {
+			return "", err
+		}
+		return "ping succeeded", nil
+	})
+	if db != nil {
+		defer db.Close()
+	}
+	step("identify table prefixes", func() (string, error) {
+		var prefixes []string
+		var err error
+		switch cmsType {
+		case "wordpress":
+			prefixes, err = wordpress.IdentifyPrefixes(db, cfg.Type)
+		case "joomla":
+			prefixes, err = joomla.IdentifyPrefixes(db, cfg.Type)
+		case "typo3":
+			// TYPO3 tables are not prefixed, just make sure they exist
+			if _, err = typo3.ListUsers(db, cfg.Type, database.UserFilter{}); err == nil {
+				return "be_users found", nil
+			}
+		case "mediawiki":
+			var prefix string
+			if _, prefix, err = mediawiki.ExtractDBConfig(mediawiki.ConfigPath(cmsPath)); err != nil {
+				return "", err
+			}
+			if _, err = mediawiki.ListUsers(db, prefix); err == nil {
+				return fmt.Sprintf("%suser found", prefix), nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(prefixes) == 0 {
+			return "", fmt.Errorf("no %s tables found", cmsType)
+		}
+		return fmt.Sprintf("%v", prefixes), nil
+	})
+
+	return ok
+}
+
+// versionInfo describes the cmsmgmt binary.
+type versionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	Modified  bool   `json:"modified"`
+}
+
+// toolVersion collects the version of the binary, preferring values set with
+// -ldflags over the VCS information Go embeds at build time.
+func toolVersion() versionInfo {
+	info := versionInfo{Version: appVersion, GoVersion: runtime.Version(), Commit: gitCommit, BuildDate: buildDate}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, kv := range bi.Settings {
+			switch kv.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = kv.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = kv.Value
+				}
+			case "vcs.modified":
+				info.Modified = kv.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// interruptGrace is how long a cancelled command gets to stop on its own.
+const interruptGrace = 2 * time.Second
+
+// interruptContext returns a context that is cancelled on the first SIGINT so
+// running queries can abort cleanly. A command blocked elsewhere, e.g. on a
+// confirmation prompt, would keep its transaction open, so after a short
+// grace period, or at once on a second SIGINT, every open transaction is
+// rolled back, the connections are closed and the process exits.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			fmt.Fprintln(os.Stderr, "Interrupted, cancelling (press Ctrl-C again to force quit)")
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sigs)
+			return
+		}
+		select {
+		case <-sigs:
+		case <-time.After(interruptGrace):
+		}
+		if n := database.Abort(); n > 0 {
+			fmt.Fprintf(os.Stderr, "Rolled back %d open transaction(s)\n", n)
+		}
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, cancel
+}
+
+// detectCMS returns the registered name of the CMS at --path, or "" if none
+// is found. With --config-file the type is taken from that file's contents.
+func detectCMS() (string, error) {
+	if configFile != "" {
+		cmsType := cmsFromConfig(configFile)
+		if cmsType == "" {
+			// unrecognised contents, let the files under --path decide the type
+			var err error
+			if cmsType, err = detectDefaultCMS(cmsPath); err != nil {
+				return "", err
+			}
+		}
+		switch cmsType {
+		case "wordpress":
+			wordpress.ConfigFile = configFile
+		case "joomla":
+			joomla.ConfigFile = configFile
+		case "typo3":
+			typo3.ConfigFile = configFile
+		case "mediawiki":
+			mediawiki.ConfigFile = configFile
+		}
+		return cmsType, nil
+	}
+	return detectDefaultCMS(cmsPath)
+}
+
+// detectDefaultCMS asks the registered adapters which CMS lives under path.
+// When several match, the first in cms.Precedence wins after a warning.
+func detectDefaultCMS(path string) (string, error) {
+	found := cms.Detect(path)
+	if len(found) == 0 {
+		return "", nil
+	}
+	if len(found) > 1 {
+		err := database.Warnf("several CMS installations found in %q (%s), using %s; pick one with --config-file",
+			cmsPath, strings.Join(found, ", "), found[0])
+		if err != nil {
+			return "", withCode(exitNoCMS, err)
+		}
+	}
+	return found[0], nil
+}
+
+// configSignatures identify a CMS from the contents of a renamed configuration file.
+var configSignatures = []struct {
+	cms string
+	re  *regexp.Regexp
+}{
+	{"wordpress", regexp.MustCompile(`define\(\s*['"]DB_NAME['"]`)},
+	{"joomla", regexp.MustCompile(`class\s+JConfig\b`)},
+	{"mediawiki", regexp.MustCompile(`\$wgDBname\s*=`)},
+	{"typo3", regexp.MustCompile(`'Connections'\s*=>`)},
+}
+
+// cmsFromConfig returns the CMS whose configuration is stored in path, or "".
+func cmsFromConfig(path string) string {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, sig := range configSignatures {
+		if sig.re.Match(buf) {
+			return sig.cms
+		}
+	}
+	return ""
+}
+
+// listWordPressData prints the users of every detected WordPress prefix as one JSON array or CSV table.
+func listWordPressData(ctx context.Context, filter database.UserFilter) error {
+	db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	header, record := wordpressUserHeader, func(u wordpress.UserDetail) (any, []string) { return u, wordpressUserRow(u) }
+	if filter.NoRoles {
+		header, record = wordpressBasicUserHeader, wordpressBasicUserRecord
+	}
+	w, err := userWriter(header)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		err := wordpress.ListUsersFunc(ctx, db, prefix, filter, func(u wordpress.UserDetail) error {
+			u.Prefix = prefix
+			u.Email = database.DisplayEmail(u.Email)
+			if anonymize {
+				anonymizeWordPressUser(&u)
+			}
+			if includeMeta {
+				// one user's meta at a time, so memory use stays flat
+				meta, err := wordpress.UserMeta(ctx, db, prefix, u.ID)
+				if err != nil {
+					return err
+				}
+				u.Meta = meta
+			}
+			return w.Write(record(u))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list WordPress users for prefix %s: %w", prefix, err)
+		}
+	}
+	return w.Close()
+}
+
+// pickPrefix selects the table prefix to operate on from the detected ones,
+// honoring --prefix when several installs share the database.
+func pickPrefix(prefixes []string) (string, error) {
+	if tablePrefix != "" {
+		want := strings.TrimSuffix(tablePrefix, "_")
+		// detected prefixes keep the server's casing, which may differ
+		for _, p := range prefixes {
+			if strings.EqualFold(p, want) {
+				runState.prefix = p
+				return p, nil
+			}
+		}
+		return "", fmt.Errorf("prefix %q not found, detected prefixes: %v", want, prefixes)
+	}
+	switch len(prefixes) {
+	case 0:
+		return "", fmt.Errorf("no table prefixes detected")
+	case 1:
+		runState.prefix = prefixes[0]
+		return prefixes[0], nil
+	default:
+		return "", fmt.Errorf("several table prefixes detected %v, choose one with --prefix", prefixes)
+	}
+}
+
+// reportPrefixes returns every detected prefix for --all-prefixes, and
+// otherwise the single one pickPrefix selects.
+func reportPrefixes(detected []string) ([]string, error) {
+	if allPrefixes && tablePrefix == "" {
+		if len(detected) == 0 {
+			return nil, fmt.Errorf("no table prefixes detected")
+		}
+		return detected, nil
+	}
+	prefix, err := pickPrefix(detected)
+	if err != nil {
+		return nil, err
+	}
+	return []string{prefix}, nil
+}
+
+// joomlaReportPrefixes is reportPrefixes for Joomla, where the configured
+// prefix is used unless --all-prefixes asks for every install in the database.
+func joomlaReportPrefixes(db *sql.DB, dbType, configured string) ([]string, error) {
+	if !allPrefixes || tablePrefix != "" {
+		return []string{configured}, nil
+	}
+	prefixes, err := joomla.IdentifyPrefixes(db, dbType)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		return []string{configured}, nil
+	}
+	return prefixes, nil
+}
+
+// forEachPathCommands makes the read-only commands under cmd run once per
+// --path when several are given. JSON output is joined into one array with
+// an element per path; CSV, which has no room for the path, is refused.
+// detect handles the paths itself.
+func forEachPathCommands(cmd *cobra.Command) {
+	for _, c := range cmd.Commands() {
+		forEachPathCommands(c)
+	}
+	if cmd.RunE == nil || cmd.Annotations[allPrefixesAnnotation] == "" {
+		return
+	}
+	run := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if len(cmsPaths) < 2 {
+			return run(cmd, args)
+		}
+		if outputFormat == "csv" {
+			return withCode(exitUsage, fmt.Errorf("CSV output covers a single site, use --output json for several --path values"))
+		}
+		var sections *sectionWriter
+		if outputFormat == "json" {
+			sections = startSections("path")
+		}
+		failed := 0
+		for i, p := range cmsPaths {
+			cmsPath = p
+			runState.cms, runState.prefix = "", ""
+			if sections != nil {
+				sections.begin(p)
+			} else {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("=== %s ===\n", p)
+			}
+			err := run(cmd, args)
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			}
+			if sections != nil {
+				if err := sections.end(err); err != nil {
+					return err
+				}
+			}
+		}
+		if sections != nil {
+			if err := sections.finish(); err != nil {
+				return err
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d paths failed", failed, len(cmsPaths))
+		}
+		return nil
+	}
+}
+
+// forEachPrefix runs fn for each prefix. With several prefixes, text output
+// gets a heading per install, JSON output is joined into one array with an
+// element per prefix, CSV is refused, and errors name the prefix they came
+// from.
+func forEachPrefix(prefixes []string, fn func(prefix string) error) error {
+	if len(prefixes) == 1 {
+		runState.prefix = prefixes[0]
+		return fn(prefixes[0])
+	}
+	if outputFormat == "csv" {
+		return withCode(exitUsage, fmt.Errorf("CSV output covers a single install, use --output json or --prefix with --all-prefixes"))
+	}
+	var sections *sectionWriter
+	if outputFormat == "json" {
+		sections = startSections("prefix")
+	}
+	for i, prefix := range prefixes {
+		runState.prefix = prefix
+		if sections != nil {
+			sections.begin(prefix)
+		} else {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("=== Prefix %s_ ===\n", prefix)
+		}
+		err := fn(prefix)
+		if sections != nil {
+			if serr := sections.end(err); serr != nil && err == nil {
+				err = serr
+			}
+			if err != nil {
+				sections.finish()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("prefix %s_: %w", prefix, err)
+		}
+	}
+	if sections != nil {
+		return sections.finish()
+	}
+	return nil
+}
+
+// wordpressResetLink writes a reset key for the user and returns the reset URL.
+func wordpressResetLink(username string) (string, error) {
+	db, _, prefixes, err := wordpress.OpenWordPress(cmsPath)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	prefix, err := pickPrefix(prefixes)
+	if err != nil {
+		return "", err
+	}
+	return wordpress.GenerateResetKey(db, prefix, username)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cmsmgmt/database"
+)
+
+func TestForEachPrefixJSON(t *testing.T) {
+	buf := capture(t)
+	saved := outputFormat
+	outputFormat = "json"
+	defer func() { outputFormat = saved }()
+
+	err := forEachPrefix([]string{"wp", "blog"}, func(prefix string) error {
+		return printJSON(map[string]string{"site": prefix})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not one JSON document: %v\n%s", err, buf)
+	}
+	want := []map[string]any{
+		{"prefix": "wp", "data": map[string]any{"site": "wp"}},
+		{"prefix": "blog", "data": map[string]any{"site": "blog"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// a failing install still leaves a complete document
+	buf.Reset()
+	err = forEachPrefix([]string{"wp", "blog"}, func(prefix string) error {
+		if prefix == "blog" {
+			return errors.New("table missing")
+		}
+		return printJSON(map[string]string{"site": prefix})
+	})
+	if err == nil {
+		t.Fatal("error of the second prefix was lost")
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil || len(got) != 2 || got[1]["error"] != "table missing" {
+		t.Errorf("got %v (%v), want the failed prefix recorded\n%s", got, err, buf)
+	}
+}
+
+func TestForEachPrefixCSV(t *testing.T) {
+	capture(t)
+	saved := outputFormat
+	outputFormat = "csv"
+	defer func() { outputFormat = saved }()
+
+	called := false
+	err := forEachPrefix([]string{"wp", "blog"}, func(string) error { called = true; return nil })
+	if err == nil || called {
+		t.Errorf("err = %v, called = %v, want CSV refused before any output", err, called)
+	}
+	if err := forEachPrefix([]string{"wp"}, func(string) error { called = true; return nil }); err != nil || !called {
+		t.Errorf("err = %v, called = %v, want a single install listed", err, called)
+	}
+}
+
+func TestNoTablesExitCode(t *testing.T) {
+	err := fmt.Errorf("processing wordpress: %w",
+		&database.NoTablesError{CMS: "WordPress", DBName: "blog", Config: "/var/www/wp-config.php"})
+	if code := exitCode(err); code != exitNoTables {
+		t.Errorf("exit code %d, want %d", code, exitNoTables)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "database blog") || !strings.Contains(msg, "no WordPress tables") {
+		t.Errorf("message %q does not name the database and the CMS", msg)
+	}
+}
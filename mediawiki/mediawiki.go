@@ -0,0 +1,208 @@
+// Package mediawiki provides functions to interact with MediaWiki installations.
+package mediawiki
+
+import (
+	"cmsmgmt/database"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile, when set, is read instead of LocalSettings.php in the CMS root.
+var ConfigFile string
+
+// ConfigPath returns the LocalSettings.php to read for the install at cmsPath.
+func ConfigPath(cmsPath string) string {
+	if ConfigFile != "" {
+		return ConfigFile
+	}
+	return filepath.Join(cmsPath, "LocalSettings.php")
+}
+
+// UserDetail represents a MediaWiki user.
+type UserDetail struct {
+	ID       int      `json:"id"`
+	Username string   `json:"username"`
+	RealName string   `json:"realName"`
+	Email    string   `json:"email"`
+	Groups   []string `json:"groups"`
+}
+
+// ExtractDBConfig extracts the database configuration from LocalSettings.php.
+// It also returns the configured table prefix ($wgDBprefix), which may be empty.
+func ExtractDBConfig(filePath string) (database.DBConfig, string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return database.DBConfig{}, "", err
+	}
+
+	cfg := database.DBConfig{
+		Type:      "mysql", // default to MySQL
+		Port:      3306,    // default MySQL port
+		Charset:   database.DefaultCharset,
+		ParseTime: true,
+	}
+	var dbPrefix string
+
+	get := func(name string) (string, bool) {
+		re := regexp.MustCompile(`\$` + name + `\s*=\s*["']([^"']*)["']\s*;`)
+		if m := re.FindStringSubmatch(string(content)); len(m) > 1 {
+			return m[1], true
+		}
+		return "", false
+	}
+
+	if t, ok := get("wgDBtype"); ok {
+		switch strings.ToLower(t) {
+		case "postgres":
+			cfg.Type = "postgres"
+			cfg.Port = 5432
+		case "mysql":
+		default:
+			return cfg, "", fmt.Errorf("unsupported MediaWiki database type: %s", t)
+		}
+	}
+	if v, ok := get("wgDBname"); ok {
+		cfg.DBName = v
+	}
+	if v, ok := get("wgDBuser"); ok {
+		cfg.User = v
+	}
+	if v, ok := get("wgDBpassword"); ok {
+		cfg.Password = v
+	}
+	if v, ok := get("wgDBserver"); ok {
+		if h, p, err := net.SplitHostPort(v); err == nil {
+			cfg.Host = h
+			if pn, err := strconv.Atoi(p); err == nil {
+				cfg.Port = pn
+			}
+		} else {
+			cfg.Host = v
+		}
+	}
+	if v, ok := get("wgDBport"); ok {
+		if pn, err := strconv.Atoi(v); err == nil {
+			cfg.Port = pn
+		}
+	}
+	if v, ok := get("wgDBprefix"); ok {
+		dbPrefix = v
+	}
+
+	return cfg, dbPrefix, nil
+}
+
+// ListUsers retrieves all users together with their group memberships.
+// Unlike WordPress and Joomla, the MediaWiki prefix includes any separator.
+func ListUsers(db *sql.DB, prefix string) ([]UserDetail, error) {
+	var users []UserDetail
+	err := ListUsersFunc(db, prefix, func(u UserDetail) error {
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListUsersFunc is like ListUsers but calls fn for each user as the rows are
+// read, stopping at the first error fn returns.
+func ListUsersFunc(db *sql.DB, prefix string, fn func(UserDetail) error) error {
+	// "user" is a reserved word, so the table names are quoted
+	q := fmt.Sprintf("SELECT u.user_id, u.user_name, u.user_real_name, u.user_email, "+
+		"GROUP_CONCAT(g.ug_group SEPARATOR ',') AS user_groups "+
+		"FROM `%[1]suser` u "+
+		"LEFT JOIN `%[1]suser_groups` g ON u.user_id = g.ug_user "+
+		"GROUP BY u.user_id ORDER BY u.user_id", prefix)
+	rows, err := db.Query(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u UserDetail
+		var groups sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.RealName, &u.Email, &groups); err != nil {
+			return err
+		}
+		if groups.Valid {
+			u.Groups = strings.Split(groups.String, ",")
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetVersion returns the MediaWiki version, e.g. "1.41.0".
+func GetVersion(cmsPath string) (string, error) {
+	// MW_VERSION exists since 1.35, older releases only set $wgVersion
+	sources := []struct {
+		file string
+		re   *regexp.Regexp
+	}{
+		{filepath.Join(cmsPath, "includes", "Defines.php"), regexp.MustCompile(`define\(\s*'MW_VERSION'\s*,\s*'([^']+)'\s*\)`)},
+		{filepath.Join(cmsPath, "includes", "DefaultSettings.php"), regexp.MustCompile(`\$wgVersion\s*=\s*'([^']+)';`)},
+	}
+
+	for _, src := range sources {
+		buf, err := os.ReadFile(src.file)
+		if err != nil {
+			continue
+		}
+		if m := src.re.FindStringSubmatch(string(buf)); len(m) == 2 {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("could not find MediaWiki version under %s", cmsPath)
+}
+
+// ---------------- public entry points ----------------
+
+// ProcessMediaWiki reads LocalSettings.php and connects to the wiki database.
+// The caller must close the returned database.
+func ProcessMediaWiki(cmsPath string) (*sql.DB, database.DBConfig, string, error) {
+	configPath := ConfigPath(cmsPath)
+	cfg, prefix, err := ExtractDBConfig(configPath)
+	if err != nil {
+		return nil, cfg, "", fmt.Errorf("failed to extract MediaWiki DB config: %w", err)
+	}
+
+	db, err := database.Connect(cfg)
+	if err != nil {
+		return nil, cfg, "", fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if !database.HasTable(db, "`"+prefix+"user`") {
+		db.Close()
+		return nil, cfg, "", &database.NoTablesError{CMS: "MediaWiki", DBName: cfg.DBName, Config: configPath}
+	}
+	return db, cfg, prefix, nil
+}
+
+// ShowInfo displays general information about the MediaWiki installation.
+func ShowInfo(cmsPath string) error {
+	db, cfg, prefix, err := ProcessMediaWiki(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Println("MediaWiki Information:")
+	fmt.Printf("DB Type  : %s\n", cfg.Type)
+	fmt.Printf("DB Name  : %s\n", cfg.DBName)
+	fmt.Printf("DB User  : %s\n", cfg.User)
+	fmt.Printf("DB Host  : %s\n", cfg.Host)
+	fmt.Printf("DB Port  : %d\n", cfg.Port)
+	fmt.Printf("Server   : %s\n", database.DescribeServer(db))
+	fmt.Printf("Prefix   : %q\n", prefix)
+	return nil
+}
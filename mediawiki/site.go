@@ -0,0 +1,32 @@
+package mediawiki
+
+import (
+	"cmsmgmt/cms"
+	"cmsmgmt/database"
+)
+
+func init() {
+	cms.Register("mediawiki",
+		func(path string) cms.Evidence { return cms.Exists(path, cms.ConfigFound, "LocalSettings.php") },
+		func(path string) (cms.CMS, error) { return Site{Path: path}, nil })
+}
+
+// Site is a MediaWiki installation registered with the cms package.
+type Site struct {
+	Path string
+}
+
+// Name implements cms.CMS.
+func (Site) Name() string { return "mediawiki" }
+
+// DBConfig implements cms.CMS.
+func (s Site) DBConfig() (database.DBConfig, error) {
+	cfg, _, err := ExtractDBConfig(ConfigPath(s.Path))
+	return cfg, err
+}
+
+// Version implements cms.CMS.
+func (s Site) Version() (string, error) { return GetVersion(s.Path) }
+
+// ShowInfo implements cms.CMS.
+func (s Site) ShowInfo() error { return ShowInfo(s.Path) }
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cmsmgmt/database"
+	"cmsmgmt/joomla"
+	"cmsmgmt/mediawiki"
+	"cmsmgmt/typo3"
+	"cmsmgmt/wordpress"
+)
+
+// out receives the data payload of json/csv output. It is stdout unless --file
+// is given, so status messages on stdout/stderr never mix with the data.
+var out io.Writer = os.Stdout
+
+// openOutput redirects the payload to path, creating parent directories as needed.
+func openOutput(path string) (io.Closer, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("cannot create directory for output file %s: %w", path, err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write output file %s: %w", path, err)
+	}
+	out = f
+	return f, nil
+}
+
+// compressOutput gzips everything written to the payload writer. The returned
+// closer flushes the gzip stream and then closes next, which may be nil.
+func compressOutput(next io.Closer) io.Closer {
+	gz := gzip.NewWriter(out)
+	out = gz
+	return gzipCloser{gz: gz, next: next}
+}
+
+type gzipCloser struct {
+	gz   *gzip.Writer
+	next io.Closer
+}
+
+func (c gzipCloser) Close() error {
+	err := c.gz.Close()
+	if c.next != nil {
+		if cerr := c.next.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// closeOutput closes the --file and --sql-out files, flushing a gzip stream
+// first. It runs after failed commands as well, so the rows written before the
+// failure still reach the file as valid gzip, with a warning that they are
+// not all of them.
+func closeOutput(failed bool) error {
+	var err error
+	if sqlOutFile != nil {
+		if cerr := sqlOutFile.Close(); cerr != nil {
+			err = fmt.Errorf("write --sql-out file: %w", cerr)
+		} else if !failed {
+			fmt.Fprintf(os.Stderr, "SQL statements written to %s; the database was not changed.\n", sqlOut)
+		}
+		sqlOutFile = nil
+	}
+	if outputCloser != nil {
+		if cerr := outputCloser.Close(); err == nil {
+			err = cerr
+		}
+		outputCloser = nil
+		if failed && outputFile != "" {
+			fmt.Fprintf(os.Stderr, "WARNING: the command failed, %s holds only the output written before the error.\n", outputFile)
+		}
+	}
+	return err
+}
+
+// formatFromFile guesses the output format from a file name such as
+// users.csv or users.json.gz.
+func formatFromFile(path string) string {
+	if strings.EqualFold(filepath.Ext(strings.TrimSuffix(path, ".gz")), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// printJSON writes v to the payload writer as JSON, indented with --pretty.
+func printJSON(v any) error {
+	enc := json.NewEncoder(out)
+	if prettyJSON {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// csvComma and csvNoHeader are set by --csv-delimiter and --no-header.
+var (
+	csvComma    = ','
+	csvNoHeader bool
+)
+
+// newCSVWriter returns a CSV writer on the payload writer with the
+// configured delimiter.
+func newCSVWriter() *csv.Writer {
+	w := csv.NewWriter(out)
+	w.Comma = csvComma
+	return w
+}
+
+// parseCSVDelimiter checks a --csv-delimiter value: a single character that
+// encoding/csv can write, so not a quote, a line break or a NUL.
+func parseCSVDelimiter(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf("--csv-delimiter must be a single character, got %q", s)
+	}
+	if r == '"' || r == '\r' || r == '\n' || r == 0 {
+		return 0, fmt.Errorf("--csv-delimiter cannot be a quote, a line break or NUL")
+	}
+	return r, nil
+}
+
+// printCSV writes a header and rows to the payload writer as CSV.
+func printCSV(header []string, rows [][]string) error {
+	w := newCSVWriter()
+	if !csvNoHeader {
+		if err := w.Write(header); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// tableStyle, set by --table-style, is how printTable draws text listings.
+var tableStyle = "plain"
+
+// tableStyles are the values --table-style accepts.
+var tableStyles = []string{"plain", "ascii", "markdown"}
+
+// printTable writes a text listing to stdout: columns aligned with spaces,
+// framed in ASCII +---+ borders, or as a Markdown table for pasting into
+// tickets, depending on --table-style. Cells may be colored with
+// database.Highlight; the colors do not count towards the column widths.
+func printTable(header []string, rows [][]string) error {
+	if tableStyle == "markdown" {
+		escaped := make([][]string, 0, len(rows)+1)
+		for _, r := range append([][]string{header}, rows...) {
+			e := make([]string, len(r))
+			for i, cell := range r {
+				e[i] = strings.ReplaceAll(cell, "|", `\|`)
+			}
+			escaped = append(escaped, e)
+		}
+		header, rows = escaped[0], escaped[1:]
+	}
+	widths := make([]int, len(header))
+	for _, r := range append([][]string{header}, rows...) {
+		for i, cell := range r {
+			widths[i] = max(widths[i], visibleWidth(cell))
+		}
+	}
+	if tableStyle == "markdown" {
+		// a delimiter row needs at least three dashes
+		for i := range widths {
+			widths[i] = max(widths[i], 3)
+		}
+	}
+	line := func(r []string) string {
+		cells := make([]string, len(widths))
+		for i, w := range widths {
+			var cell string
+			if i < len(r) {
+				cell = r[i]
+			}
+			cells[i] = cell + strings.Repeat(" ", w-visibleWidth(cell))
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+
+	var b strings.Builder
+	if tableStyle == "plain" {
+		// two spaces between the columns, the last one unpadded
+		for _, r := range append([][]string{header}, rows...) {
+			for i, cell := range r {
+				b.WriteString(cell)
+				if i < len(r)-1 {
+					b.WriteString(strings.Repeat(" ", widths[i]-visibleWidth(cell)+2))
+				}
+			}
+			b.WriteString("\n")
+		}
+	} else if tableStyle == "markdown" {
+		b.WriteString(line(header) + "\n")
+		dashes := make([]string, len(widths))
+		for i, w := range widths {
+			dashes[i] = strings.Repeat("-", w)
+		}
+		b.WriteString("| " + strings.Join(dashes, " | ") + " |\n")
+		for _, r := range rows {
+			b.WriteString(line(r) + "\n")
+		}
+	} else {
+		dashes := make([]string, len(widths))
+		for i, w := range widths {
+			dashes[i] = strings.Repeat("-", w+2)
+		}
+		border := "+" + strings.Join(dashes, "+") + "+\n"
+		b.WriteString(border + line(header) + "\n" + border)
+		for _, r := range rows {
+			b.WriteString(line(r) + "\n")
+		}
+		b.WriteString(border)
+	}
+	_, err := os.Stdout.WriteString(b.String())
+	return err
+}
+
+// ansiEscape matches the color sequences database.Highlight adds.
+var ansiEscape = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// visibleWidth is the number of characters of s a terminal shows, leaving
+// out color sequences.
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(s, ""))
+}
+
+// printData renders v as JSON, or header and rows as CSV, depending on --output.
+func printData(v any, header []string, rows [][]string) error {
+	if outputFormat == "csv" {
+		return printCSV(header, rows)
+	}
+	return printJSON(v)
+}
+
+// recordWriter writes records one at a time, so an export never has to hold
+// the whole encoded document in memory.
+type recordWriter interface {
+	Write(v any, row []string) error
+	Close() error
+}
+
+// newRecordWriter returns a writer for the current --output format. header is
+// only used for CSV.
+func newRecordWriter(header []string) recordWriter {
+	if outputFormat == "csv" {
+		return &csvRecordWriter{w: newCSVWriter(), header: header}
+	}
+	return &jsonArrayWriter{}
+}
+
+// jsonArrayWriter streams records as the elements of one JSON array. Each
+// element is encoded into the same buffer, so a long export allocates no more
+// than the largest record needs.
+type jsonArrayWriter struct {
+	n   int
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+func (j *jsonArrayWriter) Write(v any, _ []string) error {
+	if j.enc == nil {
+		j.enc = json.NewEncoder(&j.buf)
+		if prettyJSON {
+			j.enc.SetIndent("  ", "  ")
+		}
+	}
+	j.buf.Reset()
+	if err := j.enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends every value with a newline
+	buf := bytes.TrimSuffix(j.buf.Bytes(), []byte("\n"))
+
+	sep := ","
+	if j.n == 0 {
+		sep = "["
+	}
+	if prettyJSON {
+		sep += "\n  "
+	}
+	j.n++
+	if _, err := io.WriteString(out, sep); err != nil {
+		return err
+	}
+	_, err := out.Write(buf)
+	return err
+}
+
+func (j *jsonArrayWriter) Close() error {
+	end := "]\n"
+	switch {
+	case j.n == 0:
+		end = "[]\n"
+	case prettyJSON:
+		end = "\n]\n"
+	}
+	_, err := io.WriteString(out, end)
+	return err
+}
+
+// csvRecordWriter streams records as CSV rows below header.
+type csvRecordWriter struct {
+	w      *csv.Writer
+	header []string
+	n      int
+}
+
+func (c *csvRecordWriter) Write(_ any, row []string) error {
+	if c.n == 0 && !csvNoHeader {
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+	}
+	c.n++
+	return c.w.Write(row)
+}
+
+func (c *csvRecordWriter) Close() error {
+	if c.n == 0 && !csvNoHeader {
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// sectionWriter joins the JSON a command prints for each of several sites or
+// prefixes into one array of {"<key>": value, "data": document} objects. It
+// sits in front of the payload writer and streams every document through, so
+// a long listing is never held in memory.
+type sectionWriter struct {
+	w     io.Writer
+	key   string
+	value string
+	n     int  // sections written so far
+	open  bool // the current section has written its opening
+}
+
+// startSections puts a sectionWriter keyed by key in front of the payload
+// writer until finish is called.
+func startSections(key string) *sectionWriter {
+	s := &sectionWriter{w: out, key: key}
+	out = s
+	return s
+}
+
+// begin starts the section for value.
+func (s *sectionWriter) begin(value string) {
+	s.value, s.open = value, false
+}
+
+// opening returns the start of the object of the current section.
+func (s *sectionWriter) opening() string {
+	sep := ","
+	if s.n == 0 {
+		sep = "["
+	}
+	key, _ := json.Marshal(s.key)
+	value, _ := json.Marshal(s.value)
+	return fmt.Sprintf("%s{%s:%s,", sep, key, value)
+}
+
+func (s *sectionWriter) Write(p []byte) (int, error) {
+	if !s.open {
+		if _, err := io.WriteString(s.w, s.opening()+`"data":`); err != nil {
+			return 0, err
+		}
+		s.open = true
+		s.n++
+	}
+	return s.w.Write(p)
+}
+
+// end closes the current section. One that printed nothing is recorded with
+// null data and, when it failed, the error.
+func (s *sectionWriter) end(failed error) error {
+	if s.open {
+		_, err := io.WriteString(s.w, "}\n")
+		return err
+	}
+	closing := `"data":null}`
+	if failed != nil {
+		msg, _ := json.Marshal(failed.Error())
+		closing = fmt.Sprintf(`"data":null,"error":%s}`, msg)
+	}
+	_, err := io.WriteString(s.w, s.opening()+closing+"\n")
+	s.n++
+	return err
+}
+
+// finish closes the array and gives the payload writer back.
+func (s *sectionWriter) finish() error {
+	out = s.w
+	end := "]\n"
+	if s.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(out, end)
+	return err
+}
+
+// listFields, set by users list --fields, names the columns to output, in
+// order. Empty means all of them.
+var listFields []string
+
+// fieldIndexes returns the positions of fields in header, refusing names that
+// are not in it.
+func fieldIndexes(header, fields []string) ([]int, error) {
+	idx := make([]int, len(fields))
+	for i, f := range fields {
+		n := slices.Index(header, f)
+		if n < 0 {
+			return nil, fmt.Errorf("unknown field %q, choose from %s", f, strings.Join(header, ","))
+		}
+		idx[i] = n
+	}
+	return idx, nil
+}
+
+// userWriter returns the record writer for a user listing with header,
+// narrowed to the --fields columns when they are given. With --fields, text
+// output goes through it as well.
+func userWriter(header []string) (recordWriter, error) {
+	if len(listFields) == 0 {
+		return newRecordWriter(header), nil
+	}
+	idx, err := fieldIndexes(header, listFields)
+	if err != nil {
+		return nil, err
+	}
+	w := &fieldWriter{names: listFields, index: idx}
+	if outputFormat != "text" {
+		w.next = newRecordWriter(listFields)
+	}
+	return w, nil
+}
+
+// fieldWriter passes on the selected columns of every record: a JSON object
+// with just those keys, the CSV cells, or a name:value line for text.
+type fieldWriter struct {
+	next  recordWriter
+	names []string
+	index []int
+}
+
+func (f *fieldWriter) Write(v any, row []string) error {
+	cells := make([]string, len(f.index))
+	for i, n := range f.index {
+		cells[i] = row[n]
+	}
+	if f.next == nil {
+		pairs := make([]string, len(cells))
+		for i, c := range cells {
+			pairs[i] = f.names[i] + ":" + c
+		}
+		_, err := fmt.Fprintln(out, strings.Join(pairs, "  "))
+		return err
+	}
+	if outputFormat == "csv" {
+		return f.next.Write(nil, cells)
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &all); err != nil {
+		return err
+	}
+	return f.next.Write(selectedFields{f.names, all}, cells)
+}
+
+func (f *fieldWriter) Close() error {
+	if f.next == nil {
+		return nil
+	}
+	return f.next.Close()
+}
+
+// selectedFields marshals the named values as one JSON object, keeping the
+// order of names. Missing values, such as an omitted empty prefix, are null.
+type selectedFields struct {
+	names  []string
+	values map[string]json.RawMessage
+}
+
+func (s selectedFields) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range s.names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		b.Write(key)
+		b.WriteByte(':')
+		if v, ok := s.values[name]; ok {
+			b.Write(v)
+		} else {
+			b.WriteString("null")
+		}
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+var joomlaUserHeader = []string{"id", "username", "name", "email", "roles", "block", "sendEmail", "isSuperUser"}
+
+func joomlaUserRow(u joomla.UserDetail) []string {
+	return []string{
+		strconv.Itoa(u.ID), u.Username, u.Name, u.Email, strings.Join(u.Roles, ","),
+		strconv.FormatBool(u.Block), strconv.FormatBool(u.SendEmail), strconv.FormatBool(u.IsSuperUser),
+	}
+}
+
+// --no-roles output drops the role columns rather than leaving them empty.
+var joomlaBasicUserHeader = []string{"id", "username", "name", "email", "block", "sendEmail"}
+
+type joomlaBasicUser struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Block     bool   `json:"block"`
+	SendEmail bool   `json:"sendEmail"`
+}
+
+func joomlaBasicUserRecord(u joomla.UserDetail) (any, []string) {
+	return joomlaBasicUser{u.ID, u.Username, u.Name, u.Email, u.Block, u.SendEmail}, []string{
+		strconv.Itoa(u.ID), u.Username, u.Name, u.Email,
+		strconv.FormatBool(u.Block), strconv.FormatBool(u.SendEmail),
+	}
+}
+
+var wordpressUserHeader = []string{"prefix", "id", "username", "email", "name", "role", "firstName", "lastName", "nickname"}
+
+func wordpressUserRow(u wordpress.UserDetail) []string {
+	return []string{
+		u.Prefix, strconv.FormatInt(u.ID, 10), u.Username, u.Email, u.Name, u.Role,
+		u.FirstName, u.LastName, u.Nickname,
+	}
+}
+
+var wordpressBasicUserHeader = []string{"prefix", "id", "username", "email", "name"}
+
+type wordpressBasicUser struct {
+	Prefix   string `json:"prefix,omitempty"`
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+}
+
+func wordpressBasicUserRecord(u wordpress.UserDetail) (any, []string) {
+	return wordpressBasicUser{u.Prefix, u.ID, u.Username, u.Email, u.Name}, []string{
+		u.Prefix, strconv.FormatInt(u.ID, 10), u.Username, u.Email, u.Name,
+	}
+}
+
+var typo3UserHeader = []string{"uid", "username", "realName", "email", "admin", "disable"}
+
+func typo3UserRow(u typo3.UserDetail) []string {
+	return []string{
+		strconv.Itoa(u.ID), u.Username, u.RealName, u.Email,
+		strconv.FormatBool(u.Admin), strconv.FormatBool(u.Disabled),
+	}
+}
+
+var mediawikiUserHeader = []string{"id", "username", "realName", "email", "groups"}
+
+func mediawikiUserRow(u mediawiki.UserDetail) []string {
+	return []string{strconv.Itoa(u.ID), u.Username, u.RealName, u.Email, strings.Join(u.Groups, ",")}
+}
+
+var sessionHeader = []string{"userId", "username", "clientId", "time", "guest"}
+
+func sessionRows(sessions []joomla.Session) [][]string {
+	rows := make([][]string, 0, len(sessions))
+	for _, s := range sessions {
+		rows = append(rows, []string{
+			strconv.Itoa(s.UserID), s.Username, strconv.Itoa(s.ClientID),
+			s.Time.Format(time.RFC3339), strconv.FormatBool(s.Guest),
+		})
+	}
+	return rows
+}
+
+var adminHeader = []string{"id", "username", "email", "role", "lastLogin"}
+
+func adminRows(admins []database.PrivilegedUser) [][]string {
+	rows := make([][]string, 0, len(admins))
+	for _, a := range admins {
+		rows = append(rows, []string{
+			strconv.FormatInt(a.ID, 10), a.Username, a.Email, a.Role, formatLastLogin(a.LastLogin, ""),
+		})
+	}
+	return rows
+}
+
+// formatLastLogin renders a login time as RFC 3339, or never when it is nil.
+func formatLastLogin(t *time.Time, never string) string {
+	if t == nil {
+		return never
+	}
+	return t.Format(time.RFC3339)
+}
+
+// anonymize, set by users export --anonymize, replaces the personal data of
+// exported users with pseudonyms derived from their IDs.
+var anonymize bool
+
+// includeMeta, set by users export --include-meta, adds every usermeta row of
+// a WordPress user to its JSON record.
+var includeMeta bool
+
+var (
+	pseudonymAdjectives = []string{"Amber", "Brave", "Calm", "Dusty", "Eager", "Fancy", "Gentle", "Happy",
+		"Idle", "Jolly", "Keen", "Lucky", "Mellow", "Noble", "Quiet", "Rapid"}
+	pseudonymNouns = []string{"Badger", "Crane", "Dolphin", "Falcon", "Gecko", "Heron", "Ibex", "Jaguar",
+		"Koala", "Lynx", "Marten", "Otter", "Panda", "Quail", "Raven", "Walrus"}
+)
+
+// pseudonym returns the fake identity of the user with the given ID. It only
+// depends on the ID, so repeated exports give a user the same identity.
+func pseudonym(id int64) (username, name, email string) {
+	h := fnv.New32a()
+	fmt.Fprint(h, id)
+	sum := h.Sum32()
+	name = pseudonymAdjectives[sum%uint32(len(pseudonymAdjectives))] + " " +
+		pseudonymNouns[sum/uint32(len(pseudonymAdjectives))%uint32(len(pseudonymNouns))]
+	username = fmt.Sprintf("user%d", id)
+	return username, name, username + "@example.com"
+}
+
+func anonymizeWordPressUser(u *wordpress.UserDetail) {
+	u.Username, u.Name, u.Email = pseudonym(u.ID)
+	u.FirstName, u.LastName, u.Nickname = "", "", ""
+}
+
+func anonymizeJoomlaUser(u *joomla.UserDetail) {
+	u.Username, u.Name, u.Email = pseudonym(int64(u.ID))
+}
+
+func anonymizeTYPO3User(u *typo3.UserDetail) {
+	u.Username, u.RealName, u.Email = pseudonym(int64(u.ID))
+}
+
+func anonymizeMediaWikiUser(u *mediawiki.UserDetail) {
+	u.Username, u.RealName, u.Email = pseudonym(int64(u.ID))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// capture sends the payload to a buffer for the duration of the test.
+func capture(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	saved := out
+	out = &buf
+	t.Cleanup(func() { out = saved })
+	return &buf
+}
+
+func TestSectionWriter(t *testing.T) {
+	buf := capture(t)
+	s := startSections("path")
+	s.begin("/a")
+	if err := printJSON(map[string]int{"users": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.end(nil); err != nil {
+		t.Fatal(err)
+	}
+	s.begin("/b")
+	if err := s.end(errors.New("connection refused")); err != nil {
+		t.Fatal(err)
+	}
+	s.begin("/c")
+	w := newRecordWriter(nil)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.end(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if out != io.Writer(buf) {
+		t.Error("finish did not restore the payload writer")
+	}
+
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not one JSON document: %v\n%s", err, buf)
+	}
+	want := []map[string]any{
+		{"path": "/a", "data": map[string]any{"users": float64(3)}},
+		{"path": "/b", "data": nil, "error": "connection refused"},
+		{"path": "/c", "data": []any{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSectionWriterEmpty(t *testing.T) {
+	buf := capture(t)
+	if err := startSections("prefix").finish(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("got %q, want an empty array", got)
+	}
+}
+
+// --output-file users.json.gz must give a file gunzip turns back into the
+// document.
+func TestOpenOutputGzip(t *testing.T) {
+	saved := out
+	defer func() { out = saved }()
+	path := filepath.Join(t.TempDir(), "exports", "users.json.gz")
+
+	f, err := openOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closer := compressOutput(f)
+	users := []map[string]string{{"username": "alice"}, {"username": "bob"}}
+	if err := printJSON(users); err != nil {
+		t.Fatal(err)
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	gz, err := gzip.NewReader(raw)
+	if err != nil {
+		t.Fatalf("not a gzip file: %v", err)
+	}
+	var got []map[string]string
+	if err := json.NewDecoder(gz).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("got %v, want %v", got, users)
+	}
+}
+
+// A command that fails halfway must still leave a gzip file with its trailer,
+// holding what was written so far.
+func TestCloseOutputAfterFailure(t *testing.T) {
+	saved, savedFile := out, outputFile
+	defer func() { out, outputFile = saved, savedFile }()
+	outputFile = filepath.Join(t.TempDir(), "users.csv.gz")
+
+	f, err := openOutput(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputCloser = compressOutput(f)
+	if _, err := io.WriteString(out, "id,username\n1,alice\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeOutput(true); err != nil {
+		t.Fatal(err)
+	}
+	if outputCloser != nil {
+		t.Error("output was not marked closed")
+	}
+
+	raw, err := os.Open(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	gz, err := gzip.NewReader(raw)
+	if err != nil {
+		t.Fatalf("not a gzip file: %v", err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("gzip stream not finished: %v", err)
+	}
+	if string(got) != "id,username\n1,alice\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestJSONArrayWriter(t *testing.T) {
+	type user struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	for _, tt := range []struct {
+		name   string
+		users  []user
+		pretty bool
+		want   string
+	}{
+		{"empty", nil, false, "[]\n"},
+		{"empty pretty", nil, true, "[]\n"},
+		{"single", []user{{1, "alice"}}, false, `[{"id":1,"name":"alice"}]` + "\n"},
+		{"many", []user{{1, "alice"}, {2, "bob"}, {3, "carol"}}, false,
+			`[{"id":1,"name":"alice"},{"id":2,"name":"bob"},{"id":3,"name":"carol"}]` + "\n"},
+		{"many pretty", []user{{1, "alice"}, {2, "bob"}}, true,
+			"[\n  {\n    \"id\": 1,\n    \"name\": \"alice\"\n  },\n  {\n    \"id\": 2,\n    \"name\": \"bob\"\n  }\n]\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := capture(t)
+			prettyJSON = tt.pretty
+			defer func() { prettyJSON = false }()
+
+			w := &jsonArrayWriter{}
+			for _, u := range tt.users {
+				if err := w.Write(u, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf, tt.want)
+			}
+			var decoded []user
+			if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != len(tt.users) {
+				t.Errorf("decoded %v (%v), want %d records", decoded, err, len(tt.users))
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package passhash implements the password hash schemes of the supported CMSes
+// behind one interface, so creating, changing and verifying passwords share
+// the same code whatever CMS the user belongs to.
+package passhash
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher writes and checks password hashes of one scheme.
+type Hasher interface {
+	// Hash returns a new hash of plain with a fresh salt.
+	Hash(plain string) (string, error)
+	// Verify reports whether plain matches hash. A mismatch is false with a
+	// nil error; an error means hash could not be checked.
+	Verify(hash, plain string) (bool, error)
+}
+
+// Scheme names, as accepted by ByName and Identify.
+const (
+	NameBcrypt          = "bcrypt"
+	NameWordPressBcrypt = "wp-bcrypt"
+	NamePHPass          = "phpass"
+	NameMD5             = "md5"
+	NameSaltedMD5       = "md5-salt"
+	NameArgon2id        = "argon2id"
+)
+
+var schemes = []struct {
+	name    string
+	hasher  Hasher
+	matches func(hash string) bool
+}{
+	{NameWordPressBcrypt, WordPressBcrypt{}, func(h string) bool { return strings.HasPrefix(h, "$wp$") }},
+	{NameBcrypt, Bcrypt{}, func(h string) bool {
+		return strings.HasPrefix(h, "$2y$") || strings.HasPrefix(h, "$2a$") || strings.HasPrefix(h, "$2b$")
+	}},
+	{NameArgon2id, Argon2id{}, func(h string) bool { return strings.HasPrefix(h, "$argon2id$") }},
+	{NamePHPass, PHPass{}, func(h string) bool { return strings.HasPrefix(h, "$P$") || strings.HasPrefix(h, "$H$") }},
+	{NameSaltedMD5, SaltedMD5{}, func(h string) bool { return len(h) > 33 && h[32] == ':' }},
+	{NameMD5, MD5{}, func(h string) bool { return len(h) == 32 }},
+}
+
+// ByName returns the scheme called name, one of the Name constants.
+func ByName(name string) (Hasher, error) {
+	for _, s := range schemes {
+		if s.name == name {
+			return s.hasher, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown hash algorithm %q", name)
+}
+
+// Identify returns the scheme that wrote hash, judged by its format. When
+// names are given only those schemes are considered, so a CMS is never told
+// a hash matches that it would itself reject.
+func Identify(hash string, names ...string) (Hasher, error) {
+	for _, s := range schemes {
+		if len(names) > 0 && !slices.Contains(names, s.name) {
+			continue
+		}
+		if s.matches(hash) {
+			return s.hasher, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported password hash format")
+}
+
+// Verify checks plain against hash with the scheme Identify picks among names.
+func Verify(hash, plain string, names ...string) (bool, error) {
+	h, err := Identify(hash, names...)
+	if err != nil {
+		return false, err
+	}
+	return h.Verify(hash, plain)
+}
+
+// For returns the scheme to write new passwords of cms with in the given major
+// version: plain $2y$ bcrypt for WordPress, which every release accepts
+// (phpass falls back to crypt() before 6.8, and 6.8 and later use
+// password_verify), bcrypt for Joomla 3 and later, and md5:salt for Joomla 1.5
+// and 2.5.
+func For(cms string, major int) (Hasher, error) {
+	switch cms {
+	case "wordpress":
+		return Bcrypt{}, nil
+	case "joomla":
+		if major < 3 {
+			return SaltedMD5{}, nil
+		}
+		return Bcrypt{}, nil
+	}
+	return nil, fmt.Errorf("no password hash scheme known for %s", cms)
+}
+
+// Bcrypt is PHP's password_hash with PASSWORD_BCRYPT at the default cost 10.
+type Bcrypt struct{}
+
+// Hash writes the $2y$ prefix PHP uses; the hash itself is the same as $2a$.
+func (Bcrypt) Hash(plain string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt hash: %w", err)
+	}
+	return "$2y$" + strings.TrimPrefix(string(hash), "$2a$"), nil
+}
+
+// Verify accepts the $2y$, $2a$ and $2b$ variants.
+func (Bcrypt) Verify(hash, plain string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WordPressBcrypt is the $wp$2y$ scheme of WordPress 6.8 and later: bcrypt
+// over an HMAC-SHA384 of the password, so long passwords survive bcrypt's
+// 72 byte limit.
+type WordPressBcrypt struct{}
+
+func wpPrehash(plain string) string {
+	mac := hmac.New(sha512.New384, []byte("wp-sha384"))
+	mac.Write([]byte(plain))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Hash returns a hash as wp_hash_password writes it.
+func (WordPressBcrypt) Hash(plain string) (string, error) {
+	hash, err := Bcrypt{}.Hash(wpPrehash(plain))
+	if err != nil {
+		return "", err
+	}
+	return "$wp" + hash, nil
+}
+
+// Verify checks plain against a $wp$ hash.
+func (WordPressBcrypt) Verify(hash, plain string) (bool, error) {
+	return Bcrypt{}.Verify(strings.TrimPrefix(hash, "$wp"), wpPrehash(plain))
+}
+
+// MD5 is the unsalted hex MD5 of WordPress before 2.5 and Joomla 1.0.
+type MD5 struct{}
+
+// Hash returns the hex MD5 of plain. It is only there for completeness; no
+// supported CMS writes it any more.
+func (MD5) Hash(plain string) (string, error) {
+	sum := md5.Sum([]byte(plain))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify compares case-insensitively, old sites stored upper-case hex too.
+func (MD5) Verify(hash, plain string) (bool, error) {
+	sum := md5.Sum([]byte(plain))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(hash))) == 1, nil
+}
+
+// SaltedMD5 is the md5(password+salt):salt scheme of Joomla 1.5 and 2.5.
+type SaltedMD5 struct{}
+
+// Hash uses a 32 character hex salt like Joomla's genRandomPassword(32).
+func (SaltedMD5) Hash(plain string) (string, error) {
+	saltBytes := make([]byte, 16)
+	if _, err := rand.Read(saltBytes); err != nil {
+		return "", fmt.Errorf("salt gen: %w", err)
+	}
+	salt := hex.EncodeToString(saltBytes)
+	sum := md5.Sum([]byte(plain + salt))
+	return fmt.Sprintf("%x:%s", sum, salt), nil
+}
+
+// Verify also accepts a hash without the :salt part.
+func (SaltedMD5) Verify(hash, plain string) (bool, error) {
+	sum, salt, _ := strings.Cut(hash, ":")
+	computed := md5.Sum([]byte(plain + salt))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(computed[:])), []byte(strings.ToLower(sum))) == 1, nil
+}
+
+// Argon2id is PHP's password_hash with PASSWORD_ARGON2ID, which Joomla 4 and
+// later can be configured to write.
+type Argon2id struct{}
+
+// PHP's PASSWORD_ARGON2ID defaults
+const (
+	argonMemory  = 64 * 1024
+	argonTime    = 4
+	argonThreads = 1
+	argonKeyLen  = 32
+
+	// argonMaxMemory bounds the memory a stored hash may ask Verify to use,
+	// in KiB; PHP's default is 64 MiB, the bound 1 GiB.
+	argonMaxMemory = 1024 * 1024
+)
+
+// Hash hashes plain in the encoding of PHP's password_hash.
+func (Argon2id) Hash(plain string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("salt gen: %w", err)
+	}
+	key := argon2.IDKey([]byte(plain), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks plain against a hash written by Hash or PHP, with whatever
+// parameters it records.
+func (Argon2id) Verify(hash, plain string) (bool, error) {
+	// "", "argon2id", "v=19", "m=65536,t=4,p=1", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, fmt.Errorf("malformed argon2id parameters %q", parts[3])
+	}
+	// argon2.IDKey panics on t=0 or p=0 and would allocate whatever m asks for
+	if iterations < 1 || threads < 1 || memory < 8*uint32(threads) || memory > argonMaxMemory {
+		return false, fmt.Errorf("argon2id parameters %q out of range", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+	if len(key) == 0 {
+		// an empty key would compare equal to the empty result for any password
+		return false, fmt.Errorf("malformed argon2id key: empty")
+	}
+	computed := argon2.IDKey([]byte(plain), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
@@ -0,0 +1,99 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+)
+
+// Hashes read from a database must not be able to crash or exhaust Verify.
+func TestArgon2idVerifyParameters(t *testing.T) {
+	const salt, key = "c29tZXNhbHRzb21lc2FsdA", "aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g"
+	for _, params := range []string{
+		"m=65536,t=0,p=1",
+		"m=65536,t=4,p=0",
+		"m=7,t=4,p=1",
+		"m=64,t=4,p=9",
+		"m=4194304,t=4,p=1",
+	} {
+		hash := "$argon2id$v=19$" + params + "$" + salt + "$" + key
+		if ok, err := (Argon2id{}).Verify(hash, "secret"); ok || err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%s: ok = %v, err = %v, want out of range", params, ok, err)
+		}
+	}
+	if ok, err := (Argon2id{}).Verify("$argon2id$v=19$m=65536,t=4,p=1$"+salt+"$", "anything"); ok || err == nil {
+		t.Errorf("empty key: ok = %v, err = %v, want refused", ok, err)
+	}
+}
+
+// Hashes of "hashcat" written by PHP, WordPress and Joomla, from the hashcat
+// example hashes; Verify must pick the scheme and accept them.
+var knownHashes = []struct {
+	scheme, hash string
+}{
+	{NamePHPass, "$P$984478476IagS59wHZvyQMArzfx58u."},
+	{NameBcrypt, "$2a$05$LhayLxezLhK1LhWvKxCyLOj0j1u.Kj0jZ0pEmm134uzrQlFvQJLF6"},
+	{NameBcrypt, "$2y$05$LhayLxezLhK1LhWvKxCyLOj0j1u.Kj0jZ0pEmm134uzrQlFvQJLF6"},
+	{NameArgon2id, "$argon2id$v=19$m=65536,t=3,p=1$FBMjI4RJBhIykCgol1KEJA$2ky5GAdhT1kH4kIgPN/oERE3Taiy43vNN70a3HpiKQU"},
+	{NameSaltedMD5, "19e0e8d91c722e7091ca7a6a6fb0f4fa:54718031842521651757785603028777"},
+	{NameMD5, "8743b52063cd84097a65d1633f5c74f5"},
+	{NameMD5, "8743B52063CD84097A65D1633F5C74F5"},
+}
+
+func TestVerifyKnownHashes(t *testing.T) {
+	for _, tt := range knownHashes {
+		h, err := Identify(tt.hash)
+		if err != nil {
+			t.Errorf("%s: %v", tt.hash, err)
+			continue
+		}
+		if want, _ := ByName(tt.scheme); h != want {
+			t.Errorf("%s: identified as %T, want %s", tt.hash, h, tt.scheme)
+		}
+		if ok, err := Verify(tt.hash, "hashcat"); !ok || err != nil {
+			t.Errorf("%s: Verify = %v, %v, want true", tt.hash, ok, err)
+		}
+		if ok, _ := Verify(tt.hash, "hashcat2"); ok {
+			t.Errorf("%s: a wrong password verified", tt.hash)
+		}
+	}
+}
+
+// WordPress 6.8 bcrypts base64(HMAC-SHA384("wp-sha384", password)); the
+// expected value was computed independently of this package.
+func TestWordPressPrehash(t *testing.T) {
+	if got, want := wpPrehash("hashcat"), "Ti/q/q1mK4t+L9ZXt+ULwxy0cu0Bra2ocJx1A+Fu6U4oLOjnnE8MeKjIYR+8k6wm"; got != want {
+		t.Errorf("wpPrehash = %s, want %s", got, want)
+	}
+}
+
+// Every scheme must verify what it writes, in the format the CMS expects.
+func TestHashRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		scheme, prefix string
+	}{
+		{NameWordPressBcrypt, "$wp$2y$10$"},
+		{NameBcrypt, "$2y$10$"},
+		{NameArgon2id, "$argon2id$v=19$m=65536,t=4,p=1$"},
+		{NamePHPass, "$P$B"},
+		{NameSaltedMD5, ""},
+		{NameMD5, "8743b52063cd84097a65d1633f5c74f5"},
+	} {
+		h, err := ByName(tt.scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := h.Hash("hashcat")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.scheme, err)
+		}
+		if !strings.HasPrefix(hash, tt.prefix) {
+			t.Errorf("%s: %s does not start with %s", tt.scheme, hash, tt.prefix)
+		}
+		if got, err := Identify(hash); err != nil || got != h {
+			t.Errorf("%s: %s identified as %T, %v", tt.scheme, hash, got, err)
+		}
+		if ok, err := h.Verify(hash, "hashcat"); !ok || err != nil {
+			t.Errorf("%s: Verify = %v, %v, want true", tt.scheme, ok, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package typo3
+
+import (
+	"cmsmgmt/cms"
+	"cmsmgmt/database"
+)
+
+func init() {
+	cms.Register("typo3",
+		func(path string) cms.Evidence {
+			if _, err := FindConfig(path); err != nil {
+				return cms.NotFound
+			}
+			return cms.ConfigFound
+		},
+		func(path string) (cms.CMS, error) { return Site{Path: path}, nil })
+}
+
+// Site is a TYPO3 installation registered with the cms package.
+type Site struct {
+	Path string
+}
+
+// Name implements cms.CMS.
+func (Site) Name() string { return "typo3" }
+
+// DBConfig implements cms.CMS.
+func (s Site) DBConfig() (database.DBConfig, error) {
+	cfgPath, err := FindConfig(s.Path)
+	if err != nil {
+		return database.DBConfig{}, err
+	}
+	return ExtractDBConfig(cfgPath)
+}
+
+// Version implements cms.CMS.
+func (s Site) Version() (string, error) { return GetVersion(s.Path) }
+
+// ShowInfo implements cms.CMS.
+func (s Site) ShowInfo() error { return ShowInfo(s.Path) }
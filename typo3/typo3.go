@@ -0,0 +1,228 @@
+// Package typo3 provides functions to interact with TYPO3 installations.
+package typo3
+
+import (
+	"cmsmgmt/database"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigFiles are the locations of the TYPO3 system configuration relative to the
+// installation root, newest layout first.
+var ConfigFiles = []string{
+	filepath.Join("config", "system", "settings.php"),    // v12+ composer mode
+	filepath.Join("typo3conf", "system", "settings.php"), // v12+ legacy mode
+	filepath.Join("typo3conf", "LocalConfiguration.php"), // v11 and older
+	filepath.Join("public", "typo3conf", "LocalConfiguration.php"),
+}
+
+// ConfigFile, when set, is used by FindConfig instead of searching ConfigFiles.
+var ConfigFile string
+
+// UserDetail represents a TYPO3 backend user.
+type UserDetail struct {
+	ID       int    `json:"uid"`
+	Username string `json:"username"`
+	RealName string `json:"realName"`
+	Email    string `json:"email"`
+	Admin    bool   `json:"admin"`
+	Disabled bool   `json:"disable"`
+}
+
+// FindConfig returns the path of the first TYPO3 configuration file found under cmsPath.
+func FindConfig(cmsPath string) (string, error) {
+	if ConfigFile != "" {
+		return ConfigFile, nil
+	}
+	for _, f := range ConfigFiles {
+		p := filepath.Join(cmsPath, f)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no TYPO3 configuration found in %s", cmsPath)
+}
+
+// ExtractDBConfig extracts the default database connection from a TYPO3
+// settings.php or LocalConfiguration.php file.
+func ExtractDBConfig(filePath string) (database.DBConfig, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return database.DBConfig{}, err
+	}
+
+	cfg := database.DBConfig{
+		Type:      "mysql", // default to MySQL
+		Port:      3306,    // default MySQL port
+		Charset:   database.DefaultCharset,
+		ParseTime: true,
+	}
+
+	// only look at the ['DB']['Connections']['Default'] block
+	text := string(content)
+	if i := strings.Index(text, "'Connections'"); i >= 0 {
+		text = text[i:]
+	}
+	if i := strings.Index(text, "'Default'"); i >= 0 {
+		text = text[i:]
+	}
+
+	str := func(key string) string {
+		re := regexp.MustCompile(`'` + key + `'\s*=>\s*'([^']*)'`)
+		if m := re.FindStringSubmatch(text); len(m) > 1 {
+			return m[1]
+		}
+		return ""
+	}
+
+	switch strings.ToLower(str("driver")) {
+	case "pdo_pgsql", "pgsql":
+		cfg.Type = "postgres"
+		cfg.Port = 5432
+	}
+	cfg.DBName = str("dbname")
+	cfg.User = str("user")
+	cfg.Password = str("password")
+	cfg.Host = str("host")
+	if cs := str("charset"); cs != "" {
+		cfg.Charset = cs
+	}
+	if m := regexp.MustCompile(`'port'\s*=>\s*'?(\d+)'?`).FindStringSubmatch(text); len(m) > 1 {
+		if pn, err := strconv.Atoi(m[1]); err == nil {
+			cfg.Port = pn
+		}
+	}
+
+	return cfg, nil
+}
+
+// ListUsers retrieves the backend users that have not been deleted, narrowed
+// by filter. dbType is the configured database type, used to qualify be_users
+// with the schema on PostgreSQL.
+func ListUsers(db *sql.DB, dbType string, filter database.UserFilter) ([]UserDetail, error) {
+	var users []UserDetail
+	err := ListUsersFunc(db, dbType, filter, func(u UserDetail) error {
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListUsersFunc is like ListUsers but calls fn for each user as the rows are
+// read, stopping at the first error fn returns.
+func ListUsersFunc(db *sql.DB, dbType string, filter database.UserFilter, fn func(UserDetail) error) error {
+	where := "deleted = 0"
+	switch filter.Blocked {
+	case database.BlockedOnly:
+		where += " AND disable = 1"
+	case database.BlockedExclude:
+		where += " AND disable = 0"
+	}
+	// crdate is a Unix timestamp
+	var args []any
+	if !filter.Since.IsZero() {
+		where += " AND crdate >= ?"
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Before.IsZero() {
+		where += " AND crdate < ?"
+		args = append(args, filter.Before.Unix())
+	}
+	if conds, emailArgs := filter.EmailConds("email"); len(conds) > 0 {
+		where += " AND " + conds[0]
+		args = append(args, emailArgs...)
+	}
+
+	rows, err := db.Query(`
+        SELECT uid, username, realName, email, admin, disable
+        FROM `+database.Qualify(dbType, "be_users")+`
+        WHERE `+where+`
+        ORDER BY uid`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u UserDetail
+		if err := rows.Scan(&u.ID, &u.Username, &u.RealName, &u.Email, &u.Admin, &u.Disabled); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetVersion returns the TYPO3 core version, e.g. "12.4.10".
+func GetVersion(cmsPath string) (string, error) {
+	candidates := []string{
+		filepath.Join(cmsPath, "typo3", "sysext", "core", "Classes", "Information", "Typo3Version.php"),
+		filepath.Join(cmsPath, "vendor", "typo3", "cms-core", "Classes", "Information", "Typo3Version.php"),
+	}
+
+	re := regexp.MustCompile(`const\s+VERSION\s*=\s*'([^']+)';`)
+	for _, p := range candidates {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if m := re.FindStringSubmatch(string(buf)); len(m) == 2 {
+			return m[1], nil
+		}
+		return "", fmt.Errorf("could not find TYPO3 version in %s", p)
+	}
+	return "", fmt.Errorf("could not find Typo3Version.php under %s", cmsPath)
+}
+
+// ---------------- public entry points ----------------
+
+// ProcessTYPO3 reads the TYPO3 configuration and connects to its database.
+// The caller must close the returned database.
+func ProcessTYPO3(cmsPath string) (*sql.DB, database.DBConfig, error) {
+	cfgPath, err := FindConfig(cmsPath)
+	if err != nil {
+		return nil, database.DBConfig{}, err
+	}
+	cfg, err := ExtractDBConfig(cfgPath)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("failed to extract TYPO3 DB config: %w", err)
+	}
+
+	db, err := database.Connect(cfg)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if !database.HasTable(db, database.Qualify(cfg.Type, "be_users")) {
+		db.Close()
+		return nil, cfg, &database.NoTablesError{CMS: "TYPO3", DBName: cfg.DBName, Config: cfgPath}
+	}
+	return db, cfg, nil
+}
+
+// ShowInfo displays general information about the TYPO3 installation.
+func ShowInfo(cmsPath string) error {
+	db, cfg, err := ProcessTYPO3(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Println("TYPO3 Information:")
+	fmt.Printf("DB Type  : %s\n", cfg.Type)
+	fmt.Printf("DB Name  : %s\n", cfg.DBName)
+	fmt.Printf("DB User  : %s\n", cfg.User)
+	fmt.Printf("DB Host  : %s\n", cfg.Host)
+	fmt.Printf("DB Port  : %d\n", cfg.Port)
+	fmt.Printf("Server   : %s\n", database.DescribeServer(db))
+	return nil
+}
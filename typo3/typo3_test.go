@@ -0,0 +1,45 @@
+package typo3
+
+import (
+	"cmsmgmt/database"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// On PostgreSQL the listing reads be_users from --db-schema, not from
+// whatever the server's search_path finds first.
+func TestListUsersSchema(t *testing.T) {
+	database.Schema = "cms"
+	defer func() { database.Schema = "public" }()
+	for _, tt := range []struct {
+		dbType, table string
+	}{
+		{"postgres", `FROM "cms".be_users`},
+		{"mysql", "FROM be_users"},
+	} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(tt.table)).
+			WillReturnRows(sqlmock.NewRows([]string{"uid", "username", "realName", "email", "admin", "disable"}).
+				AddRow(1, "admin", "Site Admin", "admin@example.com", true, false))
+
+		var users []UserDetail
+		err = ListUsersFunc(db, tt.dbType, database.UserFilter{}, func(u UserDetail) error {
+			users = append(users, u)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tt.dbType, err)
+		} else if len(users) != 1 || users[0].Username != "admin" || !users[0].Admin {
+			t.Errorf("%s: users = %+v, want admin", tt.dbType, users)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	}
+}
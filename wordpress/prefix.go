@@ -0,0 +1,219 @@
+package wordpress
+
+import (
+	"cmsmgmt/database"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PrefixRename is one change made when moving an install to a new table prefix.
+type PrefixRename struct {
+	Kind string // "table", "usermeta" or "option"
+	Old  string
+	New  string
+}
+
+// PlanPrefixMigration lists the tables, user meta keys and options that embed
+// the prefix from and would be renamed to use the prefix to. Tables of other
+// installs whose prefix merely starts with from (e.g. "wp_blog" for "wp") are
+// left alone.
+func PlanPrefixMigration(db *sql.DB, dbType, from, to string) ([]PrefixRename, error) {
+	if from == to {
+		return nil, fmt.Errorf("old and new prefix are the same")
+	}
+
+	prefixes, err := IdentifyPrefixes(db, dbType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify WordPress prefixes: %v", err)
+	}
+	found := false
+	for _, p := range prefixes {
+		if p == to {
+			return nil, fmt.Errorf("prefix %s_ is already in use", to)
+		}
+		found = found || p == from
+	}
+	if !found {
+		return nil, fmt.Errorf("no WordPress install with prefix %s_ found", from)
+	}
+
+	tables, err := listTables(db, dbType)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		existing[t] = true
+	}
+
+	var plan []PrefixRename
+	for _, t := range tables {
+		if !strings.HasPrefix(t, from+"_") || belongsToOther(t, from, prefixes) {
+			continue
+		}
+		newName := to + strings.TrimPrefix(t, from)
+		if existing[newName] {
+			return nil, fmt.Errorf("table %s already exists", newName)
+		}
+		plan = append(plan, PrefixRename{Kind: "table", Old: t, New: newName})
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT DISTINCT meta_key FROM %[1]s_usermeta WHERE meta_key LIKE '%[2]s\\_%%' ORDER BY meta_key",
+		from, database.EscapeLike(from)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user meta keys: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		plan = append(plan, PrefixRename{Kind: "usermeta", Old: key, New: to + strings.TrimPrefix(key, from)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+
+	// other options starting with wp_ (wp_page_for_privacy_policy, ...) are not
+	// derived from the table prefix and must keep their names
+	var n int
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %[1]s_options WHERE option_name = '%[1]s_user_roles'", from)
+	if err := db.QueryRow(q).Scan(&n); err != nil {
+		return nil, fmt.Errorf("failed to read options: %v", err)
+	}
+	if n > 0 {
+		plan = append(plan, PrefixRename{Kind: "option", Old: from + "_user_roles", New: to + "_user_roles"})
+	}
+
+	return plan, nil
+}
+
+// MigratePrefix applies a plan from PlanPrefixMigration. On PostgreSQL the whole
+// migration runs in one transaction. MySQL cannot roll back DDL, so the tables
+// are renamed in a single atomic RENAME TABLE and the keys are rewritten in a
+// transaction afterwards; if that fails the tables are renamed back.
+func MigratePrefix(db *sql.DB, dbType, from, to string, plan []PrefixRename) error {
+	var tables, keys []PrefixRename
+	for _, r := range plan {
+		if r.Kind == "table" {
+			tables = append(tables, r)
+		} else {
+			keys = append(keys, r)
+		}
+	}
+
+	if strings.ToLower(dbType) == "postgres" {
+		tx, err := database.Begin(db)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		for _, r := range tables {
+			if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s RENAME TO "%s"`, database.Qualify(dbType, `"`+r.Old+`"`), r.New)); err != nil {
+				return fmt.Errorf("failed to rename table %s: %v", r.Old, err)
+			}
+		}
+		if err := renameKeys(tx, to, keys, true); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %v", err)
+		}
+		return nil
+	}
+
+	if _, err := database.Exec(db, renameTablesStatement(tables, false)); err != nil {
+		return fmt.Errorf("failed to rename tables: %w", err)
+	}
+
+	tx, err := database.Begin(db)
+	if err == nil {
+		if err = renameKeys(tx, to, keys, false); err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		if _, rerr := database.Exec(db, renameTablesStatement(tables, true)); rerr != nil {
+			return fmt.Errorf("failed to rewrite keys (%v) and to rename the tables back to %s_: %v", err, from, rerr)
+		}
+		return fmt.Errorf("failed to rewrite keys, tables were renamed back to %s_: %w", from, err)
+	}
+	return nil
+}
+
+func renameTablesStatement(tables []PrefixRename, reverse bool) string {
+	pairs := make([]string, len(tables))
+	for i, r := range tables {
+		if reverse {
+			pairs[i] = fmt.Sprintf("`%s` TO `%s`", r.New, r.Old)
+		} else {
+			pairs[i] = fmt.Sprintf("`%s` TO `%s`", r.Old, r.New)
+		}
+	}
+	return "RENAME TABLE " + strings.Join(pairs, ", ")
+}
+
+// renameKeys rewrites user meta keys and options in the already renamed tables.
+func renameKeys(tx *sql.Tx, to string, keys []PrefixRename, postgres bool) error {
+	set, where := "?", "?"
+	if postgres {
+		set, where = "$1", "$2"
+	}
+	for _, r := range keys {
+		var q string
+		switch r.Kind {
+		case "usermeta":
+			q = fmt.Sprintf("UPDATE %s_usermeta SET meta_key = %s WHERE meta_key = %s", to, set, where)
+		case "option":
+			q = fmt.Sprintf("UPDATE %s_options SET option_name = %s WHERE option_name = %s", to, set, where)
+		default:
+			continue
+		}
+		if _, err := tx.Exec(q, r.New, r.Old); err != nil {
+			return fmt.Errorf("failed to rename %s %s: %v", r.Kind, r.Old, err)
+		}
+	}
+	return nil
+}
+
+// belongsToOther reports whether table belongs to another install whose
+// prefix is longer than from but starts with it.
+func belongsToOther(table, from string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if p != from && strings.HasPrefix(p, from+"_") && strings.HasPrefix(table, p+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+func listTables(db *sql.DB, dbType string) ([]string, error) {
+	query := "SHOW TABLES"
+	var args []any
+	if strings.ToLower(dbType) == "postgres" {
+		query = "SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname = $1"
+		args = append(args, database.Schema)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %v", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables, rows.Err()
+}
@@ -0,0 +1,37 @@
+package wordpress
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// PostgreSQL only understands $n placeholders.
+func TestMigratePrefixPostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(q(`ALTER TABLE "public"."wp_usermeta" RENAME TO "blog_usermeta"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q("UPDATE blog_usermeta SET meta_key = $1 WHERE meta_key = $2")).
+		WithArgs("blog_capabilities", "wp_capabilities").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q("UPDATE blog_options SET option_name = $1 WHERE option_name = $2")).
+		WithArgs("blog_user_roles", "wp_user_roles").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	plan := []PrefixRename{
+		{Kind: "table", Old: "wp_usermeta", New: "blog_usermeta"},
+		{Kind: "usermeta", Old: "wp_capabilities", New: "blog_capabilities"},
+		{Kind: "option", Old: "wp_user_roles", New: "blog_user_roles"},
+	}
+	if err := MigratePrefix(db, "postgres", "wp", "blog", plan); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
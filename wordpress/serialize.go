@@ -0,0 +1,166 @@
+package wordpress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// unserialize decodes a value written by PHP's serialize(). Arrays become
+// map[string]any keyed by the string form of their keys; strings, integers,
+// floats, booleans and null become string, int64, float64, bool and nil.
+// Objects are not supported, WordPress does not store them in capabilities.
+func unserialize(s string) (any, error) {
+	p := phpDecoder{s: s}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(s) {
+		return nil, fmt.Errorf("unexpected data at offset %d", p.pos)
+	}
+	return v, nil
+}
+
+type phpDecoder struct {
+	s   string
+	pos int
+}
+
+// upto returns the text up to the next delim and moves past it.
+func (p *phpDecoder) upto(delim byte) (string, error) {
+	i := strings.IndexByte(p.s[p.pos:], delim)
+	if i < 0 {
+		return "", fmt.Errorf("missing %q after offset %d", delim, p.pos)
+	}
+	v := p.s[p.pos : p.pos+i]
+	p.pos += i + 1
+	return v, nil
+}
+
+func (p *phpDecoder) expect(text string) error {
+	if !strings.HasPrefix(p.s[p.pos:], text) {
+		return fmt.Errorf("expected %q at offset %d", text, p.pos)
+	}
+	p.pos += len(text)
+	return nil
+}
+
+func (p *phpDecoder) value() (any, error) {
+	if p.pos+1 >= len(p.s) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	kind := p.s[p.pos]
+	if kind == 'N' {
+		return nil, p.expect("N;")
+	}
+	if err := p.expect(string(kind) + ":"); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 'b':
+		v, err := p.upto(';')
+		if err != nil {
+			return nil, err
+		}
+		return v == "1", nil
+	case 'i':
+		v, err := p.upto(';')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(v, 10, 64)
+	case 'd':
+		v, err := p.upto(';')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(v, 64)
+	case 's':
+		v, err := p.upto(':')
+		if err != nil {
+			return nil, err
+		}
+		// the length counts bytes, not characters
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || p.pos+n+3 > len(p.s) {
+			return nil, fmt.Errorf("bad string length %q at offset %d", v, p.pos)
+		}
+		if err := p.expect(`"`); err != nil {
+			return nil, err
+		}
+		str := p.s[p.pos : p.pos+n]
+		p.pos += n
+		return str, p.expect(`";`)
+	case 'a':
+		v, err := p.upto(':')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad array length %q at offset %d", v, p.pos)
+		}
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			k, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			var key string
+			switch k := k.(type) {
+			case string:
+				key = k
+			case int64:
+				key = strconv.FormatInt(k, 10)
+			default:
+				return nil, fmt.Errorf("unsupported array key %v at offset %d", k, p.pos)
+			}
+			if m[key], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		return m, p.expect("}")
+	}
+	return nil, fmt.Errorf("unsupported type %q at offset %d", kind, p.pos-2)
+}
+
+// phpTruthy converts a decoded value to a boolean like PHP's (bool) cast.
+func phpTruthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != "" && v != "0"
+	case map[string]any:
+		return len(v) > 0
+	}
+	return false
+}
+
+// ParseCapabilities decodes a serialized capabilities array, such as the
+// <prefix>_capabilities user meta a:1:{s:6:"editor";b:1;} or the capabilities
+// of a role in <prefix>_user_roles, into a map of name to granted.
+func ParseCapabilities(serialized string) (map[string]bool, error) {
+	v, err := unserialize(serialized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode capabilities: %v", err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("capabilities are not an array")
+	}
+	caps := make(map[string]bool, len(m))
+	for k, v := range m {
+		caps[k] = phpTruthy(v)
+	}
+	return caps, nil
+}
@@ -0,0 +1,39 @@
+package wordpress
+
+import (
+	"cmsmgmt/cms"
+	"cmsmgmt/database"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	cms.Register("wordpress",
+		func(path string) cms.Evidence {
+			if _, err := os.Stat(ConfigPath(path)); err == nil {
+				return cms.ConfigFound
+			}
+			return cms.Exists(path, cms.CoreFiles, filepath.Join("wp-includes", "version.php"), "wp-load.php")
+		},
+		func(path string) (cms.CMS, error) { return Site{Path: path}, nil })
+}
+
+// Site is a WordPress installation registered with the cms package.
+type Site struct {
+	Path string
+}
+
+// Name implements cms.CMS.
+func (Site) Name() string { return "wordpress" }
+
+// DBConfig implements cms.CMS.
+func (s Site) DBConfig() (database.DBConfig, error) { return ExtractDBConfig(ConfigPath(s.Path)) }
+
+// Version implements cms.CMS.
+func (s Site) Version() (string, error) { return GetVersion(s.Path) }
+
+// ShowInfo implements cms.CMS.
+func (s Site) ShowInfo() error { return ShowInfo(s.Path) }
+
+// Info returns what ShowInfo prints, for JSON and CSV output.
+func (s Site) Info() (database.InfoReport, error) { return Info(s.Path) }
@@ -0,0 +1 @@
+<?php define('DB_NAME','wordpress');define('DB_USER','wp_user');define('DB_PASSWORD','It\'s a secret');define('DB_HOST','db.example.com:3307');$table_prefix='wp_';define('WP_DEBUG',false);if(!defined('ABSPATH')){define('ABSPATH',__DIR__.'/');}require_once ABSPATH.'wp-settings.php';
\ No newline at end of file
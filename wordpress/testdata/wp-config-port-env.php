@@ -0,0 +1,23 @@
+<?php
+/**
+ * The base configuration for WordPress, as written by the installer.
+ */
+
+// ** Database settings ** //
+define( 'DB_NAME', 'wordpress' );
+define( 'DB_USER', 'wp_user' );
+define( 'DB_PASSWORD', 'It\'s a secret' );
+define( 'DB_HOST', 'db.example.com:3307' );
+define( 'DB_PORT', getenv('WORDPRESS_DB_PORT') );
+define( 'DB_CHARSET', 'utf8mb4' );
+define( 'DB_COLLATE', '' );
+
+$table_prefix = 'wp_';
+
+define( 'WP_DEBUG', false );
+
+if ( ! defined( 'ABSPATH' ) ) {
+	define( 'ABSPATH', __DIR__ . '/' );
+}
+
+require_once ABSPATH . 'wp-settings.php';
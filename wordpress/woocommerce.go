@@ -0,0 +1,116 @@
+package wordpress
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"cmsmgmt/database"
+)
+
+// WooStats are the WooCommerce figures of one install.
+type WooStats struct {
+	Active    bool    `json:"active"` // listed in active_plugins
+	Tables    bool    `json:"tables"` // <prefix>_wc_* tables exist
+	HPOS      bool    `json:"hpos"`   // orders live in <prefix>_wc_orders
+	Customers int     `json:"customers"`
+	Orders    int     `json:"orders"`
+	Revenue   float64 `json:"revenue"`
+	Currency  string  `json:"currency,omitempty"`
+}
+
+// Detected reports whether WooCommerce is installed, as an active plugin or
+// through its tables, which stay behind when the plugin is deactivated.
+func (s WooStats) Detected() bool { return s.Active || s.Tables }
+
+// paidOrderStatuses are the statuses WooCommerce's own reports count as
+// revenue.
+var paidOrderStatuses = []string{"wc-completed", "wc-processing", "wc-on-hold"}
+
+// WooCommerceStats detects WooCommerce and, when it is there, counts the
+// users holding the customer role and the orders, and sums the totals of the
+// paid ones. Orders are read from the HPOS table <prefix>_wc_orders when the
+// site uses it and from the shop_order posts otherwise.
+func WooCommerceStats(db *sql.DB, prefix string) (WooStats, error) {
+	var stats WooStats
+	plugins, err := option(db, prefix, "active_plugins")
+	if err != nil {
+		return stats, err
+	}
+	if decoded, err := unserialize(plugins); err == nil {
+		if list, ok := decoded.(map[string]any); ok {
+			for _, p := range list {
+				if s, ok := p.(string); ok && strings.HasPrefix(s, "woocommerce/") {
+					stats.Active = true
+				}
+			}
+		}
+	}
+	var table string
+	err = db.QueryRow("SHOW TABLES LIKE ?", database.EscapeLike(prefix+"_wc_")+"%").Scan(&table)
+	if err != nil && err != sql.ErrNoRows {
+		return stats, fmt.Errorf("failed to look for WooCommerce tables: %v", err)
+	}
+	stats.Tables = err == nil
+	if !stats.Detected() {
+		return stats, nil
+	}
+
+	stats.Currency, err = option(db, prefix, "woocommerce_currency")
+	if err != nil {
+		return stats, err
+	}
+	if stats.Customers, err = CountUsers(db, prefix, "customer"); err != nil {
+		return stats, err
+	}
+
+	hpos, err := option(db, prefix, "woocommerce_custom_orders_table_enabled")
+	if err != nil {
+		return stats, err
+	}
+	if hpos == "yes" {
+		err := db.QueryRow("SHOW TABLES LIKE ?", database.EscapeLike(prefix+"_wc_orders")).Scan(&table)
+		if err != nil && err != sql.ErrNoRows {
+			return stats, fmt.Errorf("failed to look for the HPOS orders table: %v", err)
+		}
+		stats.HPOS = err == nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(paidOrderStatuses)), ",")
+	args := make([]any, len(paidOrderStatuses))
+	for i, s := range paidOrderStatuses {
+		args[i] = s
+	}
+	var count, revenue string
+	if stats.HPOS {
+		count = fmt.Sprintf("SELECT COUNT(*) FROM %s_wc_orders WHERE type = 'shop_order'", prefix)
+		revenue = fmt.Sprintf(`SELECT COALESCE(SUM(total_amount), 0) FROM %s_wc_orders
+			WHERE type = 'shop_order' AND status IN (%s)`, prefix, placeholders)
+	} else {
+		count = fmt.Sprintf("SELECT COUNT(*) FROM %s_posts WHERE post_type = 'shop_order'", prefix)
+		revenue = fmt.Sprintf(`SELECT COALESCE(SUM(CAST(m.meta_value AS DECIMAL(20,4))), 0)
+			FROM %[1]s_posts p
+			JOIN %[1]s_postmeta m ON m.post_id = p.ID AND m.meta_key = '_order_total'
+			WHERE p.post_type = 'shop_order' AND p.post_status IN (%[2]s)`, prefix, placeholders)
+	}
+	if err := db.QueryRow(count).Scan(&stats.Orders); err != nil {
+		return stats, fmt.Errorf("failed to count orders: %v", err)
+	}
+	if err := db.QueryRow(revenue, args...).Scan(&stats.Revenue); err != nil {
+		return stats, fmt.Errorf("failed to sum revenue: %v", err)
+	}
+	return stats, nil
+}
+
+// option returns the value of the named option, or "" when it is not set.
+func option(db *sql.DB, prefix, name string) (string, error) {
+	var value string
+	err := db.QueryRow(fmt.Sprintf("SELECT option_value FROM %s_options WHERE option_name = ?", prefix), name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read option %s: %v", name, err)
+	}
+	return value, nil
+}
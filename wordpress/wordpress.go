@@ -4,17 +4,74 @@ package wordpress
 import (
 	"bufio"
 	"cmsmgmt/database"
+	"cmsmgmt/passhash"
+	"context"
+	"crypto/rand"
 	"database/sql"
 	"fmt"
+	"math/big"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// ConfigFile, when set, is read instead of wp-config.php in the CMS root, for
+// hardened installs that move or rename it.
+var ConfigFile string
+
+// ConfigPath returns the wp-config.php to read for the install at cmsPath.
+// Like wp-load.php, it falls back to the parent directory when that holds a
+// wp-config.php but is not itself a WordPress root.
+func ConfigPath(cmsPath string) string {
+	if ConfigFile != "" {
+		return ConfigFile
+	}
+	p := filepath.Join(cmsPath, "wp-config.php")
+	if _, err := os.Stat(p); err == nil {
+		return p
+	}
+	abs, err := filepath.Abs(cmsPath)
+	if err != nil {
+		return p
+	}
+	parent := filepath.Dir(abs)
+	if _, err := os.Stat(filepath.Join(parent, "wp-config.php")); err == nil {
+		if _, err := os.Stat(filepath.Join(parent, "wp-settings.php")); err != nil {
+			return filepath.Join(parent, "wp-config.php")
+		}
+	}
+	return p
+}
+
+// UserDetail represents a WordPress user as returned by ListUsers. Fields are
+// emitted in declaration order in JSON output: prefix, id, username, email,
+// name, role, firstName, lastName, nickname.
+type UserDetail struct {
+	Prefix    string `json:"prefix,omitempty"`
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Nickname  string `json:"nickname"`
+
+	// Meta holds every usermeta row of the user when an export asks for it.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	capabilities string // serialized <prefix>_capabilities, for highlighting
+}
+
 // ExtractDBConfig extracts the database configuration from the given WordPress configuration file.
+// It fails when the name, user or host of the database is missing or empty.
 func ExtractDBConfig(filePath string) (database.DBConfig, error) {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -22,29 +79,37 @@ func ExtractDBConfig(filePath string) (database.DBConfig, error) {
 	}
 
 	config := database.DBConfig{
-		Type: "mysql", // Default to MySQL
-		Port: 3306,    // Default MySQL port
+		Type:      "mysql", // Default to MySQL
+		Port:      3306,    // Default MySQL port
+		Charset:   database.DefaultCharset,
+		ParseTime: true,
 	}
 
+	// the value is captured as a PHP expression: a string literal, or a getenv()
+	// call in containerized installs
 	patterns := map[string]*regexp.Regexp{
-		"DBName":     regexp.MustCompile(`define\(\s*'DB_NAME',\s*'(.+)'\s*\)`),
-		"DBUser":     regexp.MustCompile(`define\(\s*'DB_USER',\s*'(.+)'\s*\)`),
-		"DBPassword": regexp.MustCompile(`define\(\s*'DB_PASSWORD',\s*'(.+)'\s*\)`),
-		"DBHost":     regexp.MustCompile(`define\(\s*'DB_HOST',\s*'(.+)'\s*\)`),
+		"DBName":     regexp.MustCompile(`define\(\s*'DB_NAME',\s*(.+?)\s*\)\s*;`),
+		"DBUser":     regexp.MustCompile(`define\(\s*'DB_USER',\s*(.+?)\s*\)\s*;`),
+		"DBPassword": regexp.MustCompile(`define\(\s*'DB_PASSWORD',\s*(.+?)\s*\)\s*;`),
+		"DBHost":     regexp.MustCompile(`define\(\s*'DB_HOST',\s*(.+?)\s*\)\s*;`),
 	}
 
 	for key, pattern := range patterns {
 		matches := pattern.FindStringSubmatch(string(content))
 		if len(matches) > 1 {
+			value, err := configValue(matches[1])
+			if err != nil {
+				return config, err
+			}
 			switch key {
 			case "DBName":
-				config.DBName = matches[1]
+				config.DBName = value
 			case "DBUser":
-				config.User = matches[1]
+				config.User = value
 			case "DBPassword":
-				config.Password = matches[1]
+				config.Password = value
 			case "DBHost":
-				hostPort := matches[1]
+				hostPort := value
 				if host, port, err := net.SplitHostPort(hostPort); err == nil {
 					config.Host = host
 					if portNum, err := strconv.Atoi(port); err == nil {
@@ -57,16 +122,115 @@ func ExtractDBConfig(filePath string) (database.DBConfig, error) {
 		}
 	}
 
+	// some managed hosts keep the port in a constant of its own, which wins
+	// over a port in DB_HOST
+	if m := dbPortDefine.FindStringSubmatch(string(content)); m != nil {
+		value := m[1]
+		if _, err := strconv.Atoi(value); err != nil {
+			if value, err = configValue(value); err != nil {
+				return config, err
+			}
+		}
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return config, fmt.Errorf("%s sets DB_PORT to %q, which is not a port number", filePath, value)
+		}
+		config.Port = port
+	}
+
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"DB_NAME", config.DBName}, {"DB_USER", config.User}, {"DB_HOST", config.Host},
+	} {
+		if f.value == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		return config, fmt.Errorf("%s does not set %s", filePath, strings.Join(missing, ", "))
+	}
 	return config, nil
 }
 
+// dbPortDefine matches define('DB_PORT', ...), with the port as a string or
+// an integer literal.
+var dbPortDefine = regexp.MustCompile(`define\(\s*'DB_PORT',\s*(.+?)\s*\)\s*;`)
+
+// envCall matches getenv('NAME') and the getenv_docker('NAME', 'default')
+// helper of the official WordPress container image.
+var envCall = regexp.MustCompile(`^(?:getenv|getenv_docker)\(\s*['"]([^'"]+)['"]\s*(?:,\s*('(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*")\s*)?\)$`)
+
+// configValue evaluates the value of a define() in wp-config.php: a quoted
+// string, or a call reading an environment variable, which is resolved from
+// the environment of this process. An unset variable is reported with a
+// warning and resolves to its default, or the empty string.
+func configValue(expr string) (string, error) {
+	if m := envCall.FindStringSubmatch(expr); m != nil {
+		if v, ok := os.LookupEnv(m[1]); ok {
+			return v, nil
+		}
+		def, _ := unquotePHP(m[2])
+		return def, database.Warnf("wp-config.php reads %s from the environment, but it is not set", m[1])
+	}
+	if v, ok := unquotePHP(expr); ok {
+		return v, nil
+	}
+	return expr, database.Warnf("cannot evaluate %s in wp-config.php, using it literally", expr)
+}
+
+// unquotePHP returns the value of a single- or double-quoted PHP string
+// literal, undoing the common escapes, and false when s is not one.
+func unquotePHP(s string) (string, bool) {
+	if len(s) < 2 || s[0] != s[len(s)-1] {
+		return "", false
+	}
+	switch s[0] {
+	case '\'':
+		return strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(s[1 : len(s)-1]), true
+	case '"':
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\$`, `$`).Replace(s[1 : len(s)-1]), true
+	}
+	return "", false
+}
+
 // IdentifyPrefixes identifies the table prefixes used in the WordPress database.
 func IdentifyPrefixes(db *sql.DB, dbType string) ([]string, error) {
 	return database.IdentifyPrefixes(db, dbType)
 }
 
 // ListUsers retrieves the list of users from the WordPress database with the given table prefix.
-func ListUsers(db *sql.DB, prefix string) ([]map[string]string, error) {
+// The query is aborted when ctx is cancelled.
+//
+// WordPress has no block flag; a user is treated as blocked when they hold no
+// role on the site, which is what "No role for this site" in wp-admin does.
+func ListUsers(ctx context.Context, db *sql.DB, prefix string, filter database.UserFilter) ([]UserDetail, error) {
+	var users []UserDetail
+	err := ListUsersFunc(ctx, db, prefix, filter, func(u UserDetail) error {
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ListUsersFunc is like ListUsers but calls fn for each user as the rows are
+// read instead of collecting them, so large sites can be listed in constant
+// memory. Iteration stops at the first error returned by fn.
+func ListUsersFunc(ctx context.Context, db *sql.DB, prefix string, filter database.UserFilter, fn func(UserDetail) error) error {
+	defer database.Phase("list users "+prefix, time.Now())
+	if filter.NoRoles {
+		if filter.Blocked != database.BlockedAny {
+			return fmt.Errorf("blocked filters need roles and cannot be used without them")
+		}
+		return listUsersNoRoles(ctx, db, prefix, filter, fn)
+	}
+
+	// Only the four meta keys used are joined. Plugins can keep megabytes of
+	// meta per user; without the filter every row of it is carried into the
+	// GROUP BY, with it the other keys are dropped in the join.
+	where, args := filterWhere(filter)
 	query := fmt.Sprintf(`
 		SELECT u.ID, u.user_login, u.user_email, u.display_name,
 		   MAX(CASE WHEN m.meta_key = '%[1]s_capabilities' THEN m.meta_value ELSE NULL END) AS capabilities,
@@ -75,45 +239,120 @@ func ListUsers(db *sql.DB, prefix string) ([]map[string]string, error) {
 		   MAX(CASE WHEN m.meta_key = 'nickname' THEN m.meta_value ELSE NULL END) AS nickname
 		FROM %[1]s_users u
 		LEFT JOIN %[1]s_usermeta m ON u.ID = m.user_id
-		GROUP BY u.ID, u.user_login, u.user_email, u.display_name`, prefix)
+		     AND m.meta_key IN ('%[1]s_capabilities', 'first_name', 'last_name', 'nickname')
+		%[2]s
+		GROUP BY u.ID, u.user_login, u.user_email, u.display_name`, prefix, where)
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %v", err)
+		return fmt.Errorf("failed to execute query: %v", err)
 	}
 	defer rows.Close()
 
-	var users []map[string]string
 	for rows.Next() {
-		var id, login, email, displayName string
+		var u UserDetail
 		var capabilities, firstName, lastName, nickname sql.NullString
-		err := rows.Scan(&id, &login, &email, &displayName, &capabilities, &firstName, &lastName, &nickname)
+		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Name, &capabilities, &firstName, &lastName, &nickname)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+			return fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		blocked := !hasRole(capabilities.String)
+		if (filter.Blocked == database.BlockedOnly && !blocked) ||
+			(filter.Blocked == database.BlockedExclude && blocked) {
+			continue
 		}
 
-		user := map[string]string{
-			"ID":       id,
-			"Username": login,
-			"Email":    email,
-			"Name":     displayName,
-			"Role":     identifyUserRole(capabilities.String),
+		u.Role = identifyUserRole(capabilities.String)
+		u.capabilities = capabilities.String
+		if u.Role == "Unknown" && !blocked {
+			if err := database.Tolerate(fmt.Errorf("cannot resolve role of user %s from %s_capabilities", u.Username, prefix)); err != nil {
+				return err
+			}
 		}
+		u.FirstName = firstName.String
+		u.LastName = lastName.String
+		u.Nickname = nickname.String
 
-		if firstName.Valid {
-			user["FirstName"] = firstName.String
+		if err := fn(u); err != nil {
+			return err
 		}
-		if lastName.Valid {
-			user["LastName"] = lastName.String
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %v", err)
+	}
+
+	return nil
+}
+
+// UserMeta returns every usermeta row of the user with the given id. A key
+// stored more than once, which WordPress allows, keeps its first value and
+// is reported with a warning.
+func UserMeta(ctx context.Context, db *sql.DB, prefix string, id int64) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx,
+		fmt.Sprintf("SELECT meta_key, meta_value FROM %s_usermeta WHERE user_id = ? ORDER BY umeta_id", prefix), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read meta of user %d: %v", id, err)
+	}
+	defer rows.Close()
+
+	meta := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
 		}
-		if nickname.Valid {
-			user["Nickname"] = nickname.String
+		if _, dup := meta[key]; dup {
+			if err := database.Warnf("user %d has several %s meta rows, only the first is exported", id, key); err != nil {
+				return nil, err
+			}
+			continue
 		}
+		meta[key] = value.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+	return meta, nil
+}
 
-		users = append(users, user)
+// listUsersNoRoles reads the users table without the usermeta join, leaving
+// the role and name meta fields empty.
+func listUsersNoRoles(ctx context.Context, db *sql.DB, prefix string, filter database.UserFilter, fn func(UserDetail) error) error {
+	where, args := filterWhere(filter)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT u.ID, u.user_login, u.user_email, u.display_name FROM %s_users u %s ORDER BY u.ID", prefix, where), args...)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %v", err)
 	}
+	defer rows.Close()
 
-	return users, nil
+	for rows.Next() {
+		var u UserDetail
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Name); err != nil {
+			return fmt.Errorf("failed to scan row: %v", err)
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %v", err)
+	}
+	return nil
+}
+
+// filterWhere returns the WHERE clause for the filter's registration window
+// and e-mail domains.
+func filterWhere(filter database.UserFilter) (string, []any) {
+	conds, args := filter.RegisteredConds("u.user_registered")
+	emailConds, emailArgs := filter.EmailConds("u.user_email")
+	conds, args = append(conds, emailConds...), append(args, emailArgs...)
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
 }
 
 // GetVersion retrieves the version of WordPress from the given path.
@@ -134,6 +373,37 @@ func GetVersion(cmsPath string) (string, error) {
 	return matches[1], nil
 }
 
+// GetDBVersion returns the database schema version of the install, the
+// db_version option that wp-admin/upgrade.php sets after migrating the tables.
+func GetDBVersion(db *sql.DB, prefix string) (int, error) {
+	v, err := option(db, prefix, "db_version")
+	if err != nil {
+		return 0, err
+	}
+	if v == "" {
+		return 0, fmt.Errorf("the db_version option is not set")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid db_version %q", v)
+	}
+	return n, nil
+}
+
+// ExpectedDBVersion returns $wp_db_version from wp-includes/version.php, the
+// database schema version the installed files need.
+func ExpectedDBVersion(cmsPath string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(cmsPath, "wp-includes", "version.php"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read WordPress version file: %v", err)
+	}
+	m := regexp.MustCompile(`\$wp_db_version\s*=\s*(\d+)\s*;`).FindStringSubmatch(string(content))
+	if m == nil {
+		return 0, fmt.Errorf("could not find $wp_db_version in version.php")
+	}
+	return strconv.Atoi(m[1])
+}
+
 // identifyUserRole identifies the role of a user based on the capabilities string.
 func identifyUserRole(capabilities string) string {
 	lowerCaps := strings.ToLower(capabilities)
@@ -151,21 +421,31 @@ func identifyUserRole(capabilities string) string {
 	return "Unknown"
 }
 
-// GetUserByUsername retrieves the user details from the WordPress database with the given username.
-func GetUserByUsername(db *sql.DB, username string) (map[string]string, error) {
-	query := `
+// hasRole reports whether a serialized capabilities value grants at least one role.
+func hasRole(capabilities string) bool {
+	return capabilities != "" && !strings.HasPrefix(capabilities, "a:0:")
+}
+
+// GetUserByUsername retrieves the user details of the install with the given
+// table prefix for the given username.
+func GetUserByUsername(ctx context.Context, db *sql.DB, prefix, username string) (map[string]string, error) {
+	query := fmt.Sprintf(`
 		SELECT u.ID, u.user_login, u.user_email, u.display_name,
 		   MAX(CASE WHEN m.meta_key = 'first_name' THEN m.meta_value ELSE NULL END) AS first_name,
 		   MAX(CASE WHEN m.meta_key = 'last_name' THEN m.meta_value ELSE NULL END) AS last_name,
 		   MAX(CASE WHEN m.meta_key = 'nickname' THEN m.meta_value ELSE NULL END) AS nickname
-		FROM wp_users u
-		LEFT JOIN wp_usermeta m ON u.ID = m.user_id
+		FROM %[1]s_users u
+		LEFT JOIN %[1]s_usermeta m ON u.ID = m.user_id
+		     AND m.meta_key IN ('first_name', 'last_name', 'nickname')
 		WHERE u.user_login = ?
-		GROUP BY u.ID, u.user_login, u.user_email, u.display_name`
+		GROUP BY u.ID, u.user_login, u.user_email, u.display_name`, prefix)
 
 	var id, login, email, displayName string
 	var firstName, lastName, nickname sql.NullString
-	err := db.QueryRow(query, username).Scan(&id, &login, &email, &displayName, &firstName, &lastName, &nickname)
+	err := db.QueryRowContext(ctx, query, username).Scan(&id, &login, &email, &displayName, &firstName, &lastName, &nickname)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", database.ErrUserNotFound, username)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %v", err)
 	}
@@ -190,151 +470,1619 @@ func GetUserByUsername(db *sql.DB, username string) (map[string]string, error) {
 	return user, nil
 }
 
-// UpdateUser updates the user details in the WordPress database.
-func UpdateUser(db *sql.DB, user map[string]string) error {
-	tx, err := db.Begin()
+// GetUserByEmail returns every user of the install with the given table
+// prefix whose e-mail is email, as several accounts may share one address.
+// Roles are the granted roles of the capabilities meta, and the last login is
+// the newest of the last_login meta and the logins of the session tokens.
+func GetUserByEmail(ctx context.Context, db *sql.DB, prefix, email string) ([]database.UserMatch, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT u.ID, u.user_login, u.user_email, c.meta_value, l.meta_value, t.meta_value
+		FROM %[1]s_users u
+		LEFT JOIN %[1]s_usermeta c ON c.user_id = u.ID AND c.meta_key = '%[1]s_capabilities'
+		LEFT JOIN %[1]s_usermeta l ON l.user_id = u.ID AND l.meta_key = ?
+		LEFT JOIN %[1]s_usermeta t ON t.user_id = u.ID AND t.meta_key = 'session_tokens'
+		WHERE u.user_email = ?
+		ORDER BY u.ID`, prefix), LastLoginKey, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	loginRe := regexp.MustCompile(`s:5:"login";i:(\d+);`)
+	var users []database.UserMatch
+	for rows.Next() {
+		u := database.UserMatch{Prefix: prefix}
+		var capabilities, lastLogin, tokens sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &capabilities, &lastLogin, &tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		if capabilities.String != "" {
+			granted, err := ParseCapabilities(capabilities.String)
+			if err != nil {
+				if err := database.Tolerate(fmt.Errorf("user %s: %w", u.Username, err)); err != nil {
+					return nil, err
+				}
+			}
+			for role, ok := range granted {
+				if ok {
+					u.Roles = append(u.Roles, role)
+				}
+			}
+			sort.Strings(u.Roles)
+		}
+		var newest int64
+		if ts, err := strconv.ParseInt(lastLogin.String, 10, 64); err == nil {
+			newest = ts
+		}
+		for _, m := range loginRe.FindAllStringSubmatch(tokens.String, -1) {
+			if ts, err := strconv.ParseInt(m[1], 10, 64); err == nil && ts > newest {
+				newest = ts
+			}
+		}
+		if newest > 0 {
+			t := time.Unix(newest, 0).UTC()
+			u.LastLogin = &t
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+	return users, nil
+}
+
+// UpdateUser updates the user details in the WordPress database in one
+// transaction. Meta rows missing for the user are inserted, and an error is
+// returned when the user itself does not exist. It returns the number of rows
+// of the users table that changed, 0 when the values were already set.
+func UpdateUser(db *sql.DB, prefix string, user map[string]string) (int64, error) {
+	tx, err := database.Begin(db)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Update wp_users table
-	_, err = tx.Exec("UPDATE wp_users SET user_email = ?, display_name = ? WHERE ID = ?",
+	// only a changed address is validated, so legacy data does not block
+	// other edits
+	var current string
+	err = tx.QueryRow(fmt.Sprintf("SELECT user_email FROM %s_users WHERE ID = ?", prefix), user["ID"]).Scan(&current)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("user %s not found", user["ID"])
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to check user: %v", err)
+	}
+	if user["Email"] != current {
+		if err := database.ValidateEmail(user["Email"]); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET user_email = ?, display_name = ? WHERE ID = ?", prefix),
 		user["Email"], user["Name"], user["ID"])
 	if err != nil {
-		return fmt.Errorf("failed to update user: %v", err)
+		return 0, fmt.Errorf("failed to update user: %w", err)
 	}
+	// MySQL counts changed rows only, so 0 just means the values were kept
+	n, _ := res.RowsAffected()
 
-	// Update wp_usermeta table
-	metaFields := map[string]string{
-		"first_name": "FirstName",
-		"last_name":  "LastName",
-		"nickname":   "Nickname",
+	// update the meta fields, adding rows the user does not have yet
+	metaFields := []struct{ metaKey, userKey string }{
+		{"first_name", "FirstName"},
+		{"last_name", "LastName"},
+		{"nickname", "Nickname"},
 	}
 
-	for metaKey, userKey := range metaFields {
-		if value, ok := user[userKey]; ok {
-			_, err = tx.Exec("UPDATE wp_usermeta SET meta_value = ? WHERE user_id = ? AND meta_key = ?",
-				value, user["ID"], metaKey)
-			if err != nil {
-				return fmt.Errorf("failed to update user meta %s: %v", metaKey, err)
+	for _, f := range metaFields {
+		if value, ok := user[f.userKey]; ok {
+			if err := setUserMeta(tx, prefix, user["ID"], f.metaKey, value); err != nil {
+				return 0, err
 			}
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	return nil
+	return n, nil
 }
 
-func ProcessWordPress(cmsPath string) error {
-	configPath := filepath.Join(cmsPath, "wp-config.php")
-	config, err := ExtractDBConfig(configPath)
-	if err != nil {
-		return fmt.Errorf("failed to extract WordPress DB config: %v", err)
+// VerifyPassword reports whether candidate is the password of username. A
+// mismatch returns false without an error.
+func VerifyPassword(db *sql.DB, prefix, username, candidate string) (bool, error) {
+	var hash string
+	q := fmt.Sprintf("SELECT user_pass FROM %s_users WHERE user_login = ?", prefix)
+	if err := db.QueryRow(q, username).Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return false, fmt.Errorf("user %q not found", username)
+		}
+		return false, fmt.Errorf("failed to read password hash: %v", err)
 	}
+	// the formats wp_check_password accepts
+	return passhash.Verify(hash, candidate, passhash.NameWordPressBcrypt, passhash.NameBcrypt, passhash.NamePHPass, passhash.NameMD5)
+}
 
-	db, err := database.Connect(config)
+// GenerateResetKey stores a fresh password reset key for the user and returns the
+// wp-login.php link that lets them choose a new password. The key is hashed with
+// phpass, which WordPress accepts both before and after its 6.8 hashing changes.
+func GenerateResetKey(db *sql.DB, prefix, username string) (string, error) {
+	var siteURL string
+	q := fmt.Sprintf("SELECT option_value FROM %s_options WHERE option_name = 'siteurl'", prefix)
+	if err := db.QueryRow(q).Scan(&siteURL); err != nil {
+		return "", fmt.Errorf("failed to read siteurl option: %v", err)
+	}
+
+	key, err := randomPassword(20)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+		return "", fmt.Errorf("failed to generate reset key: %v", err)
+	}
+	hashed, err := passhash.PHPass{}.Hash(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash reset key: %v", err)
 	}
-	defer db.Close()
 
-	prefixes, err := IdentifyPrefixes(db, config.Type)
+	res, err := database.Exec(db,
+		fmt.Sprintf("UPDATE %s_users SET user_activation_key = ? WHERE user_login = ?", prefix),
+		fmt.Sprintf("%d:%s", time.Now().Unix(), hashed), username)
 	if err != nil {
-		return fmt.Errorf("failed to identify WordPress prefixes: %v", err)
+		return "", fmt.Errorf("failed to store reset key: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		if err := database.Tolerate(fmt.Errorf("failed to check stored reset key: %w", err)); err != nil {
+			return "", err
+		}
+	} else if n != 1 {
+		return "", fmt.Errorf("user %q not found", username)
 	}
 
-	fmt.Printf("WordPress DB Name: %s\n", config.DBName)
-	fmt.Printf("WordPress DB User: %s\n", config.User)
-	fmt.Printf("Identified WordPress table prefixes: %v\n", prefixes)
+	login := strings.ReplaceAll(url.QueryEscape(username), "+", "%20")
+	return fmt.Sprintf("%s/wp-login.php?action=rp&key=%s&login=%s",
+		strings.TrimSuffix(siteURL, "/"), key, login), nil
+}
 
-	for _, prefix := range prefixes {
-		users, err := ListUsers(db, prefix)
+// randomPassword returns n random alphanumeric characters, like wp_generate_password($n, false).
+func randomPassword(n int) (string, error) {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
 		if err != nil {
-			return fmt.Errorf("failed to list WordPress users for prefix %s: %v", prefix, err)
+			return "", err
 		}
-		fmt.Printf("WordPress Users for prefix '%s':\n", prefix)
-		for _, user := range users {
-			fmt.Printf("ID: %s, Username: %s, Email: %s, Role: %s, Name: %s %s, Nickname: %s\n",
-				user["ID"], user["Username"], user["Email"], user["Role"],
-				user["FirstName"], user["LastName"], user["Nickname"])
+		b[i] = chars[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// DuplicateGroup is a set of users sharing the same value in a field.
+type DuplicateGroup struct {
+	Value string       `json:"value"`
+	Users []UserDetail `json:"users"`
+}
+
+// FindDuplicateEmails returns the groups of users that share an e-mail address.
+func FindDuplicateEmails(db *sql.DB, prefix string) ([]DuplicateGroup, error) {
+	return FindDuplicates(db, prefix, "email")
+}
+
+// FindDuplicates returns the groups of users sharing the same (case-insensitive)
+// value in field, which is either "email" or "username".
+func FindDuplicates(db *sql.DB, prefix, field string) ([]DuplicateGroup, error) {
+	var column string
+	switch field {
+	case "email":
+		column = "user_email"
+	case "username":
+		column = "user_login"
+	default:
+		return nil, fmt.Errorf("unsupported duplicate field: %s", field)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT u.ID, u.user_login, u.user_email, u.display_name, d.v
+		FROM %[1]s_users u
+		JOIN (SELECT LOWER(%[2]s) AS v FROM %[1]s_users
+		      GROUP BY LOWER(%[2]s) HAVING COUNT(*) > 1) d ON LOWER(u.%[2]s) = d.v
+		ORDER BY d.v, u.ID`, prefix, column)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var u UserDetail
+		var v string
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Name, &v); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Value != v {
+			groups = append(groups, DuplicateGroup{Value: v})
 		}
+		g := &groups[len(groups)-1]
+		g.Users = append(g.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
 	}
 
-	return nil
+	return groups, nil
 }
 
-func ShowInfo(cmsPath string) error {
-	configPath := filepath.Join(cmsPath, "wp-config.php")
-	config, err := ExtractDBConfig(configPath)
+// defaultRoles are the roles of a stock WordPress install, used when the
+// user_roles option cannot be read.
+var defaultRoles = []string{"administrator", "editor", "author", "contributor", "subscriber"}
+
+// CountUsers returns the number of users, or of users holding role when it is
+// not empty. Roles are matched by slug, e.g. "editor".
+func CountUsers(db *sql.DB, prefix, role string) (int, error) {
+	var n int
+	var err error
+	if role == "" {
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s_users", prefix)).Scan(&n)
+	} else {
+		// capabilities are a serialized array such as a:1:{s:6:"editor";b:1;}
+		role = strings.ToLower(role)
+		pattern := fmt.Sprintf(`%%s:%d:"%s";b:1;%%`, len(role), database.EscapeLike(role))
+		err = db.QueryRow(fmt.Sprintf(
+			"SELECT COUNT(*) FROM %[1]s_usermeta WHERE meta_key = '%[1]s_capabilities' AND meta_value LIKE ?", prefix),
+			pattern).Scan(&n)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to extract WordPress DB config: %v", err)
+		return 0, fmt.Errorf("failed to count users: %v", err)
 	}
+	return n, nil
+}
 
-	db, err := database.Connect(config)
+// CountByRole returns the number of users per role defined on the site, plus
+// a "none" entry for users without any role.
+func CountByRole(db *sql.DB, prefix string) ([]database.RoleCount, error) {
+	roles, err := siteRoles(db, prefix)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+		return nil, err
 	}
-	defer db.Close()
 
-	prefixes, err := IdentifyPrefixes(db, config.Type)
+	counts := make([]database.RoleCount, 0, len(roles)+1)
+	for _, role := range roles {
+		n, err := CountUsers(db, prefix, role)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, database.RoleCount{Role: role, Users: n})
+	}
+
+	var none int
+	err = db.QueryRow(fmt.Sprintf(`
+		SELECT COUNT(*) FROM %[1]s_users u
+		LEFT JOIN %[1]s_usermeta m ON m.user_id = u.ID AND m.meta_key = '%[1]s_capabilities'
+		WHERE m.meta_value IS NULL OR m.meta_value = '' OR m.meta_value LIKE 'a:0:%%'`, prefix)).Scan(&none)
 	if err != nil {
-		return fmt.Errorf("failed to identify WordPress prefixes: %v", err)
+		return nil, fmt.Errorf("failed to count users without a role: %v", err)
 	}
+	return append(counts, database.RoleCount{Role: "none", Users: none}), nil
+}
 
-	fmt.Println("WordPress Information:")
-	fmt.Printf("DB Type: %s\n", config.Type)
-	fmt.Printf("DB Name: %s\n", config.DBName)
-	fmt.Printf("DB User: %s\n", config.User)
-	fmt.Printf("DB Host: %s\n", config.Host)
-	fmt.Printf("DB Port: %d\n", config.Port)
-	fmt.Printf("Table Prefixes: %v\n", prefixes)
+// UserStats returns the user totals of the install: all users, users without
+// a role (which WordPress treats as blocked), users registered at or after
+// since, and the CountByRole breakdown.
+func UserStats(db *sql.DB, prefix string, since time.Time) (database.UserStats, error) {
+	stats := database.UserStats{Since: since.UTC()}
+	q := fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(CASE WHEN user_registered >= ? THEN 1 ELSE 0 END), 0) FROM %s_users", prefix)
+	if err := db.QueryRow(q, since.UTC()).Scan(&stats.Total, &stats.Recent); err != nil {
+		return stats, fmt.Errorf("failed to count users: %v", err)
+	}
 
-	return nil
+	var err error
+	if stats.Roles, err = CountByRole(db, prefix); err != nil {
+		return stats, err
+	}
+	for _, c := range stats.Roles {
+		if c.Role == "none" {
+			stats.Blocked = c.Users
+		}
+	}
+	return stats, nil
 }
 
-func EditUser(cmsPath, username string) error {
-	configPath := filepath.Join(cmsPath, "wp-config.php")
-	config, err := ExtractDBConfig(configPath)
+// siteRoles returns the role slugs from the user_roles option, falling back
+// to the stock roles when the option is missing.
+func siteRoles(db *sql.DB, prefix string) ([]string, error) {
+	var value string
+	err := db.QueryRow(fmt.Sprintf("SELECT option_value FROM %[1]s_options WHERE option_name = '%[1]s_user_roles'", prefix)).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			if err := database.Tolerate(fmt.Errorf("no %s_user_roles option found", prefix)); err != nil {
+				return nil, err
+			}
+			return defaultRoles, nil
+		}
+		return nil, fmt.Errorf("failed to read user roles: %v", err)
+	}
+
+	var roles []string
+	re := regexp.MustCompile(`s:\d+:"([^"]+)";a:\d+:\{s:4:"name";`)
+	for _, m := range re.FindAllStringSubmatch(value, -1) {
+		roles = append(roles, m[1])
+	}
+	if len(roles) == 0 {
+		if err := database.Tolerate(fmt.Errorf("cannot parse %s_user_roles", prefix)); err != nil {
+			return nil, err
+		}
+		return defaultRoles, nil
+	}
+	return roles, nil
+}
+
+// adminCapability is what makes a role administrative: manage_options opens
+// the site settings and is held by no stock role except administrator.
+const adminCapability = "manage_options"
+
+// PrivilegedRoles returns the slugs of the roles that grant manage_options.
+func PrivilegedRoles(db *sql.DB, prefix string) ([]string, error) { return privilegedRoles(db, prefix) }
+
+// privilegedRoles returns the slugs of the roles in the user_roles option
+// that grant adminCapability, so renamed or custom admin roles are found too.
+func privilegedRoles(db *sql.DB, prefix string) ([]string, error) {
+	var value string
+	err := db.QueryRow(fmt.Sprintf("SELECT option_value FROM %[1]s_options WHERE option_name = '%[1]s_user_roles'", prefix)).Scan(&value)
 	if err != nil {
-		return fmt.Errorf("failed to extract WordPress DB config: %v", err)
+		if err == sql.ErrNoRows {
+			if err := database.Tolerate(fmt.Errorf("no %s_user_roles option found", prefix)); err != nil {
+				return nil, err
+			}
+			return []string{"administrator"}, nil
+		}
+		return nil, fmt.Errorf("failed to read user roles: %v", err)
 	}
 
-	db, err := database.Connect(config)
+	// each role's capabilities run up to the start of the next role
+	grant := fmt.Sprintf(`s:%d:"%s";b:1;`, len(adminCapability), adminCapability)
+	re := regexp.MustCompile(`s:\d+:"([^"]+)";a:\d+:\{s:4:"name";`)
+	matches := re.FindAllStringSubmatchIndex(value, -1)
+	var roles []string
+	for i, m := range matches {
+		end := len(value)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		if strings.Contains(value[m[1]:end], grant) {
+			roles = append(roles, value[m[2]:m[3]])
+		}
+	}
+	if len(roles) == 0 {
+		if err := database.Tolerate(fmt.Errorf("no role in %s_user_roles grants %s", prefix, adminCapability)); err != nil {
+			return nil, err
+		}
+		return []string{"administrator"}, nil
+	}
+	return roles, nil
+}
+
+// ListAdmins returns the users holding a role that grants manage_options.
+// WordPress does not record logins, so LastLogin is the newest login among the
+// user's current session tokens and nil when they have none.
+func ListAdmins(ctx context.Context, db *sql.DB, prefix string) ([]database.PrivilegedUser, error) {
+	roles, err := privilegedRoles(db, prefix)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+		return nil, err
+	}
+
+	patterns := make([]string, len(roles))
+	conds := make([]string, len(roles))
+	args := make([]any, len(roles))
+	for i, role := range roles {
+		patterns[i] = fmt.Sprintf(`s:%d:"%s";b:1;`, len(role), role)
+		conds[i] = "c.meta_value LIKE ?"
+		args[i] = "%" + database.EscapeLike(patterns[i]) + "%"
 	}
-	defer db.Close()
 
-	user, err := GetUserByUsername(db, username)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT u.ID, u.user_login, u.user_email, c.meta_value, t.meta_value
+		FROM %[1]s_users u
+		JOIN %[1]s_usermeta c ON c.user_id = u.ID AND c.meta_key = '%[1]s_capabilities'
+		LEFT JOIN %[1]s_usermeta t ON t.user_id = u.ID AND t.meta_key = 'session_tokens'
+		WHERE %[2]s
+		ORDER BY u.ID`, prefix, strings.Join(conds, " OR ")), args...)
 	if err != nil {
-		return fmt.Errorf("failed to get user: %v", err)
+		return nil, fmt.Errorf("failed to execute query: %v", err)
 	}
+	defer rows.Close()
 
-	fmt.Println("Current user details:")
-	for key, value := range user {
-		if key != "ID" && key != "Password" {
-			fmt.Printf("%s: %s\n", key, value)
+	loginRe := regexp.MustCompile(`s:5:"login";i:(\d+);`)
+	var admins []database.PrivilegedUser
+	for rows.Next() {
+		var u database.PrivilegedUser
+		var capabilities string
+		var tokens sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &capabilities, &tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		for i, p := range patterns {
+			if strings.Contains(capabilities, p) {
+				u.Role = roles[i]
+				break
+			}
+		}
+		var newest int64
+		for _, m := range loginRe.FindAllStringSubmatch(tokens.String, -1) {
+			if ts, err := strconv.ParseInt(m[1], 10, 64); err == nil && ts > newest {
+				newest = ts
+			}
+		}
+		if newest > 0 {
+			t := time.Unix(newest, 0).UTC()
+			u.LastLogin = &t
 		}
+		admins = append(admins, u)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+	return admins, nil
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	for key := range user {
-		if key != "ID" && key != "Password" {
-			fmt.Printf("Enter new %s (or press Enter to keep current value): ", key)
-			input, _ := reader.ReadString('\n')
-			input = strings.TrimSpace(input)
-			if input != "" {
-				user[key] = input
+// Capability is one decoded entry of a user's permissions.
+type Capability struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"` // "role" or "capability"
+	Granted bool   `json:"granted"`
+	// Source is the role a capability comes from, or "user" for the entries
+	// of the user's own capabilities meta
+	Source string `json:"source"`
+}
+
+// UserCapabilities is the decoded permission set of one user.
+type UserCapabilities struct {
+	Username     string       `json:"username"`
+	UserLevel    *int         `json:"userLevel"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// GetCapabilities decodes the <prefix>_capabilities and <prefix>_user_level
+// meta of username. The roles found there are expanded into their capabilities
+// from the <prefix>_user_roles option. Entries are sorted by kind, name and source.
+func GetCapabilities(db *sql.DB, prefix, username string) (UserCapabilities, error) {
+	result := UserCapabilities{Username: username, Capabilities: []Capability{}}
+
+	var capabilities, level sql.NullString
+	q := fmt.Sprintf(`
+		SELECT MAX(CASE WHEN m.meta_key = '%[1]s_capabilities' THEN m.meta_value ELSE NULL END),
+		       MAX(CASE WHEN m.meta_key = '%[1]s_user_level' THEN m.meta_value ELSE NULL END)
+		FROM %[1]s_users u
+		LEFT JOIN %[1]s_usermeta m ON m.user_id = u.ID
+		     AND m.meta_key IN ('%[1]s_capabilities', '%[1]s_user_level')
+		WHERE u.user_login = ?
+		GROUP BY u.ID`, prefix)
+	if err := db.QueryRow(q, username).Scan(&capabilities, &level); err != nil {
+		if err == sql.ErrNoRows {
+			return result, fmt.Errorf("user %q not found", username)
+		}
+		return result, fmt.Errorf("failed to read capabilities: %v", err)
+	}
+
+	if level.Valid {
+		n, err := strconv.Atoi(strings.TrimSpace(level.String))
+		if err != nil {
+			if err := database.Tolerate(fmt.Errorf("cannot parse %s_user_level %q of user %s", prefix, level.String, username)); err != nil {
+				return result, err
 			}
+		} else {
+			result.UserLevel = &n
+		}
+	}
+	if !capabilities.Valid {
+		return result, nil
+	}
+	own, err := ParseCapabilities(capabilities.String)
+	if err != nil {
+		return result, err
+	}
+
+	roles, err := roleCapabilities(db, prefix)
+	if err != nil {
+		return result, err
+	}
+	for name, granted := range own {
+		caps, isRole := roles[name]
+		kind := "capability"
+		if isRole {
+			kind = "role"
+		}
+		result.Capabilities = append(result.Capabilities, Capability{Name: name, Kind: kind, Granted: granted, Source: "user"})
+		if !isRole || !granted {
+			continue
+		}
+		for c, g := range caps {
+			result.Capabilities = append(result.Capabilities, Capability{Name: c, Kind: "capability", Granted: g, Source: name})
+		}
+	}
+
+	sort.Slice(result.Capabilities, func(i, j int) bool {
+		a, b := result.Capabilities[i], result.Capabilities[j]
+		if a.Kind != b.Kind {
+			return a.Kind == "role"
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
 		}
+		return a.Source < b.Source
+	})
+	return result, nil
+}
+
+// roleCapabilities returns the capabilities of every role defined in the
+// user_roles option. When the option is missing or unreadable the stock roles
+// are returned without capabilities.
+func roleCapabilities(db *sql.DB, prefix string) (map[string]map[string]bool, error) {
+	stock := make(map[string]map[string]bool, len(defaultRoles))
+	for _, r := range defaultRoles {
+		stock[r] = nil
+	}
+
+	var value string
+	err := db.QueryRow(fmt.Sprintf("SELECT option_value FROM %[1]s_options WHERE option_name = '%[1]s_user_roles'", prefix)).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return stock, database.Tolerate(fmt.Errorf("no %s_user_roles option found, roles are not expanded", prefix))
+		}
+		return nil, fmt.Errorf("failed to read user roles: %v", err)
+	}
+
+	decoded, err := unserialize(value)
+	defs, ok := decoded.(map[string]any)
+	if err != nil || !ok {
+		return stock, database.Tolerate(fmt.Errorf("cannot parse %s_user_roles, roles are not expanded", prefix))
+	}
+	roles := make(map[string]map[string]bool, len(defs))
+	for name, def := range defs {
+		caps := map[string]bool{}
+		if d, ok := def.(map[string]any); ok {
+			if c, ok := d["capabilities"].(map[string]any); ok {
+				for k, v := range c {
+					caps[k] = phpTruthy(v)
+				}
+			}
+		}
+		roles[name] = caps
+	}
+	return roles, nil
+}
+
+// FindOrphans reports user meta rows of users that no longer exist and users
+// without any capabilities row for the prefix.
+func FindOrphans(db *sql.DB, prefix string) ([]database.IntegrityIssue, error) {
+	checks := []struct {
+		issue database.IntegrityIssue
+		query string
+	}{
+		{
+			database.IntegrityIssue{Table: prefix + "_usermeta", Issue: "meta of missing users", Fixable: true},
+			fmt.Sprintf("SELECT COUNT(*) FROM %[1]s_usermeta WHERE user_id NOT IN (SELECT ID FROM %[1]s_users)", prefix),
+		},
+		{
+			database.IntegrityIssue{Table: prefix + "_users", Issue: "users without a role mapping"},
+			fmt.Sprintf(`SELECT COUNT(*) FROM %[1]s_users u
+				LEFT JOIN %[1]s_usermeta m ON m.user_id = u.ID AND m.meta_key = '%[1]s_capabilities'
+				WHERE m.umeta_id IS NULL`, prefix),
+		},
+	}
+
+	issues := make([]database.IntegrityIssue, 0, len(checks))
+	for _, c := range checks {
+		if err := db.QueryRow(c.query).Scan(&c.issue.Rows); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %v", c.issue.Table, err)
+		}
+		issues = append(issues, c.issue)
+	}
+	return issues, nil
+}
+
+// FixOrphans deletes the user meta rows of users that no longer exist in one
+// transaction and returns the number of rows removed.
+func FixOrphans(db *sql.DB, prefix string) (int64, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(fmt.Sprintf("DELETE FROM %[1]s_usermeta WHERE user_id NOT IN (SELECT ID FROM %[1]s_users)", prefix))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete orphaned user meta: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		if err := database.Tolerate(fmt.Errorf("failed to count deleted rows: %v", err)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return n, nil
+}
+
+// RenameUser changes the login of oldLogin to newLogin and derives a new
+// user_nicename (the author URL slug) from it, as WordPress does when a user
+// is created. It returns the number of users rows updated.
+func RenameUser(db *sql.DB, prefix, oldLogin, newLogin string) (int64, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if err := tx.QueryRow(fmt.Sprintf("SELECT ID FROM %s_users WHERE user_login = ?", prefix), oldLogin).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("user %q not found", oldLogin)
+		}
+		return 0, fmt.Errorf("failed to read user: %v", err)
+	}
+
+	// logins compare case-insensitively on most collations, so the user itself
+	// must not count when only the case changes
+	var taken int
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE user_login = ? AND ID <> ?", prefix)
+	if err := tx.QueryRow(q, newLogin, id).Scan(&taken); err != nil {
+		return 0, fmt.Errorf("failed to check new login: %v", err)
+	}
+	if taken > 0 {
+		return 0, fmt.Errorf("login %q is already taken", newLogin)
+	}
+
+	nicename, err := uniqueNicename(tx, prefix, sanitizeNicename(newLogin), id)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %s_users SET user_login = ?, user_nicename = ? WHERE ID = ?", prefix),
+		newLogin, nicename, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rename user: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		if err := database.Tolerate(fmt.Errorf("failed to check rename: %w", err)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return n, nil
+}
+
+// stockUserLevels are the user_level values of the stock roles, used when the
+// site's user_roles option cannot be read.
+var stockUserLevels = map[string]int{"administrator": 10, "editor": 7, "author": 2, "contributor": 1, "subscriber": 0}
+
+// CreateUser adds a user with the given roles in one transaction, the way
+// wp_insert_user does: a bcrypt user_pass, a unique user_nicename derived from
+// the login, the serialized <prefix>_capabilities, the highest level_N of the
+// roles as <prefix>_user_level, and the first_name, last_name and nickname
+// meta. display defaults to the login. With requireReset the ResetRequiredKey
+// meta is set too. It returns the new user's ID.
+func CreateUser(db *sql.DB, prefix string, login, email, display, password string, roles []string, requireReset bool) (int, error) {
+	if login == "" {
+		return 0, fmt.Errorf("login cannot be empty")
+	}
+	if err := database.ValidateEmail(email); err != nil {
+		return 0, err
+	}
+	if display == "" {
+		display = login
+	}
+
+	defs, err := roleCapabilities(db, prefix)
+	if err != nil {
+		return 0, err
+	}
+	caps, level, err := roleMeta(defs, roles)
+	if err != nil {
+		return 0, err
+	}
+
+	// plain $2y$ bcrypt, which every release accepts: before 6.8 phpass falls
+	// back to crypt(), from 6.8 wp_check_password uses password_verify
+	hashed, err := passhash.Bcrypt{}.Hash(password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var taken int
+	if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE user_login = ?", prefix), login).Scan(&taken); err != nil {
+		return 0, fmt.Errorf("failed to check login: %v", err)
+	}
+	if taken > 0 {
+		return 0, fmt.Errorf("login %q is already taken", login)
+	}
+	if email != "" {
+		if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE user_email = ?", prefix), email).Scan(&taken); err != nil {
+			return 0, fmt.Errorf("failed to check e-mail: %v", err)
+		}
+		if taken > 0 {
+			return 0, fmt.Errorf("e-mail %q is already used by another user", email)
+		}
+	}
+
+	nicename, err := uniqueNicename(tx, prefix, sanitizeNicename(login), 0)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s_users (user_login, user_pass, user_nicename, user_email, user_url, user_registered,
+		                      user_activation_key, user_status, display_name)
+		VALUES (?, ?, ?, ?, '', ?, '', 0, ?)`, prefix),
+		login, hashed, nicename, email, time.Now().UTC().Format(time.DateTime), display)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create user: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read new user ID: %v", err)
+	}
+
+	meta := []struct{ key, value string }{
+		{prefix + "_capabilities", caps},
+		{prefix + "_user_level", strconv.Itoa(level)},
+		{"first_name", ""},
+		{"last_name", ""},
+		{"nickname", login},
+	}
+	if requireReset {
+		meta = append(meta, struct{ key, value string }{ResetRequiredKey, "1"})
+	}
+	for _, m := range meta {
+		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s_usermeta (user_id, meta_key, meta_value) VALUES (?, ?, ?)", prefix),
+			id, m.key, m.value); err != nil {
+			return 0, fmt.Errorf("failed to add user meta %s: %v", m.key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return int(id), nil
+}
+
+// roleMeta builds the serialized <prefix>_capabilities value granting roles,
+// which must all be defined on the site, and the matching <prefix>_user_level:
+// the highest level_N among them.
+func roleMeta(defs map[string]map[string]bool, roles []string) (string, int, error) {
+	level := 0
+	var caps strings.Builder
+	fmt.Fprintf(&caps, "a:%d:{", len(roles))
+	for _, r := range roles {
+		granted, ok := defs[r]
+		if !ok {
+			return "", 0, fmt.Errorf("role %q does not exist", r)
+		}
+		for c, g := range granted {
+			if n, ok := strings.CutPrefix(c, "level_"); ok && g {
+				if v, err := strconv.Atoi(n); err == nil && v > level {
+					level = v
+				}
+			}
+		}
+		if n := stockUserLevels[r]; granted == nil && n > level {
+			level = n
+		}
+		fmt.Fprintf(&caps, `s:%d:"%s";b:1;`, len(r), r)
+	}
+	caps.WriteString("}")
+	return caps.String(), level, nil
+}
+
+// SetRoles replaces the roles of username with roles in one transaction and
+// returns the user's ID and the roles the user had. With dryRun the roles and
+// the user are checked and nothing is written. An unknown user gives
+// database.ErrUserNotFound.
+func SetRoles(db *sql.DB, prefix, username string, roles []string, dryRun bool) (int64, []string, error) {
+	defs, err := roleCapabilities(db, prefix)
+	if err != nil {
+		return 0, nil, err
+	}
+	caps, level, err := roleMeta(defs, roles)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var q queryer = db
+	var tx *sql.Tx
+	if !dryRun {
+		if tx, err = database.Begin(db); err != nil {
+			return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	var id int64
+	var current sql.NullString
+	query := fmt.Sprintf(`SELECT u.ID, m.meta_value FROM %[1]s_users u
+		LEFT JOIN %[1]s_usermeta m ON m.user_id = u.ID AND m.meta_key = '%[1]s_capabilities'
+		WHERE u.user_login = ?`, prefix)
+	if err := q.QueryRow(query, username).Scan(&id, &current); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil, fmt.Errorf("%w: %s", database.ErrUserNotFound, username)
+		}
+		return 0, nil, fmt.Errorf("failed to read user: %v", err)
+	}
+	var old []string
+	if granted, err := ParseCapabilities(current.String); err == nil {
+		for r, ok := range granted {
+			if ok {
+				old = append(old, r)
+			}
+		}
+		sort.Strings(old)
+	}
+	if dryRun {
+		return id, old, nil
+	}
+
+	if err := setUserMeta(tx, prefix, id, prefix+"_capabilities", caps); err != nil {
+		return 0, nil, err
+	}
+	if err := setUserMeta(tx, prefix, id, prefix+"_user_level", strconv.Itoa(level)); err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return id, old, nil
+}
+
+// KeepSingleAdmin gives every administrator except keep the role to instead,
+// in one transaction, and returns the demoted accounts with the role they had.
+// keep must hold an administrative role and to must not grant manage_options.
+// With dryRun nothing is written.
+func KeepSingleAdmin(ctx context.Context, db *sql.DB, prefix, keep, to string, dryRun bool) ([]database.PrivilegedUser, error) {
+	privileged, err := privilegedRoles(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(privileged, to) {
+		return nil, fmt.Errorf("role %q grants %s, choose a lower role with --to", to, adminCapability)
+	}
+	defs, err := roleCapabilities(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	caps, level, err := roleMeta(defs, []string{to})
+	if err != nil {
+		return nil, err
+	}
+	admins, err := ListAdmins(ctx, db, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var demoted []database.PrivilegedUser
+	kept := false
+	for _, a := range admins {
+		if a.Username == keep {
+			kept = true
+		} else {
+			demoted = append(demoted, a)
+		}
+	}
+	if !kept {
+		return nil, fmt.Errorf("%s is not an administrator, nothing was changed", keep)
+	}
+	if dryRun {
+		return demoted, nil
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, u := range demoted {
+		if err := setUserMeta(tx, prefix, u.ID, prefix+"_capabilities", caps); err != nil {
+			return nil, fmt.Errorf("failed to demote %s: %w", u.Username, err)
+		}
+		if err := setUserMeta(tx, prefix, u.ID, prefix+"_user_level", strconv.Itoa(level)); err != nil {
+			return nil, fmt.Errorf("failed to demote %s: %w", u.Username, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return demoted, nil
+}
+
+// queryer is the reading half of *sql.DB and *sql.Tx, so a dry run can make
+// the checks of a change without opening a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// MergeUsers folds the account from into the account into and deletes from,
+// in one transaction. The posts of from are reassigned, and its meta moves
+// over unless into already has the key, in which case into's value is kept;
+// session tokens are dropped. Removing the last administrator is refused
+// through database.Guard. With dryRun nothing is written and the rows each
+// step would change are counted instead. An unknown user gives
+// database.ErrUserNotFound.
+func MergeUsers(ctx context.Context, db *sql.DB, prefix, from, into string, dryRun bool) ([]database.MergeStep, error) {
+	admins, err := ListAdmins(ctx, db, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var q queryer = db
+	var tx *sql.Tx
+	if !dryRun {
+		if tx, err = database.Begin(db); err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	ids := make([]int64, 2)
+	for i, login := range []string{from, into} {
+		err := q.QueryRowContext(ctx, fmt.Sprintf("SELECT ID FROM %s_users WHERE user_login = ?", prefix), login).Scan(&ids[i])
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", database.ErrUserNotFound, login)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user: %v", err)
+		}
+	}
+	fromID, intoID := ids[0], ids[1]
+	if fromID == intoID {
+		return nil, fmt.Errorf("%s and %s are the same user", from, into)
+	}
+
+	var fromAdmin, intoAdmin bool
+	for _, a := range admins {
+		fromAdmin = fromAdmin || a.ID == fromID
+		intoAdmin = intoAdmin || a.ID == intoID
+	}
+	if fromAdmin && !intoAdmin && len(admins) == 1 {
+		if err := database.Guard("%s is the last administrator and %s would not inherit the role", from, into); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make(map[string]bool)
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT meta_key FROM %s_usermeta WHERE user_id = ?", prefix), intoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user meta: %v", err)
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		keys[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+
+	var move []any
+	var fromMeta int64
+	rows, err = q.QueryContext(ctx, fmt.Sprintf("SELECT umeta_id, meta_key FROM %s_usermeta WHERE user_id = ?", prefix), fromID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user meta: %v", err)
+	}
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		fromMeta++
+		if !keys[key] && key != "session_tokens" {
+			move = append(move, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+
+	usermeta := prefix + "_usermeta"
+	if dryRun {
+		var authored int64
+		count := fmt.Sprintf("SELECT COUNT(*) FROM %s_posts WHERE post_author = ?", prefix)
+		if err := db.QueryRowContext(ctx, count, fromID).Scan(&authored); err != nil {
+			return nil, fmt.Errorf("failed to count posts: %v", err)
+		}
+		return []database.MergeStep{
+			{Table: usermeta, Action: "move meta to " + into, Rows: int64(len(move))},
+			{Table: usermeta, Action: "drop remaining meta", Rows: fromMeta - int64(len(move))},
+			{Table: prefix + "_posts", Action: "reassign post_author", Rows: authored},
+			{Table: prefix + "_users", Action: "delete " + from, Rows: 1},
+		}, nil
+	}
+
+	var steps []database.MergeStep
+	exec := func(table, action, query string, args ...any) error {
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to %s in %s: %v", action, table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to count rows in %s: %v", table, err)
+		}
+		steps = append(steps, database.MergeStep{Table: table, Action: action, Rows: n})
+		return nil
+	}
+
+	if len(move) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(move)), ",")
+		err := exec(usermeta, "move meta to "+into,
+			fmt.Sprintf("UPDATE %s SET user_id = ? WHERE umeta_id IN (%s)", usermeta, placeholders),
+			append([]any{intoID}, move...)...)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		steps = append(steps, database.MergeStep{Table: usermeta, Action: "move meta to " + into})
+	}
+	if err := exec(usermeta, "drop remaining meta",
+		fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", usermeta), fromID); err != nil {
+		return nil, err
+	}
+	if err := exec(prefix+"_posts", "reassign post_author",
+		fmt.Sprintf("UPDATE %s_posts SET post_author = ? WHERE post_author = ?", prefix), intoID, fromID); err != nil {
+		return nil, err
+	}
+	if err := exec(prefix+"_users", "delete "+from,
+		fmt.Sprintf("DELETE FROM %s_users WHERE ID = ?", prefix), fromID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return steps, nil
+}
+
+// sanitizeNicename approximates sanitize_title for a login: lower case, with
+// runs of anything but letters, digits, '-' and '_' replaced by '-', and at
+// most 50 characters long like the user_nicename column.
+func sanitizeNicename(login string) string {
+	slug := regexp.MustCompile(`[^a-z0-9_-]+`).ReplaceAllString(strings.ToLower(login), "-")
+	slug = strings.Trim(slug, "-")
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	return slug
+}
+
+// uniqueNicename appends -2, -3, ... to nicename until no other user has it,
+// like wp_insert_user.
+func uniqueNicename(tx *sql.Tx, prefix, nicename string, id int64) (string, error) {
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s_users WHERE user_nicename = ? AND ID <> ?", prefix)
+	candidate := nicename
+	for suffix := 2; ; suffix++ {
+		var n int
+		if err := tx.QueryRow(q, candidate, id).Scan(&n); err != nil {
+			return "", fmt.Errorf("failed to check user_nicename: %v", err)
+		}
+		if n == 0 {
+			return candidate, nil
+		}
+		tail := fmt.Sprintf("-%d", suffix)
+		base := nicename
+		if len(base)+len(tail) > 50 {
+			base = base[:50-len(tail)]
+		}
+		candidate = base + tail
+	}
+}
+
+// ResetRequiredKey is the user meta key CreateUser sets to 1 to make the user
+// choose a new password at the next login. WordPress itself has no such flag,
+// a plugin or theme must honour the key; set it to the one yours reads.
+var ResetRequiredKey = "_password_reset_required"
+
+// LastLoginKey is the user meta key TouchLastLogin writes. WordPress itself
+// records no logins; this is the key login-tracking plugins commonly use.
+const LastLoginKey = "last_login"
+
+// TouchLastLogin sets the last_login meta of username to t, stored as a Unix
+// timestamp, and returns the previous value (nil when there was none).
+func TouchLastLogin(db *sql.DB, prefix, username string, t time.Time) (*time.Time, error) {
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if err := tx.QueryRow(fmt.Sprintf("SELECT ID FROM %s_users WHERE user_login = ?", prefix), username).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user %q not found", username)
+		}
+		return nil, fmt.Errorf("failed to read user: %v", err)
+	}
+
+	var previous *time.Time
+	var old string
+	q := fmt.Sprintf("SELECT meta_value FROM %s_usermeta WHERE user_id = ? AND meta_key = ?", prefix)
+	switch err := tx.QueryRow(q, id, LastLoginKey).Scan(&old); {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, fmt.Errorf("failed to read %s: %v", LastLoginKey, err)
+	default:
+		if ts, err := strconv.ParseInt(old, 10, 64); err == nil {
+			p := time.Unix(ts, 0).UTC()
+			previous = &p
+		}
+	}
+
+	if err := setUserMeta(tx, prefix, id, LastLoginKey, strconv.FormatInt(t.Unix(), 10)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return previous, nil
+}
+
+// LockStale strips the roles of every user with a role but no activity since
+// cutoff, which is how WordPress accounts are blocked. Activity is the newest
+// of the registration, the last_login meta and the logins of the session
+// tokens. Administrators are skipped unless includeAdmins is set, and even
+// then the last one is kept through database.Guard. With dryRun nothing is
+// written. It returns the affected users.
+func LockStale(ctx context.Context, db *sql.DB, prefix string, cutoff time.Time, includeAdmins, dryRun bool) ([]database.StaleUser, error) {
+	admins, err := privilegedRoles(db, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT u.ID, u.user_login, u.user_email, u.user_registered, c.meta_value, l.meta_value, t.meta_value
+		FROM %[1]s_users u
+		JOIN %[1]s_usermeta c ON c.user_id = u.ID AND c.meta_key = '%[1]s_capabilities'
+		LEFT JOIN %[1]s_usermeta l ON l.user_id = u.ID AND l.meta_key = ?
+		LEFT JOIN %[1]s_usermeta t ON t.user_id = u.ID AND t.meta_key = 'session_tokens'
+		WHERE u.user_registered < ?
+		ORDER BY u.ID`, prefix), LastLoginKey, cutoff.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+
+	loginRe := regexp.MustCompile(`s:5:"login";i:(\d+);`)
+	var stale []database.StaleUser
+	for rows.Next() {
+		var u database.StaleUser
+		var registered database.NullTime
+		var capabilities string
+		var lastLogin, tokens sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &registered, &capabilities, &lastLogin, &tokens); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		u.LastActive = registered.Time
+		if capabilities == "" {
+			continue
+		}
+		granted, err := ParseCapabilities(capabilities)
+		if err != nil {
+			if err := database.Tolerate(fmt.Errorf("user %s: %w", u.Username, err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		hasRole := false
+		for role, ok := range granted {
+			if ok {
+				hasRole = true
+				u.Admin = u.Admin || slices.Contains(admins, role)
+			}
+		}
+		if !hasRole {
+			continue // already blocked
+		}
+
+		newest := u.LastActive.Unix()
+		if ts, err := strconv.ParseInt(lastLogin.String, 10, 64); err == nil && ts > newest {
+			newest = ts
+		}
+		for _, m := range loginRe.FindAllStringSubmatch(tokens.String, -1) {
+			if ts, err := strconv.ParseInt(m[1], 10, 64); err == nil && ts > newest {
+				newest = ts
+			}
+		}
+		u.LastActive = time.Unix(newest, 0).UTC()
+		if !u.LastActive.Before(cutoff) || (u.Admin && !includeAdmins) {
+			continue
+		}
+		stale = append(stale, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+	if dryRun || len(stale) == 0 {
+		return stale, nil
+	}
+
+	if includeAdmins {
+		all, err := ListAdmins(ctx, db, prefix)
+		if err != nil {
+			return nil, err
+		}
+		locked := 0
+		for _, u := range stale {
+			if u.Admin {
+				locked++
+			}
+		}
+		if locked > 0 && locked >= len(all) {
+			if err := database.Guard("this change removes the last administrator"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, u := range stale {
+		if err := setUserMeta(tx, prefix, u.ID, prefix+"_capabilities", "a:0:{}"); err != nil {
+			return nil, err
+		}
+		if err := setUserMeta(tx, prefix, u.ID, prefix+"_user_level", "0"); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return stale, nil
+}
+
+// setUserMeta updates the meta key of a user, inserting the row when the
+// user does not have it yet.
+func setUserMeta(tx *sql.Tx, prefix string, userID any, key, value string) error {
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %s_usermeta SET meta_value = ? WHERE user_id = ? AND meta_key = ?", prefix),
+		value, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to update user meta %s: %v", key, err)
+	}
+	// MySQL reports rows changed, not matched, so an unchanged value also
+	// gives 0; only insert when the row is really missing
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	var exists int
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s_usermeta WHERE user_id = ? AND meta_key = ?", prefix)
+	if err := tx.QueryRow(q, userID, key).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to read user meta %s: %v", key, err)
+	}
+	if exists > 0 {
+		return nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s_usermeta (user_id, meta_key, meta_value) VALUES (?, ?, ?)", prefix),
+		userID, key, value); err != nil {
+		return fmt.Errorf("failed to add user meta %s: %v", key, err)
+	}
+	return nil
+}
+
+// EmailChange describes a single user e-mail rewrite.
+type EmailChange struct {
+	ID       string
+	Username string
+	Old      string
+	New      string
+}
+
+// RewriteEmailDomain replaces the from suffix of every matching user e-mail with to,
+// e.g. "@old.com" → "@new.com". All updates run in one transaction; with dryRun
+// nothing is written and the planned changes are returned.
+func RewriteEmailDomain(db *sql.DB, prefix, from, to string, dryRun bool) ([]EmailChange, error) {
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT ID, user_login, user_email FROM %s_users WHERE user_email LIKE ?", prefix),
+		"%"+database.EscapeLike(from))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find matching users: %v", err)
+	}
+	defer rows.Close()
+
+	var changes []EmailChange
+	for rows.Next() {
+		var c EmailChange
+		if err := rows.Scan(&c.ID, &c.Username, &c.Old); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		// LIKE is case-insensitive under the default collations, so compare the same way
+		if !strings.HasSuffix(strings.ToLower(c.Old), strings.ToLower(from)) {
+			continue
+		}
+		c.New = c.Old[:len(c.Old)-len(from)] + to
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", err)
+	}
+
+	return changes, applyEmailChanges(db, prefix, changes, dryRun)
+}
+
+// RewriteEmails applies exact old → new e-mail rewrites from mapping in one transaction.
+// Addresses that match no user are ignored.
+func RewriteEmails(db *sql.DB, prefix string, mapping map[string]string, dryRun bool) ([]EmailChange, error) {
+	olds := make([]string, 0, len(mapping))
+	for old := range mapping {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+
+	var changes []EmailChange
+	q := fmt.Sprintf("SELECT ID, user_login, user_email FROM %s_users WHERE user_email = ?", prefix)
+	for _, old := range olds {
+		rows, err := db.Query(q, old)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find users with e-mail %s: %v", old, err)
+		}
+		for rows.Next() {
+			c := EmailChange{New: mapping[old]}
+			if err := rows.Scan(&c.ID, &c.Username, &c.Old); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan row: %v", err)
+			}
+			changes = append(changes, c)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rows: %v", err)
+		}
+	}
+
+	return changes, applyEmailChanges(db, prefix, changes, dryRun)
+}
+
+// applyEmailChanges writes the e-mail changes in a single transaction. Every
+// new address is validated first, also in a dry run, so nothing is written
+// when one of them is malformed.
+func applyEmailChanges(db *sql.DB, prefix string, changes []EmailChange, dryRun bool) error {
+	for _, c := range changes {
+		if err := database.ValidateEmail(c.New); err != nil {
+			return fmt.Errorf("%s: %v", c.Username, err)
+		}
+	}
+	if dryRun || len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := database.Begin(db)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	q := fmt.Sprintf("UPDATE %s_users SET user_email = ? WHERE ID = ?", prefix)
+	for _, c := range changes {
+		if _, err := tx.Exec(q, c.New, c.ID); err != nil {
+			return fmt.Errorf("failed to update e-mail of %s: %v", c.Username, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// OpenWordPress reads the WordPress configuration, connects to its database and
+// identifies the table prefixes. The caller must close the returned database.
+func OpenWordPress(cmsPath string) (*sql.DB, database.DBConfig, []string, error) {
+	start := time.Now()
+	configPath := ConfigPath(cmsPath)
+	config, err := ExtractDBConfig(configPath)
+	if err != nil {
+		return nil, config, nil, fmt.Errorf("failed to extract WordPress DB config: %v", err)
+	}
+	database.Phase("extract config", start)
+
+	db, err := database.Connect(config)
+	if err != nil {
+		return nil, config, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	start = time.Now()
+	prefixes, err := IdentifyPrefixes(db, config.Type)
+	if err != nil {
+		db.Close()
+		return nil, config, nil, fmt.Errorf("failed to identify WordPress prefixes: %v", err)
+	}
+	database.Phase("identify prefixes", start)
+	if len(prefixes) == 0 {
+		db.Close()
+		return nil, config, nil, &database.NoTablesError{CMS: "WordPress", DBName: config.DBName, Config: configPath}
+	}
+
+	return db, config, prefixes, nil
+}
+
+func ProcessWordPress(ctx context.Context, cmsPath string, filter database.UserFilter) error {
+	db, config, prefixes, err := OpenWordPress(cmsPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Printf("WordPress DB Name: %s\n", config.DBName)
+	fmt.Printf("WordPress DB User: %s\n", config.User)
+	fmt.Printf("Identified WordPress table prefixes: %v\n", prefixes)
+
+	for _, prefix := range prefixes {
+		fmt.Printf("WordPress Users for prefix '%s':\n", prefix)
+		// the privileged roles are only looked up when they are shown
+		var privileged []string
+		if database.Color && !filter.NoRoles {
+			if privileged, err = privilegedRoles(db, prefix); err != nil {
+				return err
+			}
+		}
+		err := ListUsersFunc(ctx, db, prefix, filter, func(user UserDetail) error {
+			user.Email = database.DisplayEmail(user.Email)
+			if filter.NoRoles {
+				fmt.Printf("ID: %d, Username: %s, Email: %s, Name: %s\n",
+					user.ID, user.Username, user.Email, user.Name)
+				return nil
+			}
+			if granted, err := ParseCapabilities(user.capabilities); err == nil {
+				for _, r := range privileged {
+					if granted[r] {
+						user.Role = database.HighlightPrivileged(user.Role)
+						break
+					}
+				}
+			}
+			fmt.Printf("ID: %d, Username: %s, Email: %s, Role: %s, Name: %s %s, Nickname: %s\n",
+				user.ID, user.Username, user.Email, user.Role,
+				user.FirstName, user.LastName, user.Nickname)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list WordPress users for prefix %s: %w", prefix, err)
+		}
+	}
+
+	return nil
+}
+
+func ShowInfo(cmsPath string) error {
+	info, err := Info(cmsPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("WordPress Information:")
+	fmt.Printf("DB Type: %s\n", info.DBType)
+	fmt.Printf("DB Name: %s\n", info.DBName)
+	fmt.Printf("DB User: %s\n", info.DBUser)
+	fmt.Printf("DB Host: %s\n", info.DBHost)
+	fmt.Printf("DB Port: %d\n", info.DBPort)
+	fmt.Printf("DB Server: %s\n", info.Server)
+	fmt.Printf("Table Prefixes: %v\n", info.Prefixes)
+
+	return nil
+}
+
+// Info collects the database settings, server and table prefixes of the
+// install, and its version when the version file can be read.
+func Info(cmsPath string) (database.InfoReport, error) {
+	info := database.InfoReport{CMS: "wordpress"}
+	configPath := ConfigPath(cmsPath)
+	config, err := ExtractDBConfig(configPath)
+	if err != nil {
+		return info, fmt.Errorf("failed to extract WordPress DB config: %v", err)
+	}
+
+	db, err := database.Connect(config)
+	if err != nil {
+		return info, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	prefixes, err := IdentifyPrefixes(db, config.Type)
+	if err != nil {
+		return info, fmt.Errorf("failed to identify WordPress prefixes: %v", err)
+	}
+
+	info.DBType = config.Type
+	info.DBName = config.DBName
+	info.DBUser = config.User
+	info.DBHost = config.Host
+	info.DBPort = config.Port
+	info.Server = database.DescribeServer(db)
+	info.Prefixes = prefixes
+	if info.Version, err = GetVersion(cmsPath); err != nil {
+		if err := database.Warnf("%v", err); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+func EditUser(ctx context.Context, db *sql.DB, prefix, username string, assumeYes bool) error {
+	if err := database.Writable(); err != nil {
+		return err
+	}
+
+	user, err := GetUserByUsername(ctx, db, prefix, username)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %v", err)
+	}
+
+	// only the fields UpdateUser writes, in a stable order
+	fields := []string{"Email", "Name", "FirstName", "LastName", "Nickname"}
+
+	fmt.Println("Current user details:")
+	for _, key := range fields {
+		if value, ok := user[key]; ok {
+			fmt.Printf("%s: %s\n", key, value)
+		} else {
+			fmt.Printf("%s: (not set)\n", key)
+		}
+	}
+
+	// meta fields the user has no row for yet are offered too; UpdateUser
+	// creates the rows
+	reader := bufio.NewReader(os.Stdin)
+	var changes []database.FieldChange
+	for _, key := range fields {
+		old := user[key]
+		fmt.Printf("Enter new %s (or press Enter to keep current value): ", key)
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input != "" && input != old {
+			if key == "Email" {
+				if err := database.ValidateEmail(input); err != nil {
+					return err
+				}
+			}
+			user[key] = input
+			changes = append(changes, database.FieldChange{Field: key, Old: old, New: input})
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	ok, err := database.ConfirmChanges(reader, changes, assumeYes)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println("Aborted, nothing was changed.")
+		return nil
 	}
 
-	if err := UpdateUser(db, user); err != nil {
-		return fmt.Errorf("failed to update user: %v", err)
+	if _, err := UpdateUser(db, prefix, user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
 	}
 
 	fmt.Println("User updated successfully")
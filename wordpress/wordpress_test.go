@@ -0,0 +1,355 @@
+package wordpress
+
+import (
+	"cmsmgmt/database"
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// userRoles is a wp_user_roles option with one administrative role.
+const userRoles = `a:2:{s:13:"administrator";a:2:{s:4:"name";s:13:"Administrator";s:12:"capabilities";a:1:{s:14:"manage_options";b:1;}}` +
+	`s:6:"editor";a:2:{s:4:"name";s:6:"Editor";s:12:"capabilities";a:1:{s:10:"edit_posts";b:1;}}}`
+
+func q(sql string) string { return regexp.QuoteMeta(sql) }
+
+// expectAdmins expects the lookup of the administrators, of which the user
+// with adminID is the only one.
+func expectAdmins(mock sqlmock.Sqlmock, adminID int64) {
+	mock.ExpectQuery(q("SELECT option_value FROM wp_options WHERE option_name = 'wp_user_roles'")).
+		WillReturnRows(sqlmock.NewRows([]string{"option_value"}).AddRow(userRoles))
+	mock.ExpectQuery(q("FROM wp_users u")).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "user_login", "user_email", "c", "t"}).
+			AddRow(adminID, "admin", "admin@example.com", `a:1:{s:13:"administrator";b:1;}`, nil))
+}
+
+// expectMergeReads expects the reads MergeUsers makes before changing
+// anything: dup (5) is merged into alice (3), who already has a nickname.
+func expectMergeReads(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(q("SELECT ID FROM wp_users WHERE user_login = ?")).WithArgs("dup").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(5))
+	mock.ExpectQuery(q("SELECT ID FROM wp_users WHERE user_login = ?")).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(3))
+	mock.ExpectQuery(q("SELECT meta_key FROM wp_usermeta WHERE user_id = ?")).WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"meta_key"}).AddRow("nickname"))
+	mock.ExpectQuery(q("SELECT umeta_id, meta_key FROM wp_usermeta WHERE user_id = ?")).WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"umeta_id", "meta_key"}).
+			AddRow(10, "nickname").AddRow(11, "first_name").AddRow(12, "session_tokens"))
+}
+
+var mergeSteps = []database.MergeStep{
+	{Table: "wp_usermeta", Action: "move meta to alice", Rows: 1},
+	{Table: "wp_usermeta", Action: "drop remaining meta", Rows: 2},
+	{Table: "wp_posts", Action: "reassign post_author", Rows: 7},
+	{Table: "wp_users", Action: "delete dup", Rows: 1},
+}
+
+func TestMergeUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expectAdmins(mock, 1)
+	mock.ExpectBegin()
+	expectMergeReads(mock)
+	mock.ExpectExec(q("UPDATE wp_usermeta SET user_id = ? WHERE umeta_id IN (?)")).
+		WithArgs(int64(3), int64(11)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("DELETE FROM wp_usermeta WHERE user_id = ?")).
+		WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q("UPDATE wp_posts SET post_author = ? WHERE post_author = ?")).
+		WithArgs(int64(3), int64(5)).WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectExec(q("DELETE FROM wp_users WHERE ID = ?")).
+		WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	steps, err := MergeUsers(context.Background(), db, "wp", "dup", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(steps, mergeSteps) {
+		t.Errorf("steps = %+v, want %+v", steps, mergeSteps)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// A dry run must not send a single write: MyISAM tables cannot roll one back.
+func TestMergeUsersDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.ReadOnly = true
+	defer func() { database.ReadOnly = false }()
+
+	expectAdmins(mock, 1)
+	expectMergeReads(mock)
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM wp_posts WHERE post_author = ?")).WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(7))
+
+	steps, err := MergeUsers(context.Background(), db, "wp", "dup", "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(steps, mergeSteps) {
+		t.Errorf("steps = %+v, want %+v", steps, mergeSteps)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeUsersLastAdmin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expectAdmins(mock, 5)
+	expectMergeReads(mock)
+	_, err = MergeUsers(context.Background(), db, "wp", "dup", "alice", true)
+	if err == nil || !regexp.MustCompile(`dup is the last administrator`).MatchString(err.Error()) {
+		t.Errorf("err = %v, want the last administrator refused", err)
+	}
+}
+
+func TestMergeUsersRefused(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		from, to  int64
+		wantErr   error
+		wantMatch string
+	}{
+		{"unknown", 0, 3, database.ErrUserNotFound, ""},
+		{"same user", 3, 3, nil, "are the same user"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(q("SELECT option_value FROM wp_options")).
+				WillReturnRows(sqlmock.NewRows([]string{"option_value"}).AddRow(userRoles))
+			mock.ExpectQuery(q("FROM wp_users u")).
+				WillReturnRows(sqlmock.NewRows([]string{"ID", "user_login", "user_email", "c", "t"}))
+			from := sqlmock.NewRows([]string{"ID"})
+			if tt.from != 0 {
+				from.AddRow(tt.from)
+			}
+			mock.ExpectQuery(q("SELECT ID FROM wp_users")).WillReturnRows(from)
+			mock.ExpectQuery(q("SELECT ID FROM wp_users")).WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(tt.to))
+
+			_, err = MergeUsers(context.Background(), db, "wp", "dup", "alice", true)
+			switch {
+			case err == nil:
+				t.Fatal("merge was not refused")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			case tt.wantMatch != "" && !regexp.MustCompile(tt.wantMatch).MatchString(err.Error()):
+				t.Errorf("err = %v, want %q", err, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestKeepSingleAdminDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.ReadOnly = true
+	defer func() { database.ReadOnly = false }()
+
+	for range 3 { // privileged roles, role definitions, admins
+		mock.ExpectQuery(q("SELECT option_value FROM wp_options")).
+			WillReturnRows(sqlmock.NewRows([]string{"option_value"}).AddRow(userRoles))
+	}
+	admin := `a:1:{s:13:"administrator";b:1;}`
+	mock.ExpectQuery(q("FROM wp_users u")).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "user_login", "user_email", "c", "t"}).
+			AddRow(1, "admin", "admin@example.com", admin, nil).
+			AddRow(2, "bob", "bob@example.com", admin, nil))
+
+	demoted, err := KeepSingleAdmin(context.Background(), db, "wp", "admin", "editor", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(demoted) != 1 || demoted[0].Username != "bob" {
+		t.Errorf("demoted = %+v, want bob", demoted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetRolesDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.ReadOnly = true
+	defer func() { database.ReadOnly = false }()
+
+	mock.ExpectQuery(q("SELECT option_value FROM wp_options")).
+		WillReturnRows(sqlmock.NewRows([]string{"option_value"}).AddRow(userRoles))
+	mock.ExpectQuery(q("SELECT u.ID, m.meta_value FROM wp_users u")).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "meta_value"}).AddRow(3, `a:1:{s:6:"editor";b:1;}`))
+
+	id, old, err := SetRoles(db, "wp", "alice", []string{"administrator"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 {
+		t.Errorf("id = %d, want 3", id)
+	}
+	if !reflect.DeepEqual(old, []string{"editor"}) {
+		t.Errorf("old roles = %v, want [editor]", old)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtractDBConfig(t *testing.T) {
+	t.Setenv("WORDPRESS_DB_PORT", "3312")
+	for _, tt := range []struct {
+		file string
+		port int
+	}{
+		{"wp-config.php", 3307},             // from DB_HOST
+		{"wp-config-port-string.php", 3310}, // DB_PORT wins over DB_HOST
+		{"wp-config-port-int.php", 3311},
+		{"wp-config-port-env.php", 3312},
+		{"wp-config-minified.php", 3307}, // all on one line, no spaces
+	} {
+		config, err := ExtractDBConfig("testdata/" + tt.file)
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		want := database.DBConfig{Type: "mysql", Host: "db.example.com", Port: tt.port, DBName: "wordpress",
+			User: "wp_user", Password: "It's a secret", Charset: database.DefaultCharset, ParseTime: true}
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.file, config, want)
+		}
+	}
+}
+
+func TestExtractDBConfigBadPort(t *testing.T) {
+	t.Setenv("WORDPRESS_DB_PORT", "abc")
+	if _, err := ExtractDBConfig("testdata/wp-config-port-env.php"); err == nil {
+		t.Error("a DB_PORT that is not a number was accepted")
+	}
+}
+
+func TestSetUserMeta(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		updated int64 // rows the UPDATE reports
+		exists  int   // rows the COUNT finds, when it runs
+		insert  bool
+	}{
+		{"changed", 1, 0, false},
+		{"unchanged", 0, 1, false},
+		{"missing", 0, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(q("UPDATE wp_usermeta SET meta_value = ? WHERE user_id = ? AND meta_key = ?")).
+				WithArgs("Jane", int64(3), "first_name").WillReturnResult(sqlmock.NewResult(0, tt.updated))
+			if tt.updated == 0 {
+				mock.ExpectQuery(q("SELECT COUNT(*) FROM wp_usermeta WHERE user_id = ? AND meta_key = ?")).
+					WithArgs(int64(3), "first_name").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(tt.exists))
+			}
+			if tt.insert {
+				mock.ExpectExec(q("INSERT INTO wp_usermeta (user_id, meta_key, meta_value) VALUES (?, ?, ?)")).
+					WithArgs(int64(3), "first_name", "Jane").WillReturnResult(sqlmock.NewResult(20, 1))
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := setUserMeta(tx, "wp", int64(3), "first_name", "Jane"); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// The meta join must only read the keys the listing shows, see ListUsersFunc.
+func TestListUsersMetaKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(q("LEFT JOIN wp_usermeta m ON u.ID = m.user_id\n\t\t     AND m.meta_key IN ('wp_capabilities', 'first_name', 'last_name', 'nickname')")).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "user_login", "user_email", "display_name", "capabilities", "first_name", "last_name", "nickname"}).
+			AddRow(1, "admin", "admin@example.com", "Admin", `a:1:{s:13:"administrator";b:1;}`, "Ada", "Min", "admin"))
+
+	users, err := ListUsers(context.Background(), db, "wp", database.UserFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Role != "Administrator" || users[0].FirstName != "Ada" {
+		t.Errorf("users = %+v, want the administrator Ada", users)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// With --db-parse-time=false the MySQL driver hands user_registered over as text.
+func TestLockStaleTextDates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(q("SELECT option_value FROM wp_options WHERE option_name = 'wp_user_roles'")).
+		WillReturnRows(sqlmock.NewRows([]string{"option_value"}).AddRow(userRoles))
+	mock.ExpectQuery(q("SELECT u.ID, u.user_login, u.user_email, u.user_registered")).WithArgs(LastLoginKey, cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "user_login", "user_email", "user_registered", "c", "l", "t"}).
+			AddRow(4, "bob", "bob@example.com", []byte("2020-01-02 03:04:05"), `a:1:{s:6:"editor";b:1;}`, nil, nil))
+
+	stale, err := LockStale(context.Background(), db, "wp", cutoff, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []database.StaleUser{
+		{ID: 4, Username: "bob", Email: "bob@example.com", LastActive: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("got %+v, want %+v", stale, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}